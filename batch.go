@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"image2bytes/bitmap"
+)
+
+// Names of the index map and its element type in batch output. Assets are
+// renamed rather than collide with them.
+const (
+	indexName     = "Bitmaps"
+	indexTypeName = "Bitmap"
+	indexFileName = "bitmaps.go"
+)
+
+// isBatchInput reports whether the input names a directory or a glob pattern
+// rather than a single file.
+func isBatchInput(path string) bool {
+	if hasMeta(path) {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// hasMeta reports whether path holds glob metacharacters.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// batchInputs lists the PNG files of a directory or matching a glob pattern, sorted.
+func batchInputs(pattern string) ([]string, error) {
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		pattern = filepath.Join(pattern, "*")
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, path := range matches {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && isPNGFile(path) {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no PNG files match %s", pattern)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// assetNames derives a unique Go identifier for every input from its file name,
+// numbering repeats: "icon-1.png" and "icon_1.png" become Icon1 and Icon12.
+func assetNames(paths []string, cfg convertConfig) []string {
+	names := make([]string, len(paths))
+	cfgs := make([]convertConfig, len(paths))
+	for i, path := range paths {
+		names[i], cfgs[i] = identifierFromPath(path), cfg
+	}
+	return uniqueNames(names, cfgs)
+}
+
+// uniqueNames numbers the names that repeat an earlier one. Names are unique
+// regardless of case, so they also make unique file names on case-insensitive
+// file systems, and no asset constant can clash with another asset or with the
+// index. The constants of each candidate depend on its configuration in cfgs.
+func uniqueNames(candidates []string, cfgs []convertConfig) []string {
+	taken := map[string]bool{strings.ToLower(indexName): true, strings.ToLower(indexTypeName): true}
+	clashes := func(name string, suffixes []string) bool {
+		if taken[strings.ToLower(name)] {
+			return true
+		}
+		for _, suffix := range suffixes {
+			if taken[strings.ToLower(name+suffix)] {
+				return true
+			}
+		}
+		return false
+	}
+
+	names := make([]string, len(candidates))
+	for i, base := range candidates {
+		suffixes := bitmap.AssetSuffixes(cfgs[i].data, cfgs[i].mask)
+		name := base
+		for n := 2; clashes(name, suffixes); n++ {
+			name = base + strconv.Itoa(n)
+		}
+		taken[strings.ToLower(name)] = true
+		for _, suffix := range suffixes {
+			taken[strings.ToLower(name+suffix)] = true
+		}
+		names[i] = name
+	}
+	return names
+}
+
+// planBatch lists every PNG file of a directory or glob, to be converted with
+// the same options and written with writeAssets.
+func planBatch(pattern, outputPath string, cfg convertConfig) (*plan, error) {
+	paths, err := batchInputs(pattern)
+	if err != nil {
+		return nil, &stageError{stage: "read", path: pattern, err: err}
+	}
+	if err := checkKeys(paths); err != nil {
+		return nil, err
+	}
+
+	p := &plan{output: outputPath, pkg: cfg.pkg, sources: []string{patternDir(pattern)}}
+	p.command = p.convertCommand(pattern, cfg)
+	for i, name := range assetNames(paths, cfg) {
+		p.entries = append(p.entries, planEntry{input: paths[i], name: name, cfg: cfg})
+	}
+	return p, nil
+}
+
+// checkKeys rejects inputs that share a file name, since file names key the index.
+func checkKeys(paths []string) error {
+	keys := map[string]string{}
+	for _, path := range paths {
+		if other, ok := keys[filepath.Base(path)]; ok {
+			return &stageError{stage: "read", path: path, err: fmt.Errorf("same file name as %s", other)}
+		}
+		keys[filepath.Base(path)] = path
+	}
+	return nil
+}
+
+// convertBatchAsset converts one image of a batch, writing its panel preview
+// next to the batch output.
+func convertBatchAsset(inputPath, name, outputPath string, cfg convertConfig, cache *buildCache, stdout io.Writer) (bitmap.Asset, error) {
+	fmt.Fprintf(stdout, "Converting %s as %s\n", inputPath, name)
+	asset, err := convertAsset(inputPath, name, cfg, cache, stdout)
+	if err != nil {
+		return bitmap.Asset{}, err
+	}
+	dir := outputPath
+	if isGoFile(outputPath) {
+		dir = filepath.Dir(outputPath)
+	}
+	if cfg.panelPNG {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return bitmap.Asset{}, &stageError{stage: "write", path: dir, err: err}
+		}
+	}
+	previewPath := filepath.Join(dir, strings.ToLower(name)+".preview.png")
+	return asset, writePanelPreview(previewPath, asset.Bitmap, cfg, stdout)
+}
+
+// writeAssets writes the converted entries and their index, creating
+// directories as needed. When the output is a Go file it holds everything;
+// otherwise it is a directory that gets one Go file per image and the index in
+// bitmaps.go, and the files of images that are gone are removed. The header of
+// each file lists the images it was made from.
+func (p *plan) writeAssets(assets []bitmap.Asset, stdout io.Writer) error {
+	h, err := p.header(p.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.dir(), 0o755); err != nil {
+		return &stageError{stage: "write", path: p.dir(), err: err}
+	}
+
+	if isGoFile(p.output) {
+		err = writeGoFile(p.output, assets, func(w io.Writer) error {
+			h.write(w)
+			return bitmap.WriteGoAssets(w, p.pkg, assets, indexName)
+		})
+	} else {
+		// Files of images that are gone carry the command of the old index
+		commands := map[string]bool{p.command: true}
+		if old, err := readHeader(p.mainOutput()); err == nil && old != nil {
+			commands[old.command] = true
+		}
+		for i, a := range assets {
+			path := filepath.Join(p.output, strings.ToLower(a.Name)+".go")
+			ah, err := p.header(p.entries[i : i+1])
+			if err != nil {
+				return err
+			}
+			if err := generateGoFile(path, ah, a.Bitmap, bitmap.GoOptions{Package: p.pkg, Name: a.Name, Compression: a.Compression, Data: a.Data, Mask: a.Mask}); err != nil {
+				return &stageError{stage: "write", path: path, err: err}
+			}
+		}
+		err = writeFile(p.mainOutput(), func(w io.Writer) error {
+			h.write(w)
+			return bitmap.WriteGoIndex(w, p.pkg, assets, indexName)
+		})
+		if err == nil {
+			err = p.removeStaleAssets(assets, commands, stdout)
+		}
+	}
+	if err != nil {
+		return &stageError{stage: "write", path: p.mainOutput(), err: err}
+	}
+
+	noun := "images"
+	if len(assets) == 1 {
+		noun = "image"
+	}
+	fmt.Fprintf(stdout, "Done. %d %s written to %s\n", len(assets), noun, p.output)
+	return nil
+}
+
+// removeStaleAssets removes the files a batch wrote to its output directory
+// for images that are no longer among assets: generated Go files made by one
+// of commands, with the data they embed and their panel previews. Other files
+// are left alone.
+func (p *plan) removeStaleAssets(assets []bitmap.Asset, commands map[string]bool, stdout io.Writer) error {
+	keep := map[string]bool{indexFileName: true}
+	for _, a := range assets {
+		keep[strings.ToLower(a.Name)+".go"] = true
+	}
+	paths, err := filepath.Glob(filepath.Join(p.output, "*.go"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if keep[filepath.Base(path)] {
+			continue
+		}
+		h, err := readHeader(path)
+		if err != nil || h == nil || !commands[h.command] {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		related := []string{strings.TrimSuffix(path, ".go") + ".preview.png"}
+		for _, line := range strings.Split(string(content), "\n") {
+			if name, ok := strings.CutPrefix(line, "//go:embed "); ok {
+				related = append(related, filepath.Join(p.output, name))
+			}
+		}
+		for _, r := range related {
+			if err := os.Remove(r); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Removed %s\n", path)
+	}
+	return nil
+}
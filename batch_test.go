@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"image2bytes/bitmap"
+)
+
+func TestIsBatchInput(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{path: dir, expected: true},
+		{path: filepath.Join(dir, "*.png"), expected: true},
+		{path: "icons/arrow-[lr].png", expected: true},
+		{path: filepath.Join(dir, "logo.png"), expected: false},
+	}
+	for _, tt := range tests {
+		if got := isBatchInput(tt.path); got != tt.expected {
+			t.Errorf("isBatchInput(%q) = %v, expected %v", tt.path, got, tt.expected)
+		}
+	}
+}
+
+func TestBatchInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.png", "a.PNG", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub.png"), 0o755); err != nil {
+		t.Fatalf("Failed to create test dir: %v", err)
+	}
+
+	paths, err := batchInputs(dir)
+	if err != nil {
+		t.Fatalf("batchInputs failed: %v", err)
+	}
+	expected := []string{filepath.Join(dir, "a.PNG"), filepath.Join(dir, "b.png")}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+
+	paths, err = batchInputs(filepath.Join(dir, "b*"))
+	if err != nil || len(paths) != 1 {
+		t.Errorf("Expected only b.png, got %v (%v)", paths, err)
+	}
+
+	if _, err := batchInputs(filepath.Join(dir, "*.gif")); err == nil {
+		t.Errorf("Expected an error when nothing matches, got nil")
+	}
+}
+
+func TestAssetNames(t *testing.T) {
+	names := assetNames([]string{
+		"icons/icon-1.png",
+		"icons/icon_1.png",
+		"icons/ICON1.png",
+		"icons/bitmaps.png",
+		"icons/logo.png",
+		"icons/logo width.png",
+		"icons/7seg.png",
+		"icons/logo-mask.png",
+	}, convertConfig{})
+	expected := []string{"Icon1", "Icon12", "ICON13", "Bitmaps2", "Logo", "LogoWidth2", "Image7seg", "LogoMask"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+
+	// With masks, the mask of Logo takes the name
+	names = assetNames([]string{"logo.png", "logo-mask.png"}, convertConfig{mask: true})
+	if expected := []string{"Logo", "LogoMask2"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v, got %v", expected, names)
+	}
+}
+
+// typeCheck parses and type-checks the Go files of a generated package
+func typeCheck(t *testing.T, paths ...string) {
+	t.Helper()
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, path := range paths {
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", path, err)
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("assets", fset, files, nil); err != nil {
+		t.Fatalf("Generated package does not compile: %v", err)
+	}
+}
+
+func TestConvertBatch(t *testing.T) {
+	inputDir := t.TempDir()
+	writeCheckerboardPNG(t, filepath.Join(inputDir, "arrow-left.png"), 8, 8)
+	writeCheckerboardPNG(t, filepath.Join(inputDir, "arrow_left.png"), 4, 4)
+	writeCheckerboardPNG(t, filepath.Join(inputDir, "logo.png"), 16, 8)
+
+	t.Run("combined", func(t *testing.T) {
+		outputPath := filepath.Join(t.TempDir(), "icons.go")
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-width", "0", "-height", "0", "-package", "icons", "-codec", "auto", inputDir, outputPath}, &stdout, &stderr)
+		if code != exitOK {
+			t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+		}
+		typeCheck(t, outputPath)
+
+		content, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("Failed to read generated file: %v", err)
+		}
+		for _, expected := range []string{
+			"package icons\n",
+			"var ArrowLeft = []byte{",
+			"var ArrowLeft2 = []byte{",
+			"var Logo = []byte{",
+			"var Bitmaps = map[string]Bitmap{",
+			"\t\"arrow_left.png\": {\n\t\tWidth: ArrowLeft2Width, Height: ArrowLeft2Height,",
+		} {
+			if !strings.Contains(string(content), expected) {
+				t.Errorf("Generated file does not contain %q", expected)
+			}
+		}
+
+		// Each array can still be decoded on its own
+		bm, err := loadBitmap(outputPath, decodeOptions{name: "Logo"})
+		if err != nil {
+			t.Fatalf("loadBitmap failed: %v", err)
+		}
+		if bm.Width != 16 || bm.Height != 8 {
+			t.Errorf("Expected 16x8, got %dx%d", bm.Width, bm.Height)
+		}
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		outputDir := filepath.Join(t.TempDir(), "icons")
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-width", "0", "-height", "0", "-package", "icons", filepath.Join(inputDir, "*.png"), outputDir}, &stdout, &stderr)
+		if code != exitOK {
+			t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+		}
+
+		var paths []string
+		for _, name := range []string{"arrowleft.go", "arrowleft2.go", "logo.go", indexFileName} {
+			paths = append(paths, filepath.Join(outputDir, name))
+		}
+		typeCheck(t, paths...)
+	})
+
+	t.Run("summary", func(t *testing.T) {
+		inputDir := t.TempDir()
+		writeCheckerboardPNG(t, filepath.Join(inputDir, "a.png"), 4, 4)
+		outputDir := filepath.Join(t.TempDir(), "icons")
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"-width", "0", "-height", "0", "-package", "icons", inputDir, outputDir}, &stdout, &stderr); code != exitOK {
+			t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+		}
+		if want := "Done. 1 image written to " + outputDir + "\n"; !strings.HasSuffix(stdout.String(), want) {
+			t.Errorf("Expected %q, got %q", want, stdout.String())
+		}
+	})
+
+	t.Run("directory removes gone images", func(t *testing.T) {
+		inputDir := t.TempDir()
+		for _, name := range []string{"a.png", "b.png", "c.png"} {
+			writeCheckerboardPNG(t, filepath.Join(inputDir, name), 4, 4)
+		}
+		outputDir := filepath.Join(t.TempDir(), "icons")
+		convert := func(args ...string) {
+			t.Helper()
+			var stdout, stderr bytes.Buffer
+			args = append([]string{"-width", "0", "-height", "0", "-package", "icons", "-data", "embed", "-preview-png"}, args...)
+			if code := run(append(args, inputDir, outputDir), &stdout, &stderr); code != exitOK {
+				t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+			}
+		}
+		convert()
+
+		// Files the batch did not write are left alone
+		handWritten := filepath.Join(outputDir, "doc.go")
+		if err := os.WriteFile(handWritten, []byte("// Package icons holds icons.\npackage icons\n"), 0o644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		other := filepath.Join(outputDir, "other.go")
+		if code := run([]string{"-width", "0", "-height", "0", "-package", "icons", "-out", other, filepath.Join(inputDir, "c.png")}, io.Discard, io.Discard); code != exitOK {
+			t.Fatalf("Expected exit code %d, got %d", exitOK, code)
+		}
+
+		// A deleted image loses its file, data and preview, even when the options changed
+		if err := os.Remove(filepath.Join(inputDir, "b.png")); err != nil {
+			t.Fatalf("Failed to remove input: %v", err)
+		}
+		convert("-invert")
+		for _, name := range []string{"b.go", "b.bin", "b.preview.png"} {
+			if _, err := os.Stat(filepath.Join(outputDir, name)); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Expected %s to be removed, got %v", name, err)
+			}
+		}
+		for _, name := range []string{"a.go", "a.bin", "c.go", "doc.go", "other.go", indexFileName} {
+			if _, err := os.Stat(filepath.Join(outputDir, name)); err != nil {
+				t.Errorf("Expected %s to be kept: %v", name, err)
+			}
+		}
+		typeCheck(t, filepath.Join(outputDir, "a.go"), filepath.Join(outputDir, "c.go"), handWritten, filepath.Join(outputDir, indexFileName))
+	})
+
+	t.Run("embed", func(t *testing.T) {
+		outputPath := filepath.Join(t.TempDir(), "icons.go")
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-width", "0", "-height", "0", "-package", "icons", "-data", "embed", inputDir, outputPath}, &stdout, &stderr)
+		if code != exitOK {
+			t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+		}
+		typeCheck(t, outputPath)
+
+		content, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("Failed to read generated file: %v", err)
+		}
+		for _, expected := range []string{
+			"import _ \"embed\"\n",
+			"//go:embed arrowleft2.bin\nvar ArrowLeft2Data []byte\n",
+			"\t\tData: LogoData,\n",
+		} {
+			if !strings.Contains(string(content), expected) {
+				t.Errorf("Generated file does not contain %q", expected)
+			}
+		}
+
+		// The sidecar holds the packed rows: 16x8 mono is 16 bytes
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(outputPath), "logo.bin"))
+		if err != nil {
+			t.Fatalf("Failed to read sidecar: %v", err)
+		}
+		bm, err := loadBitmap(outputPath, decodeOptions{name: "Logo"})
+		if err != nil {
+			t.Fatalf("loadBitmap failed: %v", err)
+		}
+		if len(data) != 16 || !bytes.Equal(bm.Data, data) {
+			t.Errorf("Expected the 16 bytes of logo.bin, got % X and % X", data, bm.Data)
+		}
+	})
+}
+
+func TestConvertBatchMask(t *testing.T) {
+	inputDir := t.TempDir()
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 2))
+	for x := 2; x < 6; x++ {
+		img.SetNRGBA(x, 1, color.NRGBA{0, 0, 0, 0xFF})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode test PNG: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputDir, "sprite.png"), buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write test PNG: %v", err)
+	}
+	writeCheckerboardPNG(t, filepath.Join(inputDir, "logo.png"), 16, 8)
+
+	for _, data := range []string{"bytes", "embed"} {
+		t.Run(data, func(t *testing.T) {
+			outputPath := filepath.Join(t.TempDir(), "sprites.go")
+			var stdout, stderr bytes.Buffer
+			code := run([]string{"-width", "0", "-height", "0", "-package", "sprites", "-mask", "-codec", "lzss", "-data", data, inputDir, outputPath}, &stdout, &stderr)
+			if code != exitOK {
+				t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+			}
+			typeCheck(t, outputPath)
+
+			// The mask is opaque where the sprite is, whatever its color
+			mask, err := loadBitmap(outputPath, decodeOptions{name: "SpriteMask"})
+			if err != nil {
+				t.Fatalf("loadBitmap failed: %v", err)
+			}
+			if expected := []byte{0x00, 0x3C}; mask.Format != bitmap.Mono1 || !bytes.Equal(mask.Data, expected) {
+				t.Errorf("Expected the mask % X, got %s % X", expected, mask.Format, mask.Data)
+			}
+			sprite, err := loadBitmap(outputPath, decodeOptions{name: "Sprite"})
+			if err != nil {
+				t.Fatalf("loadBitmap failed: %v", err)
+			}
+			if !bytes.Equal(sprite.Data, mask.Data) {
+				t.Errorf("Expected black ink on a white matte, got % X", sprite.Data)
+			}
+		})
+	}
+}
+
+func TestConvertBatchErrors(t *testing.T) {
+	inputDir := t.TempDir()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{inputDir, filepath.Join(t.TempDir(), "icons.go")}, &stdout, &stderr); code != exitError {
+		t.Errorf("Expected exit code %d for an empty directory, got %d", exitError, code)
+	}
+
+	stderr.Reset()
+	if code := run([]string{inputDir, "icons.txt"}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d for a non-Go output file, got %d", exitUsage, code)
+	}
+
+	stderr.Reset()
+	if code := run([]string{"-package", "my-icons", inputDir, "icons.go"}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d for an invalid package, got %d", exitUsage, code)
+	}
+}
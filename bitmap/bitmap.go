@@ -0,0 +1,169 @@
+// Package bitmap converts images into packed pixel data for displays and
+// encodes the result as Go source, PNG images, or terminal previews.
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+// Bitmap is packed pixel data. Rows are Stride bytes long and padded to whole bytes.
+type Bitmap struct {
+	Width, Height int
+	Format        PixelFormat
+	BitOrder      BitOrder
+	Polarity      Polarity // what the highest value shows; New leaves AutoPolarity, the format's own
+	Stride        int
+	Data          []byte
+}
+
+// polarity returns b.Polarity, resolving AutoPolarity.
+func (b *Bitmap) polarity() Polarity {
+	if b.Polarity == AutoPolarity {
+		return b.Format.Polarity()
+	}
+	return b.Polarity
+}
+
+// New wraps packed data, checking that it is long enough for the given layout.
+func New(width, height int, format PixelFormat, order BitOrder, data []byte) (*Bitmap, error) {
+	if width < 0 || height < 0 {
+		return nil, fmt.Errorf("invalid size %dx%d", width, height)
+	}
+	b := &Bitmap{Width: width, Height: height, Format: format, BitOrder: order, Stride: format.Stride(width)}
+	if len(data) < b.Stride*height {
+		return nil, fmt.Errorf("%dx%d %s image needs %d bytes, got %d", width, height, format, b.Stride*height, len(data))
+	}
+	b.Data = data[:b.Stride*height]
+	return b, nil
+}
+
+// Value returns the raw value of the pixel at (x, y).
+func (b *Bitmap) Value(x, y int) uint32 {
+	row := b.Data[y*b.Stride : (y+1)*b.Stride]
+	switch b.Format {
+	case Gray8:
+		return uint32(row[x])
+	case RGB565:
+		hi, lo := row[2*x], row[2*x+1]
+		if b.BitOrder == LSBFirst {
+			hi, lo = lo, hi
+		}
+		return uint32(hi)<<8 | uint32(lo)
+	case RGB888:
+		return uint32(row[3*x])<<16 | uint32(row[3*x+1])<<8 | uint32(row[3*x+2])
+	}
+
+	bpp := b.Format.BitsPerPixel()
+	perByte := 8 / bpp
+	slot := x % perByte
+	if b.BitOrder == MSBFirst {
+		slot = perByte - 1 - slot
+	}
+	return uint32(row[x/perByte]>>(slot*bpp)) & (1<<bpp - 1)
+}
+
+// RGBAt returns the color of the pixel at (x, y) as its Polarity shows it,
+// without undoing Invert.
+func (b *Bitmap) RGBAt(x, y int) color.RGBA {
+	v := b.Value(x, y)
+	if b.Format.levels() > 0 && b.polarity() == HighBlack {
+		v = uint32(b.Format.levels()-1) - v
+	}
+	switch b.Format {
+	case Mono1:
+		if v == 0 {
+			return color.RGBA{A: 0xFF}
+		}
+		return color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+	case RGB565:
+		r, g, bl := v>>11&0x1F, v>>5&0x3F, v&0x1F
+		return color.RGBA{R: uint8(r<<3 | r>>2), G: uint8(g<<2 | g>>4), B: uint8(bl<<3 | bl>>2), A: 0xFF}
+	case RGB888:
+		return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xFF}
+	default:
+		g := uint8(v * 255 / uint32(b.Format.levels()-1))
+		return color.RGBA{R: g, G: g, B: g, A: 0xFF}
+	}
+}
+
+// Gray returns the brightness of the pixel at (x, y), 0 for black and 255 for white.
+func (b *Bitmap) Gray(x, y int) uint8 {
+	c := b.RGBAt(x, y)
+	return uint8((299*uint32(c.R) + 587*uint32(c.G) + 114*uint32(c.B)) / 1000)
+}
+
+// Image returns the bitmap as an image: a two-color paletted image for Mono1,
+// and an RGBA image otherwise.
+func (b *Bitmap) Image() image.Image {
+	rect := image.Rect(0, 0, b.Width, b.Height)
+	if b.Format == Mono1 {
+		palette := color.Palette{color.White, color.Black}
+		if b.polarity() == HighWhite {
+			palette = color.Palette{color.Black, color.White}
+		}
+		img := image.NewPaletted(rect, palette)
+		for y := 0; y < b.Height; y++ {
+			for x := 0; x < b.Width; x++ {
+				img.Pix[y*img.Stride+x] = uint8(b.Value(x, y))
+			}
+		}
+		return img
+	}
+
+	img := image.NewRGBA(rect)
+	for y := 0; y < b.Height; y++ {
+		for x := 0; x < b.Width; x++ {
+			img.SetRGBA(x, y, b.RGBAt(x, y))
+		}
+	}
+	return img
+}
+
+// pack stores raw pixel values, given row by row, in the layout of format and order.
+func pack(values []uint32, width, height int, format PixelFormat, order BitOrder) *Bitmap {
+	b := &Bitmap{Width: width, Height: height, Format: format, BitOrder: order, Stride: format.Stride(width)}
+	b.Data = make([]byte, b.Stride*height)
+
+	bpp := format.BitsPerPixel()
+	// Pixels per byte is a power of two, so the byte and the slot of a pixel
+	// are a shift and a mask away
+	perByte := 8 / max(1, min(bpp, 8))
+	shift, mask := bits.TrailingZeros(uint(perByte)), perByte-1
+	// Rows are independent bytes, so they are packed in parallel
+	parallelRows(width, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := b.Data[y*b.Stride : (y+1)*b.Stride]
+			values := values[y*width : (y+1)*width]
+			switch format {
+			case Gray8:
+				for x, v := range values {
+					row[x] = uint8(v)
+				}
+			case RGB565:
+				for x, v := range values {
+					hi, lo := uint8(v>>8), uint8(v)
+					if order == LSBFirst {
+						hi, lo = lo, hi
+					}
+					row[2*x], row[2*x+1] = hi, lo
+				}
+			case RGB888:
+				for x, v := range values {
+					row[3*x], row[3*x+1], row[3*x+2] = uint8(v>>16), uint8(v>>8), uint8(v)
+				}
+			default:
+				for x, v := range values {
+					slot := x & mask
+					if order == MSBFirst {
+						slot = mask - slot
+					}
+					row[x>>shift] |= uint8(v) << (slot * bpp)
+				}
+			}
+		}
+	})
+	return b
+}
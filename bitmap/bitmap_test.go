@@ -0,0 +1,153 @@
+package bitmap
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	bm, err := New(10, 2, Mono1, MSBFirst, []byte{0xFF, 0xC0, 0x00, 0x00, 0xAA})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	// Rows of 10 pixels are padded to 2 bytes and the extra byte is dropped
+	if bm.Stride != 2 || len(bm.Data) != 4 {
+		t.Errorf("Expected stride 2 and 4 bytes, got %d and %d", bm.Stride, len(bm.Data))
+	}
+	if bm.Value(9, 0) != 1 || bm.Value(0, 1) != 0 {
+		t.Errorf("Unexpected pixel values")
+	}
+
+	if _, err := New(10, 2, Mono1, MSBFirst, []byte{0xFF}); err == nil {
+		t.Errorf("Expected an error for short data, got nil")
+	}
+	if _, err := New(-1, 2, Mono1, MSBFirst, nil); err == nil {
+		t.Errorf("Expected an error for a negative size, got nil")
+	}
+}
+
+func TestBitmapImageRoundTrip(t *testing.T) {
+	for _, format := range []PixelFormat{Mono1, Gray2, Gray4, Gray8, RGB565, RGB888} {
+		for _, order := range []BitOrder{MSBFirst, LSBFirst} {
+			for _, polarity := range []Polarity{AutoPolarity, HighBlack, HighWhite} {
+				if polarity == HighBlack && format.levels() == 0 {
+					continue
+				}
+				t.Run(format.String()+"/"+order.String()+"/"+polarity.String(), func(t *testing.T) {
+					bm, err := Convert(createMockImage(5, 3), Options{Format: format, BitOrder: order, Polarity: polarity})
+					if err != nil {
+						t.Fatalf("Convert failed: %v", err)
+					}
+					img := bm.Image()
+					if img.Bounds() != image.Rect(0, 0, 5, 3) {
+						t.Fatalf("Unexpected bounds %v", img.Bounds())
+					}
+					for y := 0; y < 3; y++ {
+						for x := 0; x < 5; x++ {
+							want := color.Gray16Model.Convert(color.White)
+							if (x+y)%2 == 0 {
+								want = color.Gray16Model.Convert(color.Black)
+							}
+							if got := color.Gray16Model.Convert(img.At(x, y)); got != want {
+								t.Fatalf("Pixel (%d,%d): expected %v, got %v", x, y, want, got)
+							}
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestEnumText(t *testing.T) {
+	var f PixelFormat
+	if err := f.UnmarshalText([]byte("RGB565")); err != nil || f != RGB565 {
+		t.Errorf("Expected rgb565, got %v (%v)", f, err)
+	}
+	if err := f.UnmarshalText([]byte("cmyk")); err == nil {
+		t.Errorf("Expected an error for an unknown format, got nil")
+	}
+	if text, _ := Atkinson.MarshalText(); string(text) != "atkinson" {
+		t.Errorf("Expected atkinson, got %s", text)
+	}
+}
+
+func TestColorText(t *testing.T) {
+	tests := map[string]string{
+		"#ff8000": "#FF8000",
+		"F80":     "#FF8800",
+		"Black":   "#000000",
+		"white":   "#FFFFFF",
+	}
+	for text, expected := range tests {
+		var c Color
+		if err := c.UnmarshalText([]byte(text)); err != nil || c.String() != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", text, expected, c, err)
+		}
+	}
+	for _, text := range []string{"", "#12345", "#GGGGGG", "red"} {
+		var c Color
+		if err := c.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("Expected an error for %q, got %s", text, c)
+		}
+	}
+	if c := (Color{}); c.String() != "#FFFFFF" {
+		t.Errorf("Expected the zero color to be white, got %s", c)
+	}
+}
+
+func TestRectText(t *testing.T) {
+	tests := map[string]string{
+		"64x32+2+4": "64x32+2+4",
+		" 10X5 ":    "10x5+0+0",
+		"":          "",
+	}
+	for text, expected := range tests {
+		var r Rect
+		if err := r.UnmarshalText([]byte(text)); err != nil || r.String() != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", text, expected, r, err)
+		}
+	}
+	for _, text := range []string{"64", "0x5", "10x5+1", "10x5+-1+0", "10x5+1+2+3", "axb"} {
+		var r Rect
+		if err := r.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("Expected an error for %q, got %q", text, r)
+		}
+	}
+}
+
+func TestMarginsText(t *testing.T) {
+	tests := map[string]string{
+		"4":       "4",
+		" 2, 6 ":  "2,6",
+		"1,2,3,4": "1,2,3,4",
+		"5,5,5,5": "5",
+		"0":       "0",
+		"":        "0",
+	}
+	for text, expected := range tests {
+		var m Margins
+		if err := m.UnmarshalText([]byte(text)); err != nil || m.String() != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", text, expected, m, err)
+		}
+	}
+	for _, text := range []string{"1,2,3", "-1", "a", "1,,2,3"} {
+		var m Margins
+		if err := m.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("Expected an error for %q, got %q", text, m)
+		}
+	}
+}
+
+func TestPointText(t *testing.T) {
+	var p Point
+	if err := p.UnmarshalText([]byte(" -4, 12")); err != nil || p != (Point{-4, 12}) || p.String() != "-4,12" {
+		t.Errorf("Expected -4,12, got %q (%v)", p, err)
+	}
+	for _, text := range []string{"", "4", "4,a", "1,2,3"} {
+		if err := p.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("Expected an error for %q, got %q", text, p)
+		}
+	}
+}
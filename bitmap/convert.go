@@ -0,0 +1,473 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// ConvertError reports the stage of Convert that failed: "resize" or "pack".
+type ConvertError struct {
+	Stage string
+	Err   error
+}
+
+func (e *ConvertError) Error() string { return e.Stage + ": " + e.Err.Error() }
+func (e *ConvertError) Unwrap() error { return e.Err }
+
+// Convert crops, orients and resizes src as described by opts and packs it
+// into a Bitmap. Invalid options are reported as a *ConvertError.
+func Convert(src image.Image, opts Options) (*Bitmap, error) {
+	if err := opts.validateResize(); err != nil {
+		return nil, &ConvertError{Stage: "resize", Err: err}
+	}
+	if err := opts.validatePack(); err != nil {
+		return nil, &ConvertError{Stage: "pack", Err: err}
+	}
+	if err := opts.validateTone(); err != nil {
+		return nil, &ConvertError{Stage: "pack", Err: err}
+	}
+	if err := opts.validateFilters(); err != nil {
+		return nil, &ConvertError{Stage: "pack", Err: err}
+	}
+
+	// 1) Crop, orient and resize to the target resolution
+	img, err := prepare(src, opts)
+	if err != nil {
+		return nil, &ConvertError{Stage: "resize", Err: err}
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	// 2) Reduce every pixel to a raw value of the pixel format
+	var values []uint32
+	if levels := opts.Format.levels(); levels > 0 {
+		luma := luminance(img, opts)
+		if opts.hasTone() && opts.Alpha != AlphaThreshold {
+			adjustTone(luma, width, height, opts)
+		}
+		if opts.Sharpen > 0 {
+			sharpen(luma, width, height, opts)
+		}
+		switch opts.Edges {
+		case Sobel:
+			sobelEdges(luma, width, height)
+		case Canny:
+			cannyEdges(luma, width, height, opts)
+		}
+		values = quantize(luma, width, height, levels, opts)
+		if opts.Morphology != NoMorphology {
+			values = morph(values, width, height, opts)
+		}
+		// The values are brightness, which HighBlack stores the other way round
+		if flip := opts.polarity() == HighBlack != opts.Invert; flip {
+			maxLevel := uint32(levels - 1)
+			for i, level := range values {
+				values[i] = maxLevel - level
+			}
+		}
+	} else {
+		values = make([]uint32, width*height)
+		matte := opts.Matte.RGBA()
+		parallelRows(width, height, func(y0, y1 int) {
+			row := make([]color.RGBA, width)
+			for y := y0; y < y1; y++ {
+				readRow(img, y, row)
+				for x, c := range row {
+					values[y*width+x] = colorValue(over(c, matte), opts)
+				}
+			}
+		})
+	}
+
+	// 3) Lay the values out the way the panel scans them
+	if opts.TransformStage == TransformOutput && opts.transforms() {
+		values, width, height = orient(values, width, height, opts.Rotate, opts.Flip)
+	}
+
+	// 4) Pack the values into bytes
+	bm := pack(values, width, height, opts.Format, opts.BitOrder)
+	bm.Polarity = opts.polarity()
+	return bm, nil
+}
+
+// ConvertMask crops, orients and resizes src like Convert does with opts and
+// packs its alpha channel into a Mono1 bitmap in opts.BitOrder: 1 where a
+// pixel is at least threshold opaque, 0 where it is more transparent and in
+// the padding. A zero threshold means 128. The mask lines up with the bitmap
+// Convert returns, for routines that draw a bitmap through a mask.
+func ConvertMask(src image.Image, opts Options, threshold uint8) (*Bitmap, error) {
+	if err := opts.validateResize(); err != nil {
+		return nil, &ConvertError{Stage: "resize", Err: err}
+	}
+	if opts.BitOrder < MSBFirst || opts.BitOrder > LSBFirst {
+		return nil, &ConvertError{Stage: "pack", Err: fmt.Errorf("unknown bit order %s", opts.BitOrder)}
+	}
+	if threshold == 0 {
+		threshold = 128
+	}
+
+	img, err := prepare(src, opts)
+	if err != nil {
+		return nil, &ConvertError{Stage: "resize", Err: err}
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	values := make([]uint32, width*height)
+	parallelRows(width, height, func(y0, y1 int) {
+		row := make([]color.RGBA, width)
+		for y := y0; y < y1; y++ {
+			readRow(img, y, row)
+			for x, c := range row {
+				if c.A >= threshold {
+					values[y*width+x] = 1
+				}
+			}
+		}
+	})
+	if opts.TransformStage == TransformOutput && opts.transforms() {
+		values, width, height = orient(values, width, height, opts.Rotate, opts.Flip)
+	}
+	return pack(values, width, height, Mono1, opts.BitOrder), nil
+}
+
+// validateResize rejects resize options that Convert cannot honor.
+func (o Options) validateResize() error {
+	switch {
+	case o.Width < 0 || o.Height < 0:
+		return fmt.Errorf("invalid size %dx%d", o.Width, o.Height)
+	case o.Resize < Stretch || o.Resize > NoResize:
+		return fmt.Errorf("unknown resize mode %s", o.Resize)
+	case o.Scaler < Bilinear || o.Scaler > CatmullRom:
+		return fmt.Errorf("unknown scaler %s", o.Scaler)
+	case o.Crop.Min.X < 0 || o.Crop.Min.Y < 0 || o.Crop.Max.X < o.Crop.Min.X || o.Crop.Max.Y < o.Crop.Min.Y:
+		return fmt.Errorf("invalid crop %v", image.Rectangle(o.Crop))
+	case o.Rotate < Rotate0 || o.Rotate > Rotate270:
+		return fmt.Errorf("unknown rotation %s", o.Rotate)
+	case o.Flip < NoFlip || o.Flip > FlipVertical:
+		return fmt.Errorf("unknown flip %s", o.Flip)
+	case o.TransformStage < TransformSource || o.TransformStage > TransformOutput:
+		return fmt.Errorf("unknown transform stage %s", o.TransformStage)
+	case o.ImageWidth < 0 || o.ImageHeight < 0:
+		return fmt.Errorf("invalid image size %dx%d", o.ImageWidth, o.ImageHeight)
+	case o.Margins.Top < 0 || o.Margins.Right < 0 || o.Margins.Bottom < 0 || o.Margins.Left < 0:
+		return fmt.Errorf("invalid margins %s", o.Margins)
+	case o.Gravity < Center || o.Gravity > BottomRight:
+		return fmt.Errorf("unknown gravity %s", o.Gravity)
+	}
+	return nil
+}
+
+// validatePack rejects pixel options that Convert cannot honor.
+func (o Options) validatePack() error {
+	switch {
+	case o.Dither < NoDither || o.Dither > Ordered:
+		return fmt.Errorf("unknown dither %s", o.Dither)
+	case o.Format < Mono1 || o.Format > RGB888:
+		return fmt.Errorf("unknown pixel format %s", o.Format)
+	case o.BitOrder < MSBFirst || o.BitOrder > LSBFirst:
+		return fmt.Errorf("unknown bit order %s", o.BitOrder)
+	case o.Dither != NoDither && o.Format.levels() == 0:
+		return fmt.Errorf("dithering is not supported for %s", o.Format)
+	case o.Alpha < AlphaMatte || o.Alpha > AlphaThreshold:
+		return fmt.Errorf("unknown alpha mode %s", o.Alpha)
+	case o.Alpha == AlphaThreshold && o.Format.levels() == 0:
+		return fmt.Errorf("the alpha threshold is not supported for %s", o.Format)
+	case o.Polarity < AutoPolarity || o.Polarity > HighWhite:
+		return fmt.Errorf("unknown polarity %s", o.Polarity)
+	case o.Polarity == HighBlack && o.Format.levels() == 0:
+		return fmt.Errorf("polarity %s is not supported for %s", o.Polarity, o.Format)
+	case o.Luma < BT601 || o.Luma > Min:
+		return fmt.Errorf("unknown luma %s", o.Luma)
+	case o.Luma != BT601 && o.Format.levels() == 0:
+		return fmt.Errorf("luma %s is not supported for %s", o.Luma, o.Format)
+	}
+	return nil
+}
+
+// scaledSize returns the size w x h asks for a source of the given size: the
+// source size when both are zero, and the source aspect ratio for a zero one.
+func scaledSize(w, h, srcW, srcH int) (int, int) {
+	switch {
+	case w == 0 && h == 0:
+		return srcW, srcH
+	case w == 0 && srcH > 0:
+		w = (h*srcW + srcH/2) / srcH
+	case h == 0 && srcW > 0:
+		h = (w*srcH + srcW/2) / srcW
+	}
+	return w, h
+}
+
+// layout returns the bounds of the bitmap for a source of the given size, the
+// area inside the margins, and where in it the source is fitted: the whole
+// area, or a rectangle of ImageWidth x ImageHeight placed by Gravity and Offset.
+// Without Width and Height, the bitmap is the image with its margins.
+func (o Options) layout(srcW, srcH int) (canvas, area, box image.Rectangle) {
+	m := o.Margins
+	w, h := scaledSize(o.ImageWidth, o.ImageHeight, srcW, srcH)
+	if o.Width == 0 && o.Height == 0 {
+		canvas = image.Rect(0, 0, w+m.Left+m.Right, h+m.Top+m.Bottom)
+	} else {
+		W, H := scaledSize(o.Width, o.Height, srcW, srcH)
+		canvas = image.Rect(0, 0, W, H)
+	}
+	// Not image.Rect, which would swap the sides of margins wider than the bitmap
+	area = image.Rectangle{Min: image.Pt(m.Left, m.Top), Max: image.Pt(canvas.Max.X-m.Right, canvas.Max.Y-m.Bottom)}
+	if o.ImageWidth == 0 && o.ImageHeight == 0 {
+		w, h = area.Dx(), area.Dy()
+	}
+	box = o.Gravity.place(w, h, area.Dx(), area.Dy()).Add(area.Min).Add(image.Point(o.Offset))
+	return canvas, area, box
+}
+
+// place draws src fitted into box on a transparent canvas, clipped to area.
+// When the box is the whole canvas the fitted source is returned as it is.
+func place(src image.Image, canvas, area, box image.Rectangle, opts Options) image.Image {
+	if box == canvas {
+		return resize(src, box.Dx(), box.Dy(), opts)
+	}
+	dst := image.NewRGBA(canvas)
+	if visible := box.Intersect(area); !visible.Empty() {
+		img := resize(src, box.Dx(), box.Dy(), opts)
+		draw.Draw(dst, visible, img, img.Bounds().Min.Add(visible.Min.Sub(box.Min)), draw.Src)
+	}
+	return dst
+}
+
+// resize draws src into an RGBA image of w x h. Padding is left transparent,
+// so it takes the matte like transparent pixels of src do. A source that
+// already has the size and a layout readRow reads directly is returned as it
+// is, saving the copy.
+func resize(src image.Image, w, h int, opts Options) image.Image {
+	sb := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if sb.Empty() || w == 0 || h == 0 {
+		return dst
+	}
+	if w == sb.Dx() && h == sb.Dy() {
+		switch src.(type) {
+		case *image.RGBA, *image.NRGBA, *image.Gray:
+			return src
+		}
+	}
+
+	// Keep the source pixels when nothing needs scaling
+	if opts.Resize == NoResize || (w == sb.Dx() && h == sb.Dy()) {
+		r := opts.Gravity.place(sb.Dx(), sb.Dy(), w, h)
+		draw.Draw(dst, r, src, sb.Min, draw.Src)
+		return dst
+	}
+
+	scaler := opts.Scaler.Interpolator()
+	switch opts.Resize {
+	case Fit:
+		// Letterbox: scale down to the tighter dimension and pad
+		sw, sh := w, h
+		if sb.Dx()*h > sb.Dy()*w {
+			sh = max(1, sb.Dy()*w/sb.Dx())
+		} else {
+			sw = max(1, sb.Dx()*h/sb.Dy())
+		}
+		scaler.Scale(dst, opts.Gravity.place(sw, sh, w, h), src, sb, draw.Src, nil)
+	case Fill:
+		// Crop the source to the target aspect ratio, keeping the part
+		// Gravity points at
+		cw, ch := sb.Dx(), sb.Dy()
+		if cw*h > ch*w {
+			cw = max(1, ch*w/h)
+		} else {
+			ch = max(1, cw*h/w)
+		}
+		crop := opts.Gravity.place(cw, ch, sb.Dx(), sb.Dy()).Add(sb.Min)
+		scaler.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+	default:
+		// Preserve aspect to fill; adjust if you prefer letterboxing
+		scaler.Scale(dst, dst.Bounds(), src, sb, draw.Over, nil)
+	}
+	return dst
+}
+
+// Interpolator returns the x/image/draw scaler for s.
+func (s Scaler) Interpolator() draw.Interpolator {
+	switch s {
+	case NearestNeighbor:
+		return draw.NearestNeighbor
+	case CatmullRom:
+		return draw.CatmullRom
+	default:
+		return draw.ApproxBiLinear
+	}
+}
+
+// place returns a w x h rectangle aligned to g in a W x H one. When it is
+// larger, it sticks out on the other side, or on both when centered.
+func (g Gravity) place(w, h, W, H int) image.Rectangle {
+	// Halves of the room left over
+	hx, hy := 1, 1
+	switch g {
+	case TopLeft, Left, BottomLeft:
+		hx = 0
+	case TopRight, Right, BottomRight:
+		hx = 2
+	}
+	switch g {
+	case TopLeft, Top, TopRight:
+		hy = 0
+	case BottomLeft, Bottom, BottomRight:
+		hy = 2
+	}
+	x, y := (W-w)*hx/2, (H-h)*hy/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// over composites c, a premultiplied color, onto the opaque color matte.
+func over(c, matte color.RGBA) color.RGBA {
+	if c.A == 0xFF {
+		return c
+	}
+	t := 0xFF - uint32(c.A)
+	blend := func(c, m uint8) uint8 {
+		return uint8(min(0xFF, uint32(c)+(uint32(m)*t+0x7F)/0xFF))
+	}
+	return color.RGBA{blend(c.R, matte.R), blend(c.G, matte.G), blend(c.B, matte.B), 0xFF}
+}
+
+// luminance returns the luminance of every pixel on the matte as opts.Luma
+// computes it, scaled to 0..65535, or its transparency with AlphaThreshold.
+func luminance(img image.Image, opts Options) []int32 {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	luma := make([]int32, width*height)
+	matte := opts.Matte.RGBA()
+	parallelRows(width, height, func(y0, y1 int) {
+		row := make([]color.RGBA, width)
+		for y := y0; y < y1; y++ {
+			readRow(img, y, row)
+			for x, c := range row {
+				if opts.Alpha == AlphaThreshold {
+					luma[y*width+x] = int32(0xFFFF - uint32(c.A)*0x101)
+					continue
+				}
+				luma[y*width+x] = opts.Luma.of(over(c, matte))
+			}
+		}
+	})
+	return luma
+}
+
+// of returns the luminance of the opaque color c, scaled to 0..65535.
+func (l Luma) of(c color.RGBA) int32 {
+	// 16-bit per channel (0..65535)
+	r, g, b := uint32(c.R)*0x101, uint32(c.G)*0x101, uint32(c.B)*0x101
+	switch l {
+	case BT709:
+		return int32((2126*r + 7152*g + 722*b) / 10000)
+	case LinearLuma:
+		table := linearTable()
+		y := 0.2126*table[r] + 0.7152*table[g] + 0.0722*table[b]
+		return int32(math.Round(encodeSRGB(min(float64(y), 1)) * 0xFFFF))
+	case Average:
+		return int32((r + g + b) / 3)
+	case Red:
+		return int32(r)
+	case Green:
+		return int32(g)
+	case Blue:
+		return int32(b)
+	case Max:
+		return int32(max(r, g, b))
+	case Min:
+		return int32(min(r, g, b))
+	default:
+		// Perceptual luminance (ITU-R BT.601-ish)
+		return int32((299*r + 587*g + 114*b) / 1000)
+	}
+}
+
+// readRow reads row y, counted from the top of the bounds, into dst as the
+// premultiplied colors an RGBA copy of img would hold. RGBA, NRGBA and Gray
+// images are read straight from their Pix slices rather than through At,
+// which allocates a color.Color for every pixel.
+func readRow(img image.Image, y int, dst []color.RGBA) {
+	b := img.Bounds()
+	switch img := img.(type) {
+	case *image.RGBA:
+		pix := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := range dst {
+			p := pix[4*x : 4*x+4 : 4*x+4]
+			dst[x] = color.RGBA{p[0], p[1], p[2], p[3]}
+		}
+	case *image.NRGBA:
+		pix := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := range dst {
+			p := pix[4*x : 4*x+4 : 4*x+4]
+			if a := p[3]; a == 0xFF {
+				dst[x] = color.RGBA{p[0], p[1], p[2], a}
+			} else {
+				dst[x] = color.RGBA{premultiply(p[0], a), premultiply(p[1], a), premultiply(p[2], a), a}
+			}
+		}
+	case *image.Gray:
+		pix := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := range dst {
+			v := pix[x]
+			dst[x] = color.RGBA{v, v, v, 0xFF}
+		}
+	default:
+		for x := range dst {
+			dst[x] = color.RGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA)
+		}
+	}
+}
+
+// premultiply scales an NRGBA channel by alpha, rounding like image/draw does
+// when it copies an NRGBA image into an RGBA one.
+func premultiply(c, a uint8) uint8 {
+	return uint8(uint32(c) * (uint32(a) * 0x101) / 0xFF >> 8)
+}
+
+// minParallelPixels is the size below which rows are not worth spreading over goroutines.
+const minParallelPixels = 1 << 14
+
+// parallelRows calls do with bands of rows that together cover [0, height),
+// one band per GOMAXPROCS worker. Small images are done on the calling goroutine.
+func parallelRows(width, height int, do func(y0, y1 int)) {
+	workers := min(runtime.GOMAXPROCS(0), height)
+	if workers <= 1 || width*height < minParallelPixels {
+		do(0, height)
+		return
+	}
+	band := (height + workers - 1) / workers
+	var wg sync.WaitGroup
+	for y0 := 0; y0 < height; y0 += band {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(y0, min(y0+band, height))
+		}()
+	}
+	wg.Wait()
+}
+
+// polarity returns the polarity of the packed values, resolving AutoPolarity.
+func (o Options) polarity() Polarity {
+	if o.Polarity == AutoPolarity {
+		return o.Format.Polarity()
+	}
+	return o.Polarity
+}
+
+// colorValue packs an RGBA pixel into an RGB565 or RGB888 value.
+func colorValue(c color.RGBA, opts Options) uint32 {
+	r, g, b := uint32(c.R), uint32(c.G), uint32(c.B)
+	if opts.Invert {
+		r, g, b = 255-r, 255-g, 255-b
+	}
+	if opts.Format == RGB565 {
+		return r>>3<<11 | g>>2<<5 | b>>3
+	}
+	return r<<16 | g<<8 | b
+}
@@ -0,0 +1,646 @@
+package bitmap
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// createMockImage creates a simple test image with a specified pattern
+func createMockImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// Create a simple checkerboard pattern
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// Alternate black and white pixels
+			if (x+y)%2 == 0 {
+				img.Set(x, y, color.Black)
+			} else {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+
+	return img
+}
+
+// createGradient creates a horizontal gray ramp from black to white
+func createGradient(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(x * 255 / max(1, width-1))})
+		}
+	}
+	return img
+}
+
+func TestConvert(t *testing.T) {
+	// Create a small test image (4x4 pixels)
+	img := createMockImage(4, 4)
+
+	// Convert the image at its own size
+	bm, err := Convert(img, Options{})
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	// Check dimensions
+	if bm.Width != 4 {
+		t.Errorf("Expected width to be 4, got %d", bm.Width)
+	}
+	if bm.Height != 4 {
+		t.Errorf("Expected height to be 4, got %d", bm.Height)
+	}
+
+	// Each 4-pixel row fits in one byte, with 4 bits unused
+	if len(bm.Data) != 4 {
+		t.Errorf("Expected data length to be 4, got %d", len(bm.Data))
+	}
+
+	// Check the pattern (even rows should be 0xA0 - 10100000 in binary, odd rows 0x50 - 01010000)
+	// The last 4 bits are unused and set to 0
+	for i, b := range bm.Data {
+		expectedPattern := byte(0xA0)
+		if i%2 == 1 {
+			expectedPattern = 0x50
+		}
+		if b != expectedPattern {
+			t.Errorf("Row %d: Expected 0x%02X, got 0x%02X", i, expectedPattern, b)
+		}
+	}
+}
+
+// TestConvertWithOddDimensions tests Convert with an image that has odd dimensions
+func TestConvertWithOddDimensions(t *testing.T) {
+	// Create a test image with odd dimensions (5x3 pixels)
+	img := createMockImage(5, 3)
+
+	bm, err := Convert(img, Options{})
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	// Check dimensions
+	if bm.Width != 5 || bm.Height != 3 {
+		t.Errorf("Expected 5x3, got %dx%d", bm.Width, bm.Height)
+	}
+
+	// For a 5x3 image, we expect 3 bytes (one for each row)
+	if len(bm.Data) != 3 {
+		t.Errorf("Expected data length to be 3, got %d", len(bm.Data))
+	}
+
+	// Even rows start with black, giving 0xA8 (10101000); odd rows give 0x50 (01010000)
+	for i, b := range bm.Data {
+		expectedPattern := byte(0xA8)
+		if i%2 == 1 {
+			expectedPattern = 0x50
+		}
+		if b != expectedPattern {
+			t.Errorf("Row %d: Expected 0x%02X, got 0x%02X", i, expectedPattern, b)
+		}
+	}
+}
+
+// TestConvertEmpty tests Convert with an empty image (0x0)
+func TestConvertEmpty(t *testing.T) {
+	bm, err := Convert(createMockImage(0, 0), Options{})
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if bm.Width != 0 || bm.Height != 0 || len(bm.Data) != 0 {
+		t.Errorf("Expected an empty bitmap, got %dx%d with %d bytes", bm.Width, bm.Height, len(bm.Data))
+	}
+}
+
+func TestConvertBitOrderAndInvert(t *testing.T) {
+	img := createMockImage(4, 1)
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected byte
+	}{
+		{name: "msb", opts: Options{}, expected: 0xA0},
+		{name: "lsb", opts: Options{BitOrder: LSBFirst}, expected: 0x05},
+		{name: "invert", opts: Options{Invert: true}, expected: 0x50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm, err := Convert(img, tt.opts)
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if bm.Data[0] != tt.expected {
+				t.Errorf("Expected 0x%02X, got 0x%02X", tt.expected, bm.Data[0])
+			}
+		})
+	}
+}
+
+func TestConvertPolarity(t *testing.T) {
+	img := createMockImage(4, 1)
+	plain, err := Convert(img, Options{})
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if plain.Polarity != HighBlack {
+		t.Errorf("Expected mono to default to %s, got %s", HighBlack, plain.Polarity)
+	}
+
+	tests := []struct {
+		opts     Options
+		expected byte
+	}{
+		{opts: Options{Polarity: HighWhite}, expected: 0x50},
+		{opts: Options{Polarity: HighBlack}, expected: 0xA0},
+		{opts: Options{Polarity: HighWhite, Invert: true}, expected: 0xA0},
+		{opts: Options{Format: Gray2, Polarity: HighBlack}, expected: 0xCC},
+		{opts: Options{Format: Gray2, Polarity: HighWhite}, expected: 0x33},
+	}
+	for _, tt := range tests {
+		bm, err := Convert(img, tt.opts)
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		if bm.Data[0] != tt.expected || bm.Polarity != tt.opts.Polarity {
+			t.Errorf("%+v: expected 0x%02X, got 0x%02X in %s", tt.opts, tt.expected, bm.Data[0], bm.Polarity)
+		}
+		// The polarity changes the data but not the image
+		for x := 0; x < 4; x++ {
+			if got, expected := bm.Gray(x, 0), plain.Gray(x, 0); got != expected != tt.opts.Invert {
+				t.Errorf("%+v: pixel %d reads %d, want %d", tt.opts, x, got, expected)
+			}
+		}
+	}
+}
+
+func TestConvertFormats(t *testing.T) {
+	img := createGradient(4, 1)
+
+	tests := []struct {
+		format   PixelFormat
+		expected []byte
+	}{
+		{format: Gray2, expected: []byte{0b00_01_10_11}},
+		{format: Gray4, expected: []byte{0x05, 0xAF}},
+		{format: Gray8, expected: []byte{0x00, 0x55, 0xAA, 0xFF}},
+		{format: RGB565, expected: []byte{0x00, 0x00, 0x52, 0xAA, 0xAD, 0x55, 0xFF, 0xFF}},
+		{format: RGB888, expected: []byte{0, 0, 0, 0x55, 0x55, 0x55, 0xAA, 0xAA, 0xAA, 0xFF, 0xFF, 0xFF}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format.String(), func(t *testing.T) {
+			bm, err := Convert(img, Options{Format: tt.format})
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if !bytes.Equal(bm.Data, tt.expected) {
+				t.Errorf("Expected % X, got % X", tt.expected, bm.Data)
+			}
+		})
+	}
+}
+
+func TestConvertResize(t *testing.T) {
+	img := createMockImage(8, 4)
+
+	tests := []struct {
+		name          string
+		opts          Options
+		width, height int
+	}{
+		{name: "stretch", opts: Options{Width: 4, Height: 4}, width: 4, height: 4},
+		{name: "fit", opts: Options{Width: 4, Height: 4, Resize: Fit}, width: 4, height: 4},
+		{name: "fill", opts: Options{Width: 4, Height: 4, Resize: Fill}, width: 4, height: 4},
+		{name: "width only", opts: Options{Width: 4}, width: 4, height: 2},
+		{name: "none", opts: Options{Width: 16, Height: 2, Resize: NoResize}, width: 16, height: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm, err := Convert(img, tt.opts)
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if bm.Width != tt.width || bm.Height != tt.height {
+				t.Errorf("Expected %dx%d, got %dx%d", tt.width, tt.height, bm.Width, bm.Height)
+			}
+		})
+	}
+
+	// Fit pads with the white matte, so the bands above and below an 8x4 source stay blank
+	bm, err := Convert(img, Options{Width: 8, Height: 8, Resize: Fit, Scaler: NearestNeighbor})
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if bm.Data[0] != 0 || bm.Data[7] != 0 {
+		t.Errorf("Expected blank letterbox rows, got % X", bm.Data)
+	}
+}
+
+// picture returns the ink of a bitmap as rows of # and .
+func picture(bm *Bitmap) []string {
+	rows := make([]string, bm.Height)
+	for y := range rows {
+		for x := 0; x < bm.Width; x++ {
+			if bm.Gray(x, y) < 0x80 {
+				rows[y] += "#"
+			} else {
+				rows[y] += "."
+			}
+		}
+	}
+	return rows
+}
+
+func TestConvertPlacement(t *testing.T) {
+	// A black 2x2 square
+	dot := image.NewGray(image.Rect(0, 0, 2, 2))
+	tests := []struct {
+		name     string
+		src      image.Image
+		opts     Options
+		expected []string
+	}{
+		{name: "top-left", src: dot, opts: Options{Width: 4, Height: 3, ImageWidth: 2, ImageHeight: 2, Gravity: TopLeft}, expected: []string{"##..", "##..", "...."}},
+		{name: "center", src: dot, opts: Options{Width: 4, Height: 4, ImageWidth: 2, ImageHeight: 2}, expected: []string{"....", ".##.", ".##.", "...."}},
+		{name: "margins", src: dot, opts: Options{Width: 5, Height: 4, ImageWidth: 2, ImageHeight: 2, Margins: Margins{1, 1, 1, 1}, Gravity: BottomRight}, expected: []string{".....", "..##.", "..##.", "....."}},
+		{name: "offset", src: dot, opts: Options{Width: 4, Height: 3, ImageWidth: 2, ImageHeight: 2, Gravity: TopLeft, Offset: Point{1, 1}}, expected: []string{"....", ".##.", ".##."}},
+		// The image is clipped to the bitmap
+		{name: "clipped", src: dot, opts: Options{Width: 4, Height: 2, ImageWidth: 2, ImageHeight: 2, Gravity: TopLeft, Offset: Point{3, 0}}, expected: []string{"...#", "...#"}},
+		// Without a size, the margins are added around the source
+		{name: "border", src: dot, opts: Options{Margins: Margins{Top: 1, Right: 2}}, expected: []string{"....", "##..", "##.."}},
+		// Fit letterboxes inside the margins, toward Gravity
+		{name: "fit", src: dot, opts: Options{Width: 6, Height: 4, Margins: Margins{1, 1, 1, 1}, Resize: Fit, Scaler: NearestNeighbor, Gravity: Left}, expected: []string{"......", ".##...", ".##...", "......"}},
+		// Fill and NoResize keep the part of the source Gravity points at
+		{name: "fill", src: arrow(), opts: Options{Width: 4, Height: 4, Resize: Fill, Scaler: NearestNeighbor, Gravity: Left}, expected: []string{"####", "#...", "#...", "#..."}},
+		{name: "none", src: arrow(), opts: Options{Width: 4, Height: 4, Resize: NoResize, Gravity: Right}, expected: []string{"####", "....", "....", "...."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm, err := Convert(tt.src, tt.opts)
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if got := picture(bm); !slices.Equal(got, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	// The mask is clear around the placed image
+	mask, err := ConvertMask(dot, tests[0].opts, 0)
+	if err != nil {
+		t.Fatalf("ConvertMask failed: %v", err)
+	}
+	if !bytes.Equal(mask.Data, []byte{0xC0, 0xC0, 0x00}) {
+		t.Errorf("Expected mask C0 C0 00, got % X", mask.Data)
+	}
+
+	// Margins must leave room for the image
+	_, err = Convert(dot, Options{Width: 4, Height: 4, Margins: Margins{2, 2, 2, 2}})
+	var ce *ConvertError
+	if !errors.As(err, &ce) || ce.Stage != "resize" {
+		t.Errorf("Expected a resize error for margins filling the bitmap, got %v", err)
+	}
+}
+
+// transparentLogo returns an 8x1 image of a transparent background, then a
+// translucent white pixel, then four opaque black ones
+func transparentLogo() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 1))
+	img.SetNRGBA(1, 0, color.NRGBA{0xFF, 0xFF, 0xFF, 0xC0})
+	for x := 2; x < 6; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{0, 0, 0, 0xFF})
+	}
+	return img
+}
+
+func TestConvertMatte(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		expected []byte
+	}{
+		// Transparent pixels are white by default, not black
+		{name: "default", opts: Options{}, expected: []byte{0x3C}},
+		{name: "black", opts: Options{Matte: Black}, expected: []byte{0xBF}},
+		{name: "stretched", opts: Options{Width: 16, Height: 1, Scaler: NearestNeighbor}, expected: []byte{0x0F, 0xF0}},
+		// The padding of Fit takes the matte too
+		{name: "fit", opts: Options{Width: 8, Height: 3, Resize: Fit, Scaler: NearestNeighbor, Matte: Black}, expected: []byte{0xFF, 0xBF, 0xFF}},
+		{name: "rgb", opts: Options{Width: 10, Height: 1, Resize: NoResize, Format: RGB565, Matte: Color{0xFF, 0, 0, 0xFF}}, expected: []byte{
+			0xF8, 0x00, 0xF8, 0x00, 0xFE, 0x18, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0xF8, 0x00, 0xF8, 0x00, 0xF8, 0x00,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm, err := Convert(transparentLogo(), tt.opts)
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if !bytes.Equal(bm.Data, tt.expected) {
+				t.Errorf("Expected % X, got % X", tt.expected, bm.Data)
+			}
+		})
+	}
+}
+
+func TestConvertAlphaThreshold(t *testing.T) {
+	// The translucent white pixel is opaque enough to be ink, whatever its color
+	bm, err := Convert(transparentLogo(), Options{Alpha: AlphaThreshold})
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if bm.Data[0] != 0x7C {
+		t.Errorf("Expected 7C, got % X", bm.Data)
+	}
+	bm, err = Convert(transparentLogo(), Options{Alpha: AlphaThreshold, Threshold: 60})
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if bm.Data[0] != 0x3C {
+		t.Errorf("Expected 3C with a lower threshold, got % X", bm.Data)
+	}
+
+	// Gray levels follow the opacity
+	bm, err = Convert(transparentLogo(), Options{Alpha: AlphaThreshold, Format: Gray8})
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if expected := []byte{0xFF, 0x3F, 0, 0, 0, 0, 0xFF, 0xFF}; !bytes.Equal(bm.Data, expected) {
+		t.Errorf("Expected % X, got % X", expected, bm.Data)
+	}
+}
+
+func TestConvertLuma(t *testing.T) {
+	// A brick red pixel, and white so the extremes stay put
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{0xC0, 0x40, 0x20, 0xFF})
+	src.Set(1, 0, color.White)
+
+	tests := []struct {
+		luma     Luma
+		expected byte
+	}{
+		{luma: BT601, expected: 0x63},
+		{luma: BT709, expected: 0x59},
+		{luma: LinearLuma, expected: 0x6C},
+		{luma: Average, expected: 0x60},
+		{luma: Red, expected: 0xC0},
+		{luma: Green, expected: 0x40},
+		{luma: Blue, expected: 0x20},
+		{luma: Max, expected: 0xC0},
+		{luma: Min, expected: 0x20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.luma.String(), func(t *testing.T) {
+			bm, err := Convert(src, Options{Format: Gray8, Luma: tt.luma})
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if expected := []byte{tt.expected, 0xFF}; !bytes.Equal(bm.Data, expected) {
+				t.Errorf("Expected % X, got % X", expected, bm.Data)
+			}
+		})
+	}
+}
+
+func TestConvertMask(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Options
+		threshold uint8
+		expected  []byte
+	}{
+		{name: "default", expected: []byte{0x7C}},
+		{name: "threshold", threshold: 0xC1, expected: []byte{0x3C}},
+		{name: "lsb", opts: Options{BitOrder: LSBFirst}, expected: []byte{0x3E}},
+		// The padding is transparent, and the colors and their options do not matter
+		{name: "fit", opts: Options{Width: 8, Height: 3, Resize: Fit, Scaler: NearestNeighbor, Format: RGB565, Invert: true}, expected: []byte{0, 0x7C, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask, err := ConvertMask(transparentLogo(), tt.opts, tt.threshold)
+			if err != nil {
+				t.Fatalf("ConvertMask failed: %v", err)
+			}
+			if mask.Format != Mono1 || mask.BitOrder != tt.opts.BitOrder || !bytes.Equal(mask.Data, tt.expected) {
+				t.Errorf("Expected % X, got %s % X", tt.expected, mask.Format, mask.Data)
+			}
+		})
+	}
+
+	if _, err := ConvertMask(transparentLogo(), Options{Width: -1}, 0); err == nil {
+		t.Errorf("Expected an error for an invalid size, got nil")
+	}
+}
+
+func TestConvertDither(t *testing.T) {
+	// A flat mid gray is all black or all white with a plain threshold, and a mix when dithered
+	img := image.NewUniform(color.Gray{Y: 0x70})
+	src := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			src.Set(x, y, img.C)
+		}
+	}
+
+	for _, d := range []Dither{FloydSteinberg, Atkinson, Ordered} {
+		t.Run(d.String(), func(t *testing.T) {
+			bm, err := Convert(src, Options{Dither: d})
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			ink := 0
+			for y := 0; y < bm.Height; y++ {
+				for x := 0; x < bm.Width; x++ {
+					ink += int(bm.Value(x, y))
+				}
+			}
+			// 0x70 is 44% brightness, so a bit over half the pixels should be ink
+			if ink < 100 || ink > 180 {
+				t.Errorf("Expected roughly 56%% ink, got %d of 256 pixels", ink)
+			}
+		})
+	}
+}
+
+func TestConvertInvalidOptions(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		stage string
+	}{
+		{opts: Options{Width: -1}, stage: "resize"},
+		{opts: Options{Scaler: Scaler(9)}, stage: "resize"},
+		{opts: Options{ImageWidth: -1}, stage: "resize"},
+		{opts: Options{Margins: Margins{Left: -1}}, stage: "resize"},
+		{opts: Options{Gravity: Gravity(9)}, stage: "resize"},
+		{opts: Options{Format: RGB565, Dither: Atkinson}, stage: "pack"},
+		{opts: Options{Format: PixelFormat(42)}, stage: "pack"},
+		{opts: Options{Format: RGB565, Alpha: AlphaThreshold}, stage: "pack"},
+		{opts: Options{Alpha: AlphaMode(7)}, stage: "pack"},
+		{opts: Options{Polarity: Polarity(3)}, stage: "pack"},
+		{opts: Options{Format: RGB565, Polarity: HighBlack}, stage: "pack"},
+		{opts: Options{Luma: Luma(12)}, stage: "pack"},
+		{opts: Options{Format: RGB888, Luma: Red}, stage: "pack"},
+	}
+
+	for _, tt := range tests {
+		_, err := Convert(createMockImage(2, 2), tt.opts)
+		var ce *ConvertError
+		if !errors.As(err, &ce) || ce.Stage != tt.stage {
+			t.Errorf("Expected a %s error for %+v, got %v", tt.stage, tt.opts, err)
+		}
+	}
+}
+
+// opaqueImage hides the concrete type of an image, so Convert takes the generic
+// path through image/draw and At
+type opaqueImage struct{ image.Image }
+
+// noisyImages returns images of every layout readRow reads directly, filled with
+// pseudo-random pixels including translucent ones
+func noisyImages(width, height int) map[string]image.Image {
+	rect := image.Rect(0, 0, width, height)
+	rgba, nrgba, gray := image.NewRGBA(rect), image.NewNRGBA(rect), image.NewGray(rect)
+	seed := uint32(1)
+	next := func() uint8 {
+		seed = seed*1664525 + 1013904223
+		return uint8(seed >> 24)
+	}
+	for i := range nrgba.Pix {
+		nrgba.Pix[i] = next()
+	}
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		// Premultiplied colors never exceed their alpha
+		a := next()
+		channel := func() uint8 { return uint8(int(next()) % (int(a) + 1)) }
+		rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2], rgba.Pix[i+3] = channel(), channel(), channel(), a
+	}
+	for i := range gray.Pix {
+		gray.Pix[i] = next()
+	}
+	offset := image.Rect(3, 2, width+3, height+2)
+	return map[string]image.Image{
+		"rgba":      rgba,
+		"nrgba":     nrgba,
+		"gray":      gray,
+		"subimage":  image.NewRGBA(image.Rect(0, 0, width+5, height+4)).SubImage(offset),
+		"nrgba-sub": nrgba.SubImage(image.Rect(1, 1, width, height)),
+	}
+}
+
+// TestConvertFastPaths checks that reading Pix directly, and in parallel,
+// gives exactly the bytes of the generic path
+func TestConvertFastPaths(t *testing.T) {
+	// Large enough for the rows to be spread over workers
+	width, height := 301, 207
+	if width*height < minParallelPixels {
+		t.Fatalf("Test image too small to run in parallel")
+	}
+	tests := []Options{
+		{},
+		{Threshold: 90, BitOrder: LSBFirst},
+		{Dither: Ordered},
+		{Dither: FloydSteinberg, Invert: true},
+		{Format: Gray2, Dither: Atkinson},
+		{Format: Gray4, Dither: Ordered},
+		{Format: Gray8},
+		{Format: RGB565, Invert: true},
+		{Format: RGB888},
+		{Width: 150, Height: 100, Format: Gray4},
+		{Width: 400, Height: 300, Resize: Fit},
+	}
+	for name, src := range noisyImages(width, height) {
+		for _, opts := range tests {
+			got, err := Convert(src, opts)
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			expected, err := Convert(opaqueImage{src}, opts)
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if got.Width != expected.Width || got.Height != expected.Height || !bytes.Equal(got.Data, expected.Data) {
+				t.Errorf("%s %+v: fast path differs from the generic path", name, opts)
+			}
+		}
+	}
+}
+
+// benchmarkSource returns an 800x480 photo-like image of the given layout
+func benchmarkSource(kind string) image.Image {
+	const width, height = 800, 480
+	rect := image.Rect(0, 0, width, height)
+	var img draw.Image
+	switch kind {
+	case "gray":
+		img = image.NewGray(rect)
+	case "nrgba":
+		img = image.NewNRGBA(rect)
+	default:
+		img = image.NewRGBA(rect)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), uint8((x ^ y) & 0xFF), 0xFF})
+		}
+	}
+	return img
+}
+
+func BenchmarkConvert(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		opts Options
+	}{
+		{name: "threshold", opts: Options{}},
+		{name: "ordered", opts: Options{Dither: Ordered}},
+		{name: "floyd-steinberg", opts: Options{Dither: FloydSteinberg}},
+		{name: "gray4", opts: Options{Format: Gray4, Dither: Ordered}},
+		{name: "rgb565", opts: Options{Format: RGB565}},
+		{name: "resize", opts: Options{Width: 296, Height: 128, Resize: Fit}},
+	}
+	for _, kind := range []string{"rgba", "nrgba", "gray"} {
+		src := benchmarkSource(kind)
+		for _, bm := range benchmarks {
+			b.Run(kind+"/"+bm.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := Convert(src, bm.opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestParallelRows(t *testing.T) {
+	for _, size := range []struct{ width, height int }{{0, 0}, {1, 1}, {1000, 3}, {200, 200}, {minParallelPixels, 7}} {
+		var mu sync.Mutex
+		seen := make([]int, size.height)
+		parallelRows(size.width, size.height, func(y0, y1 int) {
+			mu.Lock()
+			defer mu.Unlock()
+			for y := y0; y < y1; y++ {
+				seen[y]++
+			}
+		})
+		for y, n := range seen {
+			if n != 1 {
+				t.Errorf("%dx%d: row %d done %d times", size.width, size.height, y, n)
+			}
+		}
+	}
+}
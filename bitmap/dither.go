@@ -0,0 +1,95 @@
+package bitmap
+
+// diffusionTap spreads weight/divisor of the quantization error to the pixel at (dx, dy).
+type diffusionTap struct {
+	dx, dy int
+	weight int32
+}
+
+// diffusionKernel is an error-diffusion matrix.
+type diffusionKernel struct {
+	divisor int32
+	taps    []diffusionTap
+}
+
+var (
+	floydSteinbergKernel = diffusionKernel{divisor: 16, taps: []diffusionTap{
+		{1, 0, 7}, {-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+	}}
+	// Atkinson only spreads 6/8 of the error, dropping the rest
+	atkinsonKernel = diffusionKernel{divisor: 8, taps: []diffusionTap{
+		{1, 0, 1}, {2, 0, 1}, {-1, 1, 1}, {0, 1, 1}, {1, 1, 1}, {0, 2, 1},
+	}}
+)
+
+// bayer4 is the 4x4 ordered-dither matrix.
+var bayer4 = [4][4]int32{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// quantize reduces 16-bit luminance values to brightness levels 0..levels-1.
+// Two levels (Mono1) use the threshold from opts; more levels round to the nearest one.
+// Every pixel stands alone without dithering or with ordered dithering, so
+// rows are quantized in parallel; error diffusion carries from pixel to pixel.
+func quantize(luma []int32, width, height, levels int, opts Options) []uint32 {
+	const maxLuma = 0xFFFF
+	step := int32(maxLuma / (levels - 1))
+	thresh := int32(opts.threshold())
+
+	level := func(v int32) uint32 {
+		if levels == 2 {
+			if v < thresh {
+				return 0 // darker pixel -> black
+			}
+			return 1 // lighter pixel -> white
+		}
+		l := (v + step/2) / step
+		return uint32(min(max(l, 0), int32(levels-1)))
+	}
+
+	out := make([]uint32, len(luma))
+	switch opts.Dither {
+	case Ordered:
+		parallelRows(width, height, func(y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				for x := 0; x < width; x++ {
+					// Offset each pixel by up to half a step either way
+					bias := (2*bayer4[y%4][x%4] + 1 - 16) * step / 32
+					out[y*width+x] = level(luma[y*width+x] + bias)
+				}
+			}
+		})
+	case FloydSteinberg, Atkinson:
+		kernel := floydSteinbergKernel
+		if opts.Dither == Atkinson {
+			kernel = atkinsonKernel
+		}
+		buf := make([]int32, len(luma))
+		copy(buf, luma)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				v := buf[y*width+x]
+				l := level(v)
+				out[y*width+x] = l
+				diff := v - int32(l)*step
+				for _, t := range kernel.taps {
+					nx, ny := x+t.dx, y+t.dy
+					if nx < 0 || nx >= width || ny >= height {
+						continue
+					}
+					buf[ny*width+nx] += diff * t.weight / kernel.divisor
+				}
+			}
+		}
+	default:
+		parallelRows(width, height, func(y0, y1 int) {
+			for i := y0 * width; i < y1*width; i++ {
+				out[i] = level(luma[i])
+			}
+		})
+	}
+	return out
+}
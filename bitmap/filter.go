@@ -0,0 +1,258 @@
+package bitmap
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+)
+
+// Limits of the filter options, which keep the kernels small enough to stay fast.
+const (
+	maxSharpen     = 5
+	maxFilterRange = 64
+)
+
+// Hysteresis thresholds of Canny, as fractions of the strongest gradient: edges
+// start at pixels above cannyHigh and continue through pixels above cannyLow.
+const cannyHigh, cannyLow = 0.2, 0.08
+
+// validateFilters rejects filter options that Convert cannot honor.
+func (o Options) validateFilters() error {
+	switch {
+	case !(o.Sharpen >= 0 && o.Sharpen <= maxSharpen):
+		return fmt.Errorf("sharpen amount %g is not between 0 and %d", o.Sharpen, maxSharpen)
+	case !(o.SharpenRadius >= 0 && o.SharpenRadius <= maxFilterRange):
+		return fmt.Errorf("sharpen radius %g is not between 0 and %d", o.SharpenRadius, maxFilterRange)
+	case o.Edges < NoEdges || o.Edges > Canny:
+		return fmt.Errorf("unknown edge detection %s", o.Edges)
+	case !(o.EdgeRadius >= 0 && o.EdgeRadius <= maxFilterRange):
+		return fmt.Errorf("edge radius %g is not between 0 and %d", o.EdgeRadius, maxFilterRange)
+	case o.Morphology < NoMorphology || o.Morphology > Erode:
+		return fmt.Errorf("unknown morphology %s", o.Morphology)
+	case o.MorphologyRadius < 0 || o.MorphologyRadius > maxFilterRange:
+		return fmt.Errorf("morphology radius %d is not between 0 and %d", o.MorphologyRadius, maxFilterRange)
+	case (o.Sharpen > 0 || o.Edges != NoEdges || o.Morphology != NoMorphology) && o.Format.levels() == 0:
+		return fmt.Errorf("filters are not supported for %s", o.Format)
+	}
+	return nil
+}
+
+// sharpen applies an unsharp mask to luma in place: the difference between
+// every pixel and a blur of its surroundings is added again, opts.Sharpen times.
+func sharpen(luma []int32, width, height int, opts Options) {
+	src := make([]float32, len(luma))
+	for i, v := range luma {
+		src[i] = float32(v)
+	}
+	blurred := gaussianBlur(src, width, height, cmp.Or(opts.SharpenRadius, 1))
+	amount := float32(opts.Sharpen)
+	parallelRows(width, height, func(y0, y1 int) {
+		for i := y0 * width; i < y1*width; i++ {
+			v := src[i] + amount*(src[i]-blurred[i])
+			luma[i] = int32(min(max(v, 0), 0xFFFF))
+		}
+	})
+}
+
+// sobelEdges replaces luma by the strength of its gradients, dark where they
+// are strong and white where the image is flat.
+func sobelEdges(luma []int32, width, height int) {
+	gx, gy := gradients(normalize(luma), width, height)
+	parallelRows(width, height, func(y0, y1 int) {
+		for i := y0 * width; i < y1*width; i++ {
+			// A step from black to white has a gradient of 4
+			m := math.Hypot(float64(gx[i]), float64(gy[i])) / 4
+			luma[i] = int32(math.Round((1 - min(m, 1)) * 0xFFFF))
+		}
+	})
+}
+
+// cannyEdges replaces luma by its Canny edges, black on white. The image is
+// smoothed at the scale of opts.EdgeRadius first.
+func cannyEdges(luma []int32, width, height int, opts Options) {
+	gx, gy := gradients(gaussianBlur(normalize(luma), width, height, cmp.Or(opts.EdgeRadius, 1)), width, height)
+
+	// Keep the ridges: pixels at least as strong as both neighbors across the edge
+	at := func(s []float32, x, y int) float32 {
+		return s[min(max(y, 0), height-1)*width+min(max(x, 0), width-1)]
+	}
+	mag := make([]float32, len(luma))
+	for i := range mag {
+		mag[i] = float32(math.Hypot(float64(gx[i]), float64(gy[i])))
+	}
+	ridge := make([]float32, len(luma))
+	tan22 := float32(math.Tan(math.Pi / 8))
+	parallelRows(width, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				i := y*width + x
+				ax, ay := abs(gx[i]), abs(gy[i])
+				dx, dy := 1, 1
+				switch {
+				case ay <= ax*tan22:
+					dy = 0
+				case ax <= ay*tan22:
+					dx = 0
+				case gx[i]*gy[i] < 0:
+					dx = -1
+				}
+				if m := mag[i]; m > 0 && m >= at(mag, x-dx, y-dy) && m > at(mag, x+dx, y+dy) {
+					ridge[i] = m
+				}
+			}
+		}
+	})
+
+	// Follow the edges from the strong pixels through the weaker ones
+	strongest := float32(0)
+	for _, m := range ridge {
+		strongest = max(strongest, m)
+	}
+	edge := make([]bool, len(luma))
+	var stack []int
+	for i, m := range ridge {
+		if strongest > 0 && m >= cannyHigh*strongest {
+			edge[i] = true
+			stack = append(stack, i)
+		}
+	}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y := i%width, i/width
+		for ny := max(y-1, 0); ny <= min(y+1, height-1); ny++ {
+			for nx := max(x-1, 0); nx <= min(x+1, width-1); nx++ {
+				if j := ny*width + nx; !edge[j] && ridge[j] >= cannyLow*strongest && ridge[j] > 0 {
+					edge[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+	}
+	for i, e := range edge {
+		if e {
+			luma[i] = 0
+		} else {
+			luma[i] = 0xFFFF
+		}
+	}
+}
+
+// morph dilates or erodes the dark areas of values, quantized brightness
+// levels where 0 is black, by a square of opts.MorphologyRadius pixels.
+func morph(values []uint32, width, height int, opts Options) []uint32 {
+	r := cmp.Or(opts.MorphologyRadius, 1)
+	// Dark areas grow where every pixel takes the darkest of its neighborhood
+	pick := func(a, b uint32) uint32 { return min(a, b) }
+	if opts.Morphology == Erode {
+		pick = func(a, b uint32) uint32 { return max(a, b) }
+	}
+
+	// The square is a row and then a column, each taking the pick of 2r+1 pixels
+	rows := make([]uint32, len(values))
+	parallelRows(width, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := values[y*width : (y+1)*width]
+			for x := range row {
+				v := row[x]
+				for nx := max(x-r, 0); nx <= min(x+r, width-1); nx++ {
+					v = pick(v, row[nx])
+				}
+				rows[y*width+x] = v
+			}
+		}
+	})
+	out := make([]uint32, len(values))
+	parallelRows(width, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				v := rows[y*width+x]
+				for ny := max(y-r, 0); ny <= min(y+r, height-1); ny++ {
+					v = pick(v, rows[ny*width+x])
+				}
+				out[y*width+x] = v
+			}
+		}
+	})
+	return out
+}
+
+// normalize returns luma scaled to 0..1.
+func normalize(luma []int32) []float32 {
+	out := make([]float32, len(luma))
+	for i, v := range luma {
+		out[i] = float32(v) / 0xFFFF
+	}
+	return out
+}
+
+// gaussianBlur returns src blurred by a Gaussian with a standard deviation of
+// sigma pixels. Pixels beyond the borders repeat the nearest ones.
+func gaussianBlur(src []float32, width, height int, sigma float64) []float32 {
+	r := max(1, int(math.Ceil(3*sigma)))
+	kernel := make([]float32, 2*r+1)
+	sum := float32(0)
+	for i := range kernel {
+		d := float64(i - r)
+		kernel[i] = float32(math.Exp(-d * d / (2 * sigma * sigma)))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	// The Gaussian is separable: blur the rows, then the columns
+	rows := make([]float32, len(src))
+	parallelRows(width, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := src[y*width : (y+1)*width]
+			for x := range row {
+				acc := float32(0)
+				for k, w := range kernel {
+					acc += w * row[min(max(x+k-r, 0), width-1)]
+				}
+				rows[y*width+x] = acc
+			}
+		}
+	})
+	out := make([]float32, len(src))
+	parallelRows(width, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				acc := float32(0)
+				for k, w := range kernel {
+					acc += w * rows[min(max(y+k-r, 0), height-1)*width+x]
+				}
+				out[y*width+x] = acc
+			}
+		}
+	})
+	return out
+}
+
+// gradients returns the horizontal and vertical Sobel gradients of src.
+// Pixels beyond the borders repeat the nearest ones.
+func gradients(src []float32, width, height int) (gx, gy []float32) {
+	gx, gy = make([]float32, len(src)), make([]float32, len(src))
+	at := func(x, y int) float32 {
+		return src[min(max(y, 0), height-1)*width+min(max(x, 0), width-1)]
+	}
+	parallelRows(width, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				i := y*width + x
+				gx[i] = at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+				gy[i] = at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			}
+		}
+	})
+	return gx, gy
+}
+
+// abs returns the absolute value of v.
+func abs(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
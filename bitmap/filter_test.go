@@ -0,0 +1,168 @@
+package bitmap
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+// square returns a size x size white image with a black square in the middle
+func square(size, inner int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	lo, hi := (size-inner)/2, (size+inner)/2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if x >= lo && x < hi && y >= lo && y < hi {
+				img.SetGray(x, y, color.Gray{})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 0xFF})
+			}
+		}
+	}
+	return img
+}
+
+// inkRow returns which pixels of row y of a Mono1 bitmap are ink
+func inkRow(bm *Bitmap, y int) []bool {
+	row := make([]bool, bm.Width)
+	for x := range row {
+		row[x] = bm.Gray(x, y) < 0x80
+	}
+	return row
+}
+
+func TestConvertSharpen(t *testing.T) {
+	// A soft step from dark to light gray gets steeper
+	src := grayRamp(32, 1, 64, 192)
+	plain := convertGray8(t, src, Options{})
+	got := convertGray8(t, src, Options{Sharpen: 2, SharpenRadius: 2})
+	if got[0] >= plain[0] || got[31] <= plain[31] {
+		t.Errorf("Expected the ends to spread, got %d..%d from %d..%d", got[0], got[31], plain[0], plain[31])
+	}
+	// A flat image has no detail to bring out
+	flat := grayRamp(8, 8, 100, 100)
+	if got := convertGray8(t, flat, Options{Sharpen: 5}); slices.Min(got) != slices.Max(got) {
+		t.Errorf("Expected a flat image to stay flat, got % X", got)
+	}
+}
+
+func TestConvertEdges(t *testing.T) {
+	for _, edges := range []Edges{Sobel, Canny} {
+		t.Run(edges.String(), func(t *testing.T) {
+			bm, err := Convert(square(24, 12), Options{Edges: edges})
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			// Only the outline of the square is ink: not the inside, not the paper
+			row := inkRow(bm, 12)
+			if row[2] || row[12] || row[21] {
+				t.Errorf("Expected paper inside and outside the square, got %v", row)
+			}
+			if !row[5] && !row[6] || !row[17] && !row[18] {
+				t.Errorf("Expected ink on the sides of the square, got %v", row)
+			}
+			if edges == Canny && slices.Index(row, true) >= 0 {
+				// Canny outlines are thin
+				n := 0
+				for _, ink := range row {
+					if ink {
+						n++
+					}
+				}
+				if n > 4 {
+					t.Errorf("Expected thin outlines, got %d pixels of ink in %v", n, row)
+				}
+			}
+		})
+	}
+
+	// A flat image has no edges
+	bm, err := Convert(grayRamp(8, 8, 30, 30), Options{Edges: Canny})
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if slices.Contains(inkRow(bm, 4), true) {
+		t.Errorf("Expected no edges in a flat image, got % X", bm.Data)
+	}
+
+	// Canny smooths at EdgeRadius and ignores the sharpen radius
+	canny := func(opts Options) []byte {
+		opts.Edges = Canny
+		bm, err := Convert(square(24, 12), opts)
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		return bm.Data
+	}
+	plain := canny(Options{})
+	if got := canny(Options{SharpenRadius: 4}); !bytes.Equal(got, plain) {
+		t.Errorf("Expected the sharpen radius to leave the edges alone, got % X, want % X", got, plain)
+	}
+	if got := canny(Options{EdgeRadius: 4}); bytes.Equal(got, plain) {
+		t.Errorf("Expected a wider edge radius to change the edges, got % X", got)
+	}
+}
+
+func TestConvertMorphology(t *testing.T) {
+	// A vertical stroke 3 pixels wide
+	src := image.NewGray(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			if x >= 6 && x < 9 {
+				src.SetGray(x, y, color.Gray{})
+			} else {
+				src.SetGray(x, y, color.Gray{Y: 0xFF})
+			}
+		}
+	}
+	count := func(opts Options) int {
+		bm, err := Convert(src, opts)
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		n := 0
+		for _, ink := range inkRow(bm, 4) {
+			if ink {
+				n++
+			}
+		}
+		return n
+	}
+	tests := []struct {
+		opts     Options
+		expected int
+	}{
+		{opts: Options{}, expected: 3},
+		{opts: Options{Morphology: Dilate}, expected: 5},
+		{opts: Options{Morphology: Dilate, MorphologyRadius: 2}, expected: 7},
+		{opts: Options{Morphology: Erode}, expected: 1},
+		{opts: Options{Morphology: Erode, MorphologyRadius: 2}, expected: 0},
+		// Strokes are dark in the image, whatever the stored polarity
+		{opts: Options{Morphology: Dilate, Polarity: HighWhite}, expected: 5},
+		{opts: Options{Morphology: Dilate, Format: Gray4}, expected: 5},
+	}
+	for _, tt := range tests {
+		if got := count(tt.opts); got != tt.expected {
+			t.Errorf("%s %d: expected a stroke of %d, got %d", tt.opts.Morphology, tt.opts.MorphologyRadius, tt.expected, got)
+		}
+	}
+}
+
+func TestConvertFiltersInvalid(t *testing.T) {
+	for _, opts := range []Options{
+		{Sharpen: -1},
+		{Sharpen: 6},
+		{SharpenRadius: 100},
+		{Edges: Edges(5)},
+		{EdgeRadius: 100},
+		{Morphology: Morphology(5)},
+		{Morphology: Dilate, MorphologyRadius: -1},
+		{Format: RGB888, Edges: Sobel},
+	} {
+		if _, err := Convert(square(4, 2), opts); err == nil {
+			t.Errorf("Expected an error for %+v, got nil", opts)
+		}
+	}
+}
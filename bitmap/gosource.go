@@ -0,0 +1,366 @@
+package bitmap
+
+import (
+	"bufio"
+	"fmt"
+	"go/token"
+	"io"
+	"slices"
+	"strings"
+
+	"image2bytes/compress"
+)
+
+// GoOptions control the Go source written by WriteGo.
+type GoOptions struct {
+	// Package is the package clause of the file; "main" when empty.
+	Package string
+	// Name is the identifier of the byte array. The constants are prefixed with it.
+	Name string
+	// Compression holds the compressed data to write instead of the packed
+	// pixels, or nil to write them as they are.
+	Compression *compress.Result
+	// Data is how the byte array is spelled.
+	Data DataStyle
+	// Mask is written after the bitmap as its transparency mask, or nil.
+	Mask *Asset
+}
+
+// DataStyle is how the byte array of an asset is spelled in Go source. Every
+// way the array is a []byte variable.
+type DataStyle int
+
+const (
+	// DataBytes writes a composite literal of hex bytes, 12 per line.
+	DataBytes DataStyle = iota
+	// DataString converts a string literal of hex escapes on a single line,
+	// which the compiler handles much faster than a large composite literal.
+	DataString
+	// DataEmbed declares NameData with a go:embed directive for the file named
+	// by EmbedFile, which the caller writes next to the Go file, and checks its
+	// length when the program starts.
+	DataEmbed
+)
+
+var dataStyleNames = []string{"bytes", "string", "embed"}
+
+func (d DataStyle) String() string               { return enumString(dataStyleNames, int(d)) }
+func (d DataStyle) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+func (d *DataStyle) UnmarshalText(b []byte) error {
+	return unmarshalEnum("data style", dataStyleNames, b, (*int)(d))
+}
+
+// Asset is one bitmap of a Go file holding several, written by WriteGoAssets.
+type Asset struct {
+	// Key identifies the asset in the index map, usually its source file name.
+	Key string
+	// Name is the identifier of the byte array. The constants are prefixed with it.
+	Name string
+	// Bitmap is the packed image.
+	Bitmap *Bitmap
+	// Compression holds the compressed data to write instead of the packed
+	// pixels, or nil to write them as they are.
+	Compression *compress.Result
+	// Data is how the byte array is spelled.
+	Data DataStyle
+	// Mask is a transparency mask written after the bitmap, usually made by
+	// ConvertMask and named after the asset with a Mask suffix, or nil. Its Key
+	// and own Mask are ignored.
+	Mask *Asset
+}
+
+// AssetSuffixes returns the suffixes appended to an asset's name to form the
+// names of its constants, for an asset written in the data style and, when
+// masked, with a mask named with a Mask suffix. Together with the name itself
+// they are every identifier the asset declares.
+func AssetSuffixes(data DataStyle, masked bool) []string {
+	suffixes := []string{"Width", "Height", "Format", "BitOrder", "Polarity", "Codec", "Size", "Window", "Lookahead"}
+	if data == DataEmbed {
+		suffixes = append(suffixes, "Data")
+	}
+	if masked {
+		suffixes = append(suffixes, "Mask")
+		for _, s := range suffixes[:len(suffixes)-1] {
+			// Masks have no polarity
+			if s != "Polarity" {
+				suffixes = append(suffixes, "Mask"+s)
+			}
+		}
+	}
+	return suffixes
+}
+
+// Bytes returns the data the byte array of a holds: the compressed data when
+// there is compression, the packed pixels otherwise.
+func (a Asset) Bytes() []byte {
+	if c := a.Compression; c != nil && c.Codec.Name() != "none" {
+		return c.Data
+	}
+	return a.Bitmap.Data
+}
+
+// EmbedFile returns the name of the file a DataEmbed asset is embedded from,
+// relative to its Go file: the lower-case name with a .bin extension.
+func (a Asset) EmbedFile() string {
+	return strings.ToLower(a.Name) + ".bin"
+}
+
+// dataName returns the identifier of the byte array of a.
+func (a Asset) dataName() string {
+	if a.Data == DataEmbed {
+		return a.Name + "Data"
+	}
+	return a.Name
+}
+
+// WriteGo writes b as a Go file declaring the byte array and the constants
+// firmware needs to interpret it.
+func WriteGo(w io.Writer, b *Bitmap, opts GoOptions) error {
+	return WriteGoAssets(w, opts.Package, []Asset{{Name: opts.Name, Bitmap: b, Compression: opts.Compression, Data: opts.Data, Mask: opts.Mask}}, "")
+}
+
+// WriteGoAssets writes several bitmaps as one Go file in package pkg ("main"
+// when empty). When index is not empty, the file also declares a Bitmap type and
+// a map[string]Bitmap called index, keyed by each asset's Key.
+func WriteGoAssets(w io.Writer, pkg string, assets []Asset, index string) error {
+	return writeGoFile(w, pkg, assets, true, index)
+}
+
+// WriteGoIndex writes only the Bitmap type and the index map for assets that
+// other files of package pkg declare, one WriteGo call each.
+func WriteGoIndex(w io.Writer, pkg string, assets []Asset, index string) error {
+	if index == "" {
+		return fmt.Errorf("missing index name")
+	}
+	return writeGoFile(w, pkg, assets, false, index)
+}
+
+// writeGoFile writes the package clause, then the assets when declare is set,
+// then the index when it has a name.
+func writeGoFile(w io.Writer, pkg string, assets []Asset, declare bool, index string) error {
+	if pkg == "" {
+		pkg = "main"
+	}
+	if !token.IsIdentifier(pkg) || (index != "" && !token.IsIdentifier(index)) {
+		return fmt.Errorf("invalid package %q or index %q", pkg, index)
+	}
+	embeds := map[string]string{}
+	for _, a := range withMasks(assets) {
+		if !token.IsIdentifier(a.Name) {
+			return fmt.Errorf("invalid package %q or name %q", pkg, a.Name)
+		}
+		if a.Data < DataBytes || a.Data > DataEmbed {
+			return fmt.Errorf("unknown data style %s", a.Data)
+		}
+		if a.Data != DataEmbed {
+			continue
+		}
+		if other, ok := embeds[a.EmbedFile()]; ok {
+			return fmt.Errorf("%s and %s would both be embedded from %s", other, a.Name, a.EmbedFile())
+		}
+		embeds[a.EmbedFile()] = a.Name
+	}
+
+	// Writes to bw are not checked one by one: a failure sticks and is reported by Flush
+	bw := bufio.NewWriter(w)
+
+	// Start with the package declaration
+	fmt.Fprintf(bw, "package %s\n", pkg)
+	if declare && len(embeds) > 0 {
+		fmt.Fprintf(bw, "\nimport _ \"embed\"\n")
+	}
+	if declare {
+		for _, a := range assets {
+			fmt.Fprintf(bw, "\n")
+			writeGoAsset(bw, a, false)
+			if a.Mask != nil {
+				fmt.Fprintf(bw, "\n")
+				writeGoAsset(bw, *a.Mask, true)
+			}
+		}
+	}
+	if index != "" {
+		fmt.Fprintf(bw, "\n")
+		writeGoIndex(bw, assets, index)
+	}
+
+	return bw.Flush()
+}
+
+// withMasks returns the assets, each followed by its mask if it has one.
+func withMasks(assets []Asset) []Asset {
+	all := make([]Asset, 0, len(assets))
+	for _, a := range assets {
+		all = append(all, a)
+		if a.Mask != nil {
+			all = append(all, *a.Mask)
+		}
+	}
+	return all
+}
+
+// writeGoAsset declares the constants and the byte array of one asset, or of
+// the mask of one. A set bit of a mask is always an opaque pixel, so masks
+// have no polarity.
+func writeGoAsset(bw *bufio.Writer, a Asset, mask bool) {
+	name, b := a.Name, a.Bitmap
+
+	// Declare the image dimensions and layout
+	fmt.Fprintf(bw, "// %sWidth and %sHeight define image dimensions\n", name, name)
+	fmt.Fprintf(bw, "const %sWidth = %d\n", name, b.Width)
+	fmt.Fprintf(bw, "const %sHeight = %d\n\n", name, b.Height)
+	fmt.Fprintf(bw, "// %sFormat and %sBitOrder describe how pixels are packed\n", name, name)
+	fmt.Fprintf(bw, "const %sFormat = %q\n", name, b.Format)
+	fmt.Fprintf(bw, "const %sBitOrder = %q\n\n", name, b.BitOrder)
+	if mask {
+		fmt.Fprintf(bw, "// %s is a transparency mask: a set bit is an opaque pixel\n\n", name)
+	} else {
+		fmt.Fprintf(bw, "// %sPolarity is what the highest pixel value shows: black or white\n", name)
+		fmt.Fprintf(bw, "const %sPolarity = %q\n\n", name, b.polarity())
+	}
+	// Describe the compression, if any
+	if c := a.Compression; c != nil && c.Codec.Name() != "none" {
+		fmt.Fprintf(bw, "// %s is compressed with %s and decompresses to %sSize bytes\n", name, c.Codec.Name(), name)
+		fmt.Fprintf(bw, "const %sCodec = %q\n", name, c.Codec.Name())
+		fmt.Fprintf(bw, "const %sSize = %d\n", name, c.RawSize)
+		if p := c.Codec.Params(); p.Window > 0 {
+			fmt.Fprintf(bw, "const %sWindow = %d\n", name, p.Window)
+			if p.Lookahead > 0 {
+				fmt.Fprintf(bw, "const %sLookahead = %d\n", name, p.Lookahead)
+			}
+		}
+		fmt.Fprintf(bw, "\n")
+	}
+	switch a.Data {
+	case DataString:
+		writeStringData(bw, name, a.Bytes())
+	case DataEmbed:
+		writeEmbedData(bw, a)
+	default:
+		writeByteData(bw, name, a.Bytes())
+	}
+}
+
+// hexDigits are the upper-case hex digits the data is spelled with.
+const hexDigits = "0123456789ABCDEF"
+
+// writeByteData declares the byte array as a composite literal, 12 bytes per
+// line as gofmt leaves it. Lines are assembled from the digits of each byte
+// rather than formatted one byte at a time.
+func writeByteData(bw *bufio.Writer, name string, data []byte) {
+	const perLine = 12
+	fmt.Fprintf(bw, "var %s = []byte{\n", name)
+	line := make([]byte, 0, 1+perLine*len("0x00, "))
+	for len(data) > 0 {
+		n := min(perLine, len(data))
+		line = append(line[:0], '\t')
+		for i, v := range data[:n] {
+			if i > 0 {
+				line = append(line, ' ')
+			}
+			line = append(line, '0', 'x', hexDigits[v>>4], hexDigits[v&0x0F], ',')
+		}
+		line = append(line, '\n')
+		bw.Write(line)
+		data = data[n:]
+	}
+	bw.WriteString("}\n")
+}
+
+// writeStringData declares the byte array as the conversion of one string
+// literal of hex escapes. Splitting it over lines would take a chain of +
+// operators, which compiles far slower than the composite literal it replaces.
+func writeStringData(bw *bufio.Writer, name string, data []byte) {
+	fmt.Fprintf(bw, "var %s = []byte(\"", name)
+	buf := make([]byte, 0, 4*min(len(data), 4096))
+	for len(data) > 0 {
+		n := min(4096, len(data))
+		buf = buf[:0]
+		for _, v := range data[:n] {
+			buf = append(buf, '\\', 'x', hexDigits[v>>4], hexDigits[v&0x0F])
+		}
+		bw.Write(buf)
+		data = data[n:]
+	}
+	bw.WriteString("\")\n")
+}
+
+// writeEmbedData declares the byte array embedded from the file of a, and an
+// init function that panics when the file does not have the length this file
+// was generated for: a sidecar regenerated without its Go file, or the reverse.
+func writeEmbedData(bw *bufio.Writer, a Asset) {
+	name, file := a.dataName(), a.EmbedFile()
+	fmt.Fprintf(bw, "// %s holds the data of %s, embedded from %s\n", name, a.Name, file)
+	fmt.Fprintf(bw, "//\n//go:embed %s\n", file)
+	fmt.Fprintf(bw, "var %s []byte\n\n", name)
+
+	// Packed pixels have a length that follows from the dimensions, but
+	// compressed data can only be checked against the length it had
+	want := fmt.Sprintf("%sHeight*((%sWidth*%d+7)/8)", a.Name, a.Name, a.Bitmap.Format.BitsPerPixel())
+	if c := a.Compression; c != nil && c.Codec.Name() != "none" {
+		want = fmt.Sprint(len(c.Data))
+	}
+	fmt.Fprintf(bw, "func init() {\n")
+	fmt.Fprintf(bw, "\tif len(%s) != %s {\n", name, want)
+	fmt.Fprintf(bw, "\t\tpanic(\"%s does not match the constants of %s; regenerate both\")\n", file, a.Name)
+	fmt.Fprintf(bw, "\t}\n")
+	fmt.Fprintf(bw, "}\n")
+}
+
+// writeGoIndex declares the Bitmap type and a map describing every asset.
+func writeGoIndex(bw *bufio.Writer, assets []Asset, index string) {
+	fmt.Fprintf(bw, "// Bitmap describes a packed image and how to decode it\n")
+	fmt.Fprintf(bw, "type Bitmap struct {\n")
+	fmt.Fprintf(bw, "\tWidth, Height     int\n")
+	fmt.Fprintf(bw, "\tFormat, BitOrder  string\n")
+	fmt.Fprintf(bw, "\tPolarity          string\n")
+	fmt.Fprintf(bw, "\tCodec             string\n")
+	fmt.Fprintf(bw, "\tSize              int // decompressed length of Data\n")
+	fmt.Fprintf(bw, "\tWindow, Lookahead int\n")
+	fmt.Fprintf(bw, "\tData              []byte\n")
+	// Only indexes of masked assets have masks, so others stay as they were
+	masks := slices.ContainsFunc(assets, func(a Asset) bool { return a.Mask != nil })
+	if masks {
+		fmt.Fprintf(bw, "\tMask              *Bitmap // 1bpp transparency mask, a set bit opaque, or nil\n")
+	}
+	fmt.Fprintf(bw, "}\n\n")
+
+	fmt.Fprintf(bw, "// %s maps source file names to their bitmaps\n", index)
+	fmt.Fprintf(bw, "var %s = map[string]Bitmap{\n", index)
+	for _, a := range assets {
+		fmt.Fprintf(bw, "\t%q: {\n", a.Key)
+		writeIndexFields(bw, a, false, "\t\t")
+		if a.Mask != nil {
+			fmt.Fprintf(bw, "\t\tMask: &Bitmap{\n")
+			writeIndexFields(bw, *a.Mask, true, "\t\t\t")
+			fmt.Fprintf(bw, "\t\t},\n")
+		}
+		fmt.Fprintf(bw, "\t},\n")
+	}
+	fmt.Fprintf(bw, "}\n")
+}
+
+// writeIndexFields writes the fields of the Bitmap describing a, or the mask
+// a is, one group per line, each line starting with indent.
+func writeIndexFields(bw *bufio.Writer, a Asset, mask bool, indent string) {
+	name := a.Name
+	fmt.Fprintf(bw, "%sWidth: %sWidth, Height: %sHeight,\n", indent, name, name)
+	if mask {
+		fmt.Fprintf(bw, "%sFormat: %sFormat, BitOrder: %sBitOrder,\n", indent, name, name)
+	} else {
+		fmt.Fprintf(bw, "%sFormat: %sFormat, BitOrder: %sBitOrder, Polarity: %sPolarity,\n", indent, name, name, name)
+	}
+	if c := a.Compression; c != nil && c.Codec.Name() != "none" {
+		fmt.Fprintf(bw, "%sCodec: %sCodec, Size: %sSize,\n", indent, name, name)
+		if p := c.Codec.Params(); p.Window > 0 {
+			fmt.Fprintf(bw, "%sWindow: %sWindow,", indent, name)
+			if p.Lookahead > 0 {
+				fmt.Fprintf(bw, " Lookahead: %sLookahead,", name)
+			}
+			fmt.Fprintf(bw, "\n")
+		}
+	} else {
+		fmt.Fprintf(bw, "%sCodec: \"none\", Size: len(%s),\n", indent, a.dataName())
+	}
+	fmt.Fprintf(bw, "%sData: %s,\n", indent, a.dataName())
+}
@@ -0,0 +1,274 @@
+package bitmap
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"image2bytes/compress"
+)
+
+func TestWriteGo(t *testing.T) {
+	bm, err := New(4, 2, Gray4, LSBFirst, []byte{0x01, 0x23, 0x45, 0x67})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteGo(&buf, bm, GoOptions{Package: "assets", Name: "Logo"}); err != nil {
+		t.Fatalf("WriteGo failed: %v", err)
+	}
+	for _, expected := range []string{
+		"package assets\n",
+		"const LogoWidth = 4\n",
+		"const LogoHeight = 2\n",
+		"const LogoFormat = \"gray4\"\n",
+		"const LogoBitOrder = \"lsb\"\n",
+		"const LogoPolarity = \"white\"\n",
+		"var Logo = []byte{\n\t0x01, 0x23, 0x45, 0x67,\n}\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Output does not contain %q:\n%s", expected, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "LogoCodec") {
+		t.Errorf("Expected no compression constants for uncompressed data")
+	}
+}
+
+// TestWriteGoDataStyles checks that every data style is gofmt-clean, and that
+// a string holds the same bytes, including ones that need escaping.
+func TestWriteGoDataStyles(t *testing.T) {
+	data := make([]byte, 300)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	bm, err := New(300, 1, Gray8, MSBFirst, data)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	for _, style := range []DataStyle{DataBytes, DataString, DataEmbed} {
+		var buf bytes.Buffer
+		if err := WriteGo(&buf, bm, GoOptions{Name: "Logo", Data: style}); err != nil {
+			t.Fatalf("WriteGo(%s) failed: %v", style, err)
+		}
+		formatted, err := format.Source(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: output does not parse: %v", style, err)
+		}
+		if !bytes.Equal(formatted, buf.Bytes()) {
+			t.Errorf("%s: output is not gofmt-clean:\n%s", style, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteGo(&buf, bm, GoOptions{Name: "Logo", Data: DataString}); err != nil {
+		t.Fatalf("WriteGo failed: %v", err)
+	}
+	_, lit, ok := strings.Cut(buf.String(), "var Logo = []byte(")
+	if !ok {
+		t.Fatalf("Expected a string literal:\n%s", buf.String())
+	}
+	lit, _, _ = strings.Cut(lit, ")\n")
+	s, err := strconv.Unquote(lit)
+	if err != nil {
+		t.Fatalf("Unquote failed: %v", err)
+	}
+	if s != string(data) {
+		t.Errorf("Expected % X, got % X", data, s)
+	}
+
+	if err := WriteGo(&buf, bm, GoOptions{Name: "Logo", Data: DataStyle(9)}); err == nil {
+		t.Errorf("Expected an error for an unknown data style, got nil")
+	}
+}
+
+func TestWriteGoEmbed(t *testing.T) {
+	bm, err := New(4, 2, Gray4, LSBFirst, []byte{0x01, 0x23, 0x45, 0x67})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteGo(&buf, bm, GoOptions{Name: "Logo", Data: DataEmbed}); err != nil {
+		t.Fatalf("WriteGo failed: %v", err)
+	}
+	for _, expected := range []string{
+		"package main\n\nimport _ \"embed\"\n",
+		"const LogoWidth = 4\n",
+		"//go:embed logo.bin\nvar LogoData []byte\n",
+		"if len(LogoData) != LogoHeight*((LogoWidth*4+7)/8) {",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Output does not contain %q:\n%s", expected, buf.String())
+		}
+	}
+
+	// Compressed data is checked against the length it was written with
+	results, err := compress.Compress("lzss", compress.DefaultParams, bm.Data)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	buf.Reset()
+	if err := WriteGo(&buf, bm, GoOptions{Name: "Logo", Data: DataEmbed, Compression: &results[0]}); err != nil {
+		t.Fatalf("WriteGo failed: %v", err)
+	}
+	if expected := fmt.Sprintf("if len(LogoData) != %d {", len(results[0].Data)); !strings.Contains(buf.String(), expected) {
+		t.Errorf("Output does not contain %q:\n%s", expected, buf.String())
+	}
+
+	// Names that differ only in case would share a file
+	assets := []Asset{{Name: "Logo", Bitmap: bm, Data: DataEmbed}, {Name: "LOGO", Bitmap: bm, Data: DataEmbed}}
+	if err := WriteGoAssets(&bytes.Buffer{}, "", assets, ""); err == nil {
+		t.Errorf("Expected an error for assets sharing logo.bin, got nil")
+	}
+}
+
+func TestWriteGoMask(t *testing.T) {
+	bm, err := New(4, 2, Gray4, LSBFirst, []byte{0x01, 0x23, 0x45, 0x67})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	mask, err := New(4, 2, Mono1, LSBFirst, []byte{0x0F, 0x06})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	opts := GoOptions{Name: "Logo", Mask: &Asset{Name: "LogoMask", Bitmap: mask}}
+	if err := WriteGo(&buf, bm, opts); err != nil {
+		t.Fatalf("WriteGo failed: %v", err)
+	}
+	for _, expected := range []string{
+		"var Logo = []byte{\n",
+		"const LogoMaskWidth = 4\n",
+		"const LogoMaskFormat = \"mono\"\n",
+		"const LogoMaskBitOrder = \"lsb\"\n",
+		"var LogoMask = []byte{\n\t0x0F, 0x06,\n}\n",
+		"// LogoMask is a transparency mask: a set bit is an opaque pixel\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Output does not contain %q:\n%s", expected, buf.String())
+		}
+	}
+	// A set bit of a mask always means opaque, whatever the polarity of the bitmap
+	if strings.Contains(buf.String(), "LogoMaskPolarity") {
+		t.Errorf("Expected the mask to have no polarity:\n%s", buf.String())
+	}
+
+	// The index describes the mask of the assets that have one
+	assets := []Asset{{Key: "a.png", Name: "A", Bitmap: bm, Mask: &Asset{Name: "AMask", Bitmap: mask}}, {Key: "b.png", Name: "B", Bitmap: bm}}
+	buf.Reset()
+	if err := WriteGoAssets(&buf, "icons", assets, "Bitmaps"); err != nil {
+		t.Fatalf("WriteGoAssets failed: %v", err)
+	}
+	for _, expected := range []string{
+		"\tMask              *Bitmap",
+		"\t\tMask: &Bitmap{\n\t\t\tWidth: AMaskWidth, Height: AMaskHeight,\n\t\t\tFormat: AMaskFormat, BitOrder: AMaskBitOrder,\n",
+		"\t\t\tData: AMask,\n\t\t},\n\t},\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Output does not contain %q:\n%s", expected, buf.String())
+		}
+	}
+	if strings.Count(buf.String(), "Mask: &Bitmap{") != 1 {
+		t.Errorf("Expected only A to have a mask:\n%s", buf.String())
+	}
+	if formatted, err := format.Source(buf.Bytes()); err != nil || !bytes.Equal(formatted, buf.Bytes()) {
+		t.Errorf("Output is not gofmt-clean (%v):\n%s", err, buf.String())
+	}
+}
+
+func BenchmarkWriteGo(b *testing.B) {
+	bm, err := New(320, 240, RGB565, MSBFirst, make([]byte, 320*240*2))
+	if err != nil {
+		b.Fatalf("New failed: %v", err)
+	}
+	for _, style := range []DataStyle{DataBytes, DataString} {
+		b.Run(style.String(), func(b *testing.B) {
+			b.SetBytes(int64(len(bm.Data)))
+			for b.Loop() {
+				if err := WriteGo(io.Discard, bm, GoOptions{Name: "Logo", Data: style}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteGoCompressed(t *testing.T) {
+	bm, err := New(8, 8, Mono1, MSBFirst, make([]byte, 8))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	results, err := compress.Compress("heatshrink", compress.DefaultParams, bm.Data)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteGo(&buf, bm, GoOptions{Name: "Logo", Compression: &results[0]}); err != nil {
+		t.Fatalf("WriteGo failed: %v", err)
+	}
+	for _, expected := range []string{
+		"const LogoCodec = \"heatshrink\"\n",
+		"const LogoSize = 8\n",
+		"const LogoWindow = 8\n",
+		"const LogoLookahead = 4\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Output does not contain %q:\n%s", expected, buf.String())
+		}
+	}
+}
+
+func TestWriteGoInvalidName(t *testing.T) {
+	bm, _ := New(0, 0, Mono1, MSBFirst, nil)
+	for _, opts := range []GoOptions{{Name: "my-logo"}, {Name: "Logo", Package: "1pkg"}} {
+		if err := WriteGo(&bytes.Buffer{}, bm, opts); err == nil {
+			t.Errorf("Expected an error for %+v, got nil", opts)
+		}
+	}
+}
+
+func TestWriteGoAssets(t *testing.T) {
+	bm, err := New(8, 1, Mono1, MSBFirst, []byte{0xA5})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	assets := []Asset{{Key: "a.png", Name: "A", Bitmap: bm}, {Key: "b.png", Name: "B", Bitmap: bm}}
+
+	var buf bytes.Buffer
+	if err := WriteGoAssets(&buf, "icons", assets, "Bitmaps"); err != nil {
+		t.Fatalf("WriteGoAssets failed: %v", err)
+	}
+	for _, expected := range []string{
+		"package icons\n\n// AWidth",
+		"var A = []byte{\n\t0xA5,\n}\n",
+		"var B = []byte{\n\t0xA5,\n}\n",
+		"type Bitmap struct {",
+		"var Bitmaps = map[string]Bitmap{\n\t\"a.png\": {\n",
+		"\t\tCodec: \"none\", Size: len(B),\n\t\tData: B,\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Output does not contain %q:\n%s", expected, buf.String())
+		}
+	}
+
+	// The index alone refers to arrays declared elsewhere
+	buf.Reset()
+	if err := WriteGoIndex(&buf, "icons", assets, "Bitmaps"); err != nil {
+		t.Fatalf("WriteGoIndex failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "[]byte{") || !strings.Contains(buf.String(), "Data: A,") {
+		t.Errorf("Expected only the index, got:\n%s", buf.String())
+	}
+	if err := WriteGoIndex(&buf, "icons", assets, ""); err == nil {
+		t.Errorf("Expected an error without an index name, got nil")
+	}
+}
@@ -0,0 +1,671 @@
+package bitmap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// Options control how Convert turns an image into a Bitmap. The zero value
+// converts at the source resolution to 1bpp with a 50% threshold, MSB-first.
+type Options struct {
+	// Crop is the part of the source to convert, relative to its top-left
+	// corner. The zero value converts the whole source.
+	Crop Rect
+	// Rotate turns the image clockwise, and Flip then mirrors it. Both apply
+	// at the stage TransformStage picks.
+	Rotate Rotation
+	Flip   Flip
+	// TransformStage decides when Rotate and Flip apply: to the source, so
+	// Width and Height are the size of the result, or to the converted pixels,
+	// so Width and Height are the size before rotating.
+	TransformStage TransformStage
+	// Width and Height are the size of the bitmap. When both are zero the source
+	// size is kept; when one is zero it follows the source aspect ratio.
+	Width, Height int
+	// Resize decides how the source is fitted into Width x Height.
+	Resize ResizeMode
+	// Scaler is the interpolation used when resizing.
+	Scaler Scaler
+	// Margins keep the edges of the bitmap clear: the image is fitted and
+	// placed inside them, and they take the matte like padding does.
+	Margins Margins
+	// ImageWidth and ImageHeight are the size the source is fitted to when it
+	// should not cover the whole bitmap, like an icon on a larger screen. When
+	// both are zero it is the area inside Margins; when one is zero it follows
+	// the source aspect ratio. Parts of the image outside Margins are clipped.
+	ImageWidth, ImageHeight int
+	// Gravity is where the image sits inside Margins when it is smaller than
+	// the room there, like the letterboxed image of Fit, and which part of the
+	// source Fill and NoResize keep when they crop. The zero value centers.
+	Gravity Gravity
+	// Offset moves the image right and down from where Gravity puts it.
+	Offset Point
+	// Threshold is the luminance (1..255) below which a pixel becomes black in
+	// Mono1 without dithering. Zero means 128.
+	Threshold uint8
+	// Dither selects how gray levels are approximated with fewer levels.
+	Dither Dither
+	// Format is the pixel format of the packed data.
+	Format PixelFormat
+	// BitOrder is the order of pixels within a byte for formats under 8 bits per pixel.
+	BitOrder BitOrder
+	// Polarity is what the highest pixel value shows, for displays that
+	// disagree with the format. AutoPolarity keeps the format's own.
+	Polarity Polarity
+	// Invert flips every pixel value: black for white in Mono1, light for dark
+	// in the gray formats, and the complementary color in the RGB formats.
+	// Unlike Polarity, it changes the image: a decoded bitmap shows it inverted.
+	Invert bool
+	// Matte is the color transparent pixels are composited onto. It also fills
+	// the padding of Fit and NoResize and the bitmap around a placed image.
+	// The zero value is white.
+	Matte Color
+	// Alpha decides what the transparency of the source means.
+	Alpha AlphaMode
+	// Luma decides how a color becomes the luminance the gray formats are made
+	// of: a weighting of the channels, or a single one. It is not supported
+	// for the RGB formats.
+	Luma Luma
+
+	// The tone adjustments below shape the luminance before it is quantized,
+	// in linear light and in the order they are listed. They are not supported
+	// for the RGB formats, and are ignored with AlphaThreshold.
+
+	// AutoLevels stretches the tones so that this percentage of the pixels
+	// (0 up to 50) is clipped to black, and as many to white. Zero disables it.
+	AutoLevels float64
+	// Equalize spreads the tones evenly, over the whole image or region by region.
+	Equalize Equalize
+	// Gamma brightens the midtones when above 1 and darkens them below.
+	// Zero means 1, which leaves them as they are.
+	Gamma float64
+	// Brightness (-1 to 1) is added to the linear luminance.
+	Brightness float64
+	// Contrast (-1 to 1) pushes tones away from middle gray, or pulls them
+	// toward it when negative.
+	Contrast float64
+
+	// The filters below also only apply to Mono1 and the gray formats. The
+	// unsharp mask and the edge detection run after the tone adjustments, the
+	// morphology after quantizing.
+
+	// Sharpen is the amount of the unsharp mask: how much of the detail finer
+	// than SharpenRadius is added again, from 0 (off) to 5.
+	Sharpen float64
+	// SharpenRadius is the radius in pixels of the blur the unsharp mask
+	// subtracts. Zero means 1.
+	SharpenRadius float64
+	// Edges replaces the image by its outlines, drawn dark on light.
+	Edges Edges
+	// EdgeRadius is the radius in pixels of the blur Canny smooths the image
+	// with before it looks for edges. Zero means 1.
+	EdgeRadius float64
+	// Morphology thickens or thins dark strokes after quantizing, by
+	// MorphologyRadius pixels. Zero means 1.
+	Morphology       Morphology
+	MorphologyRadius int
+}
+
+// threshold returns the 16-bit luminance threshold.
+func (o Options) threshold() uint32 {
+	if o.Threshold == 0 {
+		return 0x8000
+	}
+	return uint32(o.Threshold) << 8
+}
+
+// ResizeMode decides how the source is fitted into the target size.
+type ResizeMode int
+
+const (
+	// Stretch scales the source to exactly the target size, ignoring its aspect ratio.
+	Stretch ResizeMode = iota
+	// Fit scales the source to fit inside the target size and pads the rest with the matte.
+	Fit
+	// Fill scales the source to cover the target size and crops the overflow.
+	Fill
+	// NoResize keeps the source pixels as they are and crops or pads with the matte.
+	NoResize
+)
+
+var resizeModeNames = []string{"stretch", "fit", "fill", "none"}
+
+func (m ResizeMode) String() string               { return enumString(resizeModeNames, int(m)) }
+func (m ResizeMode) MarshalText() ([]byte, error) { return []byte(m.String()), nil }
+func (m *ResizeMode) UnmarshalText(b []byte) error {
+	return unmarshalEnum("resize mode", resizeModeNames, b, (*int)(m))
+}
+
+// Scaler is the interpolation used when resizing.
+type Scaler int
+
+const (
+	// Bilinear is a fast approximation of bilinear interpolation.
+	Bilinear Scaler = iota
+	// NearestNeighbor keeps hard pixel edges, for icons and pixel art.
+	NearestNeighbor
+	// CatmullRom is slow but sharp, for photos.
+	CatmullRom
+)
+
+var scalerNames = []string{"bilinear", "nearest", "catmull-rom"}
+
+func (s Scaler) String() string               { return enumString(scalerNames, int(s)) }
+func (s Scaler) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+func (s *Scaler) UnmarshalText(b []byte) error {
+	return unmarshalEnum("scaler", scalerNames, b, (*int)(s))
+}
+
+// Dither selects how gray levels are approximated with fewer levels.
+type Dither int
+
+const (
+	// NoDither rounds every pixel to the nearest level, or applies Threshold in Mono1.
+	NoDither Dither = iota
+	// FloydSteinberg diffuses the whole quantization error to four neighbors.
+	FloydSteinberg
+	// Atkinson diffuses three quarters of the error to six neighbors, keeping
+	// more contrast in highlights and shadows.
+	Atkinson
+	// Ordered adds a 4x4 Bayer pattern, which compresses better than error diffusion.
+	Ordered
+)
+
+var ditherNames = []string{"none", "floyd-steinberg", "atkinson", "ordered"}
+
+func (d Dither) String() string               { return enumString(ditherNames, int(d)) }
+func (d Dither) MarshalText() ([]byte, error) { return []byte(d.String()), nil }
+func (d *Dither) UnmarshalText(b []byte) error {
+	return unmarshalEnum("dither", ditherNames, b, (*int)(d))
+}
+
+// PixelFormat is the layout of one pixel in the packed data.
+type PixelFormat int
+
+const (
+	// Mono1 stores 1 bit per pixel: 1 for black, 0 for white.
+	Mono1 PixelFormat = iota
+	// Gray2 stores 2 bits of brightness per pixel: 0 is black, 3 is white.
+	Gray2
+	// Gray4 stores 4 bits of brightness per pixel: 0 is black, 15 is white.
+	Gray4
+	// Gray8 stores 1 byte of brightness per pixel: 0 is black, 255 is white.
+	Gray8
+	// RGB565 stores 16-bit 5-6-5 colors, big-endian unless BitOrder is LSBFirst.
+	RGB565
+	// RGB888 stores 3 bytes per pixel in R, G, B order.
+	RGB888
+)
+
+var pixelFormatNames = []string{"mono", "gray2", "gray4", "gray8", "rgb565", "rgb888"}
+
+func (f PixelFormat) String() string               { return enumString(pixelFormatNames, int(f)) }
+func (f PixelFormat) MarshalText() ([]byte, error) { return []byte(f.String()), nil }
+func (f *PixelFormat) UnmarshalText(b []byte) error {
+	return unmarshalEnum("pixel format", pixelFormatNames, b, (*int)(f))
+}
+
+// BitsPerPixel returns the number of bits one pixel takes in the packed data.
+func (f PixelFormat) BitsPerPixel() int {
+	switch f {
+	case Gray2:
+		return 2
+	case Gray4:
+		return 4
+	case Gray8:
+		return 8
+	case RGB565:
+		return 16
+	case RGB888:
+		return 24
+	default:
+		return 1
+	}
+}
+
+// levels returns the number of gray levels of the gray formats, or 0 for colors.
+func (f PixelFormat) levels() int {
+	if f >= RGB565 {
+		return 0
+	}
+	return 1 << f.BitsPerPixel()
+}
+
+// Stride returns the number of bytes in one row of width pixels, including the
+// padding of a partial final byte.
+func (f PixelFormat) Stride(width int) int {
+	return (width*f.BitsPerPixel() + 7) / 8
+}
+
+// Polarity returns what the highest pixel value of f shows when AutoPolarity
+// is asked for: black ink in Mono1, white otherwise.
+func (f PixelFormat) Polarity() Polarity {
+	if f == Mono1 {
+		return HighBlack
+	}
+	return HighWhite
+}
+
+// BitOrder is the order of pixels within a byte.
+type BitOrder int
+
+const (
+	// MSBFirst puts the leftmost pixel in the most significant bits.
+	MSBFirst BitOrder = iota
+	// LSBFirst puts the leftmost pixel in the least significant bits. For RGB565
+	// it writes little-endian words.
+	LSBFirst
+)
+
+var bitOrderNames = []string{"msb", "lsb"}
+
+func (o BitOrder) String() string               { return enumString(bitOrderNames, int(o)) }
+func (o BitOrder) MarshalText() ([]byte, error) { return []byte(o.String()), nil }
+func (o *BitOrder) UnmarshalText(b []byte) error {
+	return unmarshalEnum("bit order", bitOrderNames, b, (*int)(o))
+}
+
+// Polarity is what the highest pixel value shows: 1 in Mono1, the brightest
+// level in the gray formats.
+type Polarity int
+
+const (
+	// AutoPolarity is the polarity of the pixel format, as PixelFormat.Polarity returns it.
+	AutoPolarity Polarity = iota
+	// HighBlack makes the highest value black, like ink on paper. It is not
+	// supported for the RGB formats.
+	HighBlack
+	// HighWhite makes the highest value white, like a lit OLED pixel or the
+	// white of many e-paper drivers.
+	HighWhite
+)
+
+var polarityNames = []string{"auto", "black", "white"}
+
+func (p Polarity) String() string               { return enumString(polarityNames, int(p)) }
+func (p Polarity) MarshalText() ([]byte, error) { return []byte(p.String()), nil }
+func (p *Polarity) UnmarshalText(b []byte) error {
+	return unmarshalEnum("polarity", polarityNames, b, (*int)(p))
+}
+
+// Rotation is a clockwise turn by a multiple of 90 degrees.
+type Rotation int
+
+const (
+	Rotate0 Rotation = iota
+	Rotate90
+	Rotate180
+	Rotate270
+)
+
+var rotationNames = []string{"0", "90", "180", "270"}
+
+func (r Rotation) String() string               { return enumString(rotationNames, int(r)) }
+func (r Rotation) MarshalText() ([]byte, error) { return []byte(r.String()), nil }
+func (r *Rotation) UnmarshalText(b []byte) error {
+	return unmarshalEnum("rotation", rotationNames, b, (*int)(r))
+}
+
+// Flip mirrors the image.
+type Flip int
+
+const (
+	// NoFlip leaves the image as it is.
+	NoFlip Flip = iota
+	// FlipHorizontal swaps left and right.
+	FlipHorizontal
+	// FlipVertical swaps top and bottom.
+	FlipVertical
+)
+
+var flipNames = []string{"none", "horizontal", "vertical"}
+
+func (f Flip) String() string               { return enumString(flipNames, int(f)) }
+func (f Flip) MarshalText() ([]byte, error) { return []byte(f.String()), nil }
+func (f *Flip) UnmarshalText(b []byte) error {
+	return unmarshalEnum("flip", flipNames, b, (*int)(f))
+}
+
+// TransformStage decides when Rotate and Flip apply.
+type TransformStage int
+
+const (
+	// TransformSource turns the source before it is resized, for art drawn in
+	// another orientation than the bitmap.
+	TransformSource TransformStage = iota
+	// TransformOutput turns the converted pixels, for panels mounted rotated
+	// or mirrored: the art is converted upright, with its dithering, and then
+	// laid out the way the panel scans it.
+	TransformOutput
+)
+
+var transformStageNames = []string{"source", "output"}
+
+func (s TransformStage) String() string               { return enumString(transformStageNames, int(s)) }
+func (s TransformStage) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+func (s *TransformStage) UnmarshalText(b []byte) error {
+	return unmarshalEnum("transform stage", transformStageNames, b, (*int)(s))
+}
+
+// Gravity is the side or corner an image is aligned to.
+type Gravity int
+
+const (
+	Center Gravity = iota
+	TopLeft
+	Top
+	TopRight
+	Left
+	Right
+	BottomLeft
+	Bottom
+	BottomRight
+)
+
+var gravityNames = []string{"center", "top-left", "top", "top-right", "left", "right", "bottom-left", "bottom", "bottom-right"}
+
+func (g Gravity) String() string               { return enumString(gravityNames, int(g)) }
+func (g Gravity) MarshalText() ([]byte, error) { return []byte(g.String()), nil }
+func (g *Gravity) UnmarshalText(b []byte) error {
+	return unmarshalEnum("gravity", gravityNames, b, (*int)(g))
+}
+
+// Equalize selects how tones are spread before quantizing.
+type Equalize int
+
+const (
+	// NoEqualize leaves the tones as they are.
+	NoEqualize Equalize = iota
+	// Histogram spreads the tones of the whole image evenly over the gray levels.
+	Histogram
+	// CLAHE equalizes regions of the image separately, with limited contrast,
+	// so detail shows in both shadows and highlights.
+	CLAHE
+)
+
+var equalizeNames = []string{"none", "histogram", "clahe"}
+
+func (e Equalize) String() string               { return enumString(equalizeNames, int(e)) }
+func (e Equalize) MarshalText() ([]byte, error) { return []byte(e.String()), nil }
+func (e *Equalize) UnmarshalText(b []byte) error {
+	return unmarshalEnum("equalization", equalizeNames, b, (*int)(e))
+}
+
+// Luma decides how a color becomes a luminance.
+type Luma int
+
+const (
+	// BT601 weighs the gamma-encoded channels as ITU-R BT.601 does, like
+	// analog television and most image tools.
+	BT601 Luma = iota
+	// BT709 weighs the gamma-encoded channels as ITU-R BT.709 does, counting
+	// green for more and red and blue for less.
+	BT709
+	// LinearLuma is the relative luminance of sRGB: the BT.709 weighting of the
+	// channels in linear light, encoded again.
+	LinearLuma
+	// Average takes the mean of the three channels.
+	Average
+	// Red, Green and Blue take a single channel, for art where one channel
+	// has the most contrast, like a red logo on white in the green channel.
+	Red
+	Green
+	Blue
+	// Max takes the brightest channel, turning every saturated color light.
+	Max
+	// Min takes the darkest channel, turning every saturated color dark.
+	Min
+)
+
+var lumaNames = []string{"bt601", "bt709", "linear", "average", "red", "green", "blue", "max", "min"}
+
+func (l Luma) String() string               { return enumString(lumaNames, int(l)) }
+func (l Luma) MarshalText() ([]byte, error) { return []byte(l.String()), nil }
+func (l *Luma) UnmarshalText(b []byte) error {
+	return unmarshalEnum("luma", lumaNames, b, (*int)(l))
+}
+
+// Edges selects an edge detection that turns the image into its outlines.
+type Edges int
+
+const (
+	// NoEdges keeps the image as it is.
+	NoEdges Edges = iota
+	// Sobel darkens every pixel by the strength of the gradient around it, so
+	// strong edges come out dark and soft ones gray, ready for thresholding.
+	Sobel
+	// Canny draws thin, connected outlines in black on white: it smooths the
+	// image, keeps the ridges of the gradient and follows weak edges only
+	// where they continue strong ones.
+	Canny
+)
+
+var edgesNames = []string{"none", "sobel", "canny"}
+
+func (e Edges) String() string               { return enumString(edgesNames, int(e)) }
+func (e Edges) MarshalText() ([]byte, error) { return []byte(e.String()), nil }
+func (e *Edges) UnmarshalText(b []byte) error {
+	return unmarshalEnum("edge detection", edgesNames, b, (*int)(e))
+}
+
+// Morphology thickens or thins dark strokes.
+type Morphology int
+
+const (
+	// NoMorphology keeps strokes as they are.
+	NoMorphology Morphology = iota
+	// Dilate grows dark areas, thickening strokes and closing small gaps.
+	Dilate
+	// Erode shrinks dark areas, thinning strokes and removing specks.
+	Erode
+)
+
+var morphologyNames = []string{"none", "dilate", "erode"}
+
+func (m Morphology) String() string               { return enumString(morphologyNames, int(m)) }
+func (m Morphology) MarshalText() ([]byte, error) { return []byte(m.String()), nil }
+func (m *Morphology) UnmarshalText(b []byte) error {
+	return unmarshalEnum("morphology", morphologyNames, b, (*int)(m))
+}
+
+// AlphaMode decides what the transparency of the source means.
+type AlphaMode int
+
+const (
+	// AlphaMatte composites the source onto the matte color, so transparent
+	// pixels take the matte.
+	AlphaMatte AlphaMode = iota
+	// AlphaThreshold ignores the colors and takes opacity as ink: opaque pixels
+	// are black and transparent ones white. In Mono1 the threshold applies to
+	// opacity, so with the default pixels at least half opaque are black. It
+	// is not supported for the RGB formats.
+	AlphaThreshold
+)
+
+var alphaModeNames = []string{"matte", "threshold"}
+
+func (m AlphaMode) String() string               { return enumString(alphaModeNames, int(m)) }
+func (m AlphaMode) MarshalText() ([]byte, error) { return []byte(m.String()), nil }
+func (m *AlphaMode) UnmarshalText(b []byte) error {
+	return unmarshalEnum("alpha mode", alphaModeNames, b, (*int)(m))
+}
+
+// Color is an opaque color, written as #RRGGBB, #RGB, white or black. A color
+// without alpha, like the zero value, is white.
+type Color color.RGBA
+
+// White and Black are the colors the names white and black stand for.
+var (
+	White = Color{0xFF, 0xFF, 0xFF, 0xFF}
+	Black = Color{0x00, 0x00, 0x00, 0xFF}
+)
+
+// RGBA returns c as an opaque color.RGBA.
+func (c Color) RGBA() color.RGBA {
+	if c.A == 0 {
+		return color.RGBA(White)
+	}
+	return color.RGBA{c.R, c.G, c.B, 0xFF}
+}
+
+func (c Color) String() string {
+	rgba := c.RGBA()
+	return fmt.Sprintf("#%02X%02X%02X", rgba.R, rgba.G, rgba.B)
+}
+
+func (c Color) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *Color) UnmarshalText(b []byte) error {
+	s := strings.ToLower(strings.TrimSpace(string(b)))
+	switch s {
+	case "white":
+		*c = White
+		return nil
+	case "black":
+		*c = Black
+		return nil
+	}
+	digits := strings.TrimPrefix(s, "#")
+	if len(digits) == 3 {
+		digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+	}
+	rgb, err := hex.DecodeString(digits)
+	if err != nil || len(rgb) != 3 {
+		return fmt.Errorf("invalid color %q (want #RRGGBB, #RGB, white or black)", string(b))
+	}
+	*c = Color{rgb[0], rgb[1], rgb[2], 0xFF}
+	return nil
+}
+
+// Rect is a rectangle of pixels, written as WxH+X+Y like an ImageMagick
+// geometry, or WxH at the origin. The zero value is written as an empty string.
+type Rect image.Rectangle
+
+func (r Rect) String() string {
+	if image.Rectangle(r).Empty() {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d+%d+%d", r.Max.X-r.Min.X, r.Max.Y-r.Min.Y, r.Min.X, r.Min.Y)
+}
+
+func (r Rect) MarshalText() ([]byte, error) { return []byte(r.String()), nil }
+
+func (r *Rect) UnmarshalText(b []byte) error {
+	s := strings.ToLower(strings.TrimSpace(string(b)))
+	if s == "" {
+		*r = Rect{}
+		return nil
+	}
+	invalid := fmt.Errorf("invalid rectangle %q (want WxH+X+Y or WxH)", string(b))
+	size, offset, hasOffset := strings.Cut(s, "+")
+	ws, hs, ok := strings.Cut(size, "x")
+	if !ok {
+		return invalid
+	}
+	xs, ys := "0", "0"
+	if hasOffset {
+		if xs, ys, ok = strings.Cut(offset, "+"); !ok {
+			return invalid
+		}
+	}
+	var v [4]int
+	for i, digits := range []string{ws, hs, xs, ys} {
+		n, err := strconv.Atoi(digits)
+		if err != nil || n < 0 || (i < 2 && n == 0) {
+			return invalid
+		}
+		v[i] = n
+	}
+	w, h, x, y := v[0], v[1], v[2], v[3]
+	*r = Rect(image.Rect(x, y, x+w, y+h))
+	return nil
+}
+
+// Margins are the widths of the borders of a rectangle, written like CSS
+// margins: N for every side, V,H for top and bottom and for left and right, or
+// T,R,B,L.
+type Margins struct {
+	Top, Right, Bottom, Left int
+}
+
+func (m Margins) String() string {
+	switch {
+	case m.Top == m.Bottom && m.Left == m.Right && m.Top == m.Left:
+		return strconv.Itoa(m.Top)
+	case m.Top == m.Bottom && m.Left == m.Right:
+		return fmt.Sprintf("%d,%d", m.Top, m.Left)
+	}
+	return fmt.Sprintf("%d,%d,%d,%d", m.Top, m.Right, m.Bottom, m.Left)
+}
+
+func (m Margins) MarshalText() ([]byte, error) { return []byte(m.String()), nil }
+
+func (m *Margins) UnmarshalText(b []byte) error {
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		*m = Margins{}
+		return nil
+	}
+	fields := strings.Split(s, ",")
+	v := make([]int, len(fields))
+	for i, field := range fields {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid margins %q (want N, V,H or T,R,B,L)", string(b))
+		}
+		v[i] = n
+	}
+	switch len(v) {
+	case 1:
+		*m = Margins{v[0], v[0], v[0], v[0]}
+	case 2:
+		*m = Margins{v[0], v[1], v[0], v[1]}
+	case 4:
+		*m = Margins{v[0], v[1], v[2], v[3]}
+	default:
+		return fmt.Errorf("invalid margins %q (want N, V,H or T,R,B,L)", string(b))
+	}
+	return nil
+}
+
+// Point is a position or a move in pixels, written as X,Y.
+type Point image.Point
+
+func (p Point) String() string { return fmt.Sprintf("%d,%d", p.X, p.Y) }
+
+func (p Point) MarshalText() ([]byte, error) { return []byte(p.String()), nil }
+
+func (p *Point) UnmarshalText(b []byte) error {
+	xs, ys, ok := strings.Cut(strings.TrimSpace(string(b)), ",")
+	x, errX := strconv.Atoi(strings.TrimSpace(xs))
+	y, errY := strconv.Atoi(strings.TrimSpace(ys))
+	if !ok || errX != nil || errY != nil {
+		return fmt.Errorf("invalid point %q (want X,Y)", string(b))
+	}
+	*p = Point{x, y}
+	return nil
+}
+
+// enumString returns the name of value i, or its number if it has none.
+func enumString(names []string, i int) string {
+	if i < 0 || i >= len(names) {
+		return fmt.Sprintf("%d", i)
+	}
+	return names[i]
+}
+
+// unmarshalEnum stores the index of the name b in v.
+func unmarshalEnum(kind string, names []string, b []byte, v *int) error {
+	s := strings.ToLower(strings.TrimSpace(string(b)))
+	for i, name := range names {
+		if s == name {
+			*v = i
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown %s %q (want %s)", kind, string(b), strings.Join(names, ", "))
+}
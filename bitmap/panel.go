@@ -0,0 +1,80 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Panel holds the colors of a display: Paper where a pixel is off and Ink
+// where it is on.
+type Panel struct {
+	Paper color.RGBA
+	Ink   color.RGBA
+	// Polarity is what a pixel that is on shows: HighBlack for e-paper and
+	// LCDs, which darken their ink, and HighWhite for OLEDs, which light it.
+	// AutoPolarity means HighBlack.
+	Polarity Polarity
+}
+
+// polarity returns p.Polarity, resolving AutoPolarity.
+func (p Panel) polarity() Polarity {
+	if p.Polarity == AutoPolarity {
+		return HighBlack
+	}
+	return p.Polarity
+}
+
+// Panels approximate how common displays show white and black pixels.
+var Panels = map[string]Panel{
+	"eink":         {Paper: color.RGBA{0xE8, 0xE6, 0xDC, 0xFF}, Ink: color.RGBA{0x2F, 0x2F, 0x2F, 0xFF}},
+	"tricolor-red": {Paper: color.RGBA{0xE8, 0xE6, 0xDC, 0xFF}, Ink: color.RGBA{0xC4, 0x1E, 0x1E, 0xFF}},
+	"oled":         {Paper: color.RGBA{0x05, 0x05, 0x08, 0xFF}, Ink: color.RGBA{0xE6, 0xF4, 0xFF, 0xFF}, Polarity: HighWhite},
+	"lcd":          {Paper: color.RGBA{0xB8, 0xC4, 0xA0, 0xFF}, Ink: color.RGBA{0x26, 0x30, 0x26, 0xFF}},
+	"mono":         {Paper: color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}, Ink: color.RGBA{0x00, 0x00, 0x00, 0xFF}},
+}
+
+// PanelImage draws b the way the panel shows it: every pixel becomes a
+// scale x scale square between the paper and ink colors, optionally outlined by
+// faint grid lines a quarter of the way from paper to ink. The stored value
+// decides how much ink a pixel gets, counted from the end of its range that
+// shows what the ink of the panel shows. The RGB formats keep their own colors.
+func PanelImage(b *Bitmap, p Panel, scale int, grid bool) (*image.RGBA, error) {
+	if scale < 1 {
+		return nil, fmt.Errorf("preview scale must be at least 1, got %d", scale)
+	}
+
+	line := mixColors(p.Paper, p.Ink, 64)
+	img := image.NewRGBA(image.Rect(0, 0, b.Width*scale, b.Height*scale))
+	for y := 0; y < b.Height; y++ {
+		for x := 0; x < b.Width; x++ {
+			c := b.RGBAt(x, y)
+			if levels := b.Format.levels(); levels > 0 {
+				ink := b.Value(x, y) * 255 / uint32(levels-1)
+				if b.polarity() != p.polarity() {
+					ink = 255 - ink
+				}
+				c = mixColors(p.Paper, p.Ink, uint8(ink))
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					// Grid lines need a few pixels per cell to stay readable
+					if grid && scale >= 3 && (dx == 0 || dy == 0) {
+						img.SetRGBA(x*scale+dx, y*scale+dy, line)
+					} else {
+						img.SetRGBA(x*scale+dx, y*scale+dy, c)
+					}
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+// mixColors returns the color t/255 of the way from a to b.
+func mixColors(a, b color.RGBA, t uint8) color.RGBA {
+	mix := func(x, y uint8) uint8 {
+		return uint8((int(x)*(255-int(t)) + int(y)*int(t)) / 255)
+	}
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: 0xFF}
+}
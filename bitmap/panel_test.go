@@ -0,0 +1,114 @@
+package bitmap
+
+import (
+	"testing"
+)
+
+func TestPanelImage(t *testing.T) {
+	// 2x1: black, white
+	bm, err := New(2, 1, Mono1, MSBFirst, []byte{0x80})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	panel := Panels["eink"]
+
+	img, err := PanelImage(bm, panel, 3, false)
+	if err != nil {
+		t.Fatalf("PanelImage failed: %v", err)
+	}
+	if img.Bounds().Dx() != 6 || img.Bounds().Dy() != 3 {
+		t.Fatalf("Expected a 6x3 image, got %v", img.Bounds())
+	}
+	if img.RGBAAt(2, 2) != panel.Ink || img.RGBAAt(3, 0) != panel.Paper {
+		t.Errorf("Expected ink then paper, got %v and %v", img.RGBAAt(2, 2), img.RGBAAt(3, 0))
+	}
+
+	img, err = PanelImage(bm, panel, 3, true)
+	if err != nil {
+		t.Fatalf("PanelImage failed: %v", err)
+	}
+	if grid := mixColors(panel.Paper, panel.Ink, 64); img.RGBAAt(3, 1) != grid || img.RGBAAt(4, 1) != panel.Paper {
+		t.Errorf("Expected a grid line on the cell edge only, got %v and %v", img.RGBAAt(3, 1), img.RGBAAt(4, 1))
+	}
+
+	if _, err := PanelImage(bm, panel, 0, false); err == nil {
+		t.Errorf("Expected an error for a zero scale, got nil")
+	}
+}
+
+func TestPanelImageColor(t *testing.T) {
+	// RGB formats ignore the panel colors
+	bm, err := New(1, 1, RGB888, MSBFirst, []byte{0x12, 0x34, 0x56})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	img, err := PanelImage(bm, Panels["eink"], 1, false)
+	if err != nil {
+		t.Fatalf("PanelImage failed: %v", err)
+	}
+	if c := img.RGBAAt(0, 0); c.R != 0x12 || c.G != 0x34 || c.B != 0x56 {
+		t.Errorf("Expected the pixel color to be kept, got %v", c)
+	}
+}
+
+func TestPanelImagePolarity(t *testing.T) {
+	// A white image with a black square, as each display stores and shows it
+	tests := []struct {
+		profile string
+		// lit is whether a set bit shows the ink of the panel
+		lit bool
+	}{
+		{profile: "ssd1306", lit: true},
+		{profile: "badger2040", lit: true},
+		{profile: "waveshare-2in13", lit: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			profile := Profiles[tt.profile]
+			opts := Options{}
+			profile.Apply(&opts)
+			opts.Width, opts.Height = 24, 24
+			bm, err := Convert(square(24, 12), opts)
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			panel := Panels[profile.Panel]
+			img, err := PanelImage(bm, panel, 1, false)
+			if err != nil {
+				t.Fatalf("PanelImage failed: %v", err)
+			}
+			for y := 0; y < bm.Height; y++ {
+				for x := 0; x < bm.Width; x++ {
+					set := bm.Data[y*bm.Stride+x/8]&(0x80>>(x%8)) != 0
+					expected := panel.Paper
+					if set == tt.lit {
+						expected = panel.Ink
+					}
+					if got := img.RGBAAt(x, y); got != expected {
+						t.Fatalf("Pixel (%d,%d) with bit %t: expected %v, got %v", x, y, set, expected, got)
+					}
+				}
+			}
+		})
+	}
+
+	// On an OLED the white background is lit and the black square is dark
+	profile := Profiles["ssd1306"]
+	opts := Options{}
+	profile.Apply(&opts)
+	opts.Width, opts.Height = 24, 24
+	bm, err := Convert(square(24, 12), opts)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if bm.Data[0] != 0xFF {
+		t.Errorf("Expected the white background to be stored as 0xFF, got %#02x", bm.Data[0])
+	}
+	img, err := PanelImage(bm, Panels["oled"], 1, false)
+	if err != nil {
+		t.Fatalf("PanelImage failed: %v", err)
+	}
+	if img.RGBAAt(0, 0) != Panels["oled"].Ink || img.RGBAAt(12, 12) != Panels["oled"].Paper {
+		t.Errorf("Expected a lit background and a dark square, got %v and %v", img.RGBAAt(0, 0), img.RGBAAt(12, 12))
+	}
+}
@@ -0,0 +1,162 @@
+package bitmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"io"
+)
+
+// PreviewModes lists the terminal renderers accepted by WritePreview.
+var PreviewModes = []string{"halfblock", "braille", "sixel", "kitty"}
+
+// WritePreview draws b in the terminal. It reads the packed data directly, so
+// the preview shows exactly what the firmware gets, including any bit-order
+// mistakes. The text modes draw dark pixels as ink.
+func WritePreview(w io.Writer, b *Bitmap, mode string) error {
+	// Writes to bw are not checked one by one: a failure sticks and is reported by Flush
+	bw := bufio.NewWriter(w)
+	var err error
+	switch mode {
+	case "halfblock":
+		writeHalfBlocks(bw, b)
+	case "braille":
+		writeBraille(bw, b)
+	case "sixel":
+		writeSixel(bw, b)
+	case "kitty":
+		err = writeKitty(bw, b)
+	default:
+		return fmt.Errorf("unknown preview mode %q", mode)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// inkAt reports whether the pixel at (x, y) is dark, treating pixels outside
+// the bitmap as paper.
+func (b *Bitmap) inkAt(x, y int) bool {
+	if x >= b.Width || y >= b.Height {
+		return false
+	}
+	return b.Gray(x, y) < 0x80
+}
+
+// writeHalfBlocks draws two pixel rows per line.
+func writeHalfBlocks(w *bufio.Writer, b *Bitmap) {
+	blocks := [4]rune{' ', '▀', '▄', '█'}
+	for y := 0; y < b.Height; y += 2 {
+		for x := 0; x < b.Width; x++ {
+			i := 0
+			if b.inkAt(x, y) {
+				i |= 1
+			}
+			if b.inkAt(x, y+1) {
+				i |= 2
+			}
+			w.WriteRune(blocks[i])
+		}
+		w.WriteByte('\n')
+	}
+}
+
+// brailleDots maps a pixel in a 2x4 cell to its braille dot, indexed [y][x].
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// writeBraille draws 2x4 pixels per character, with dark pixels as raised dots.
+func writeBraille(w *bufio.Writer, b *Bitmap) {
+	for y := 0; y < b.Height; y += 4 {
+		for x := 0; x < b.Width; x += 2 {
+			r := rune(0x2800)
+			for dy := 0; dy < 4; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					if b.inkAt(x+dx, y+dy) {
+						r |= brailleDots[dy][dx]
+					}
+				}
+			}
+			w.WriteRune(r)
+		}
+		w.WriteByte('\n')
+	}
+}
+
+// writeSixel draws the image with the DEC sixel protocol, white paper and
+// black ink, six pixel rows per band.
+func writeSixel(w *bufio.Writer, b *Bitmap) {
+	fmt.Fprintf(w, "\x1bPq\"1;1;%d;%d#0;2;100;100;100#1;2;0;0;0", b.Width, b.Height)
+	for y := 0; y < b.Height; y += 6 {
+		for color := 0; color < 2; color++ {
+			fmt.Fprintf(w, "#%d", color)
+			var run byte
+			count := 0
+			for x := 0; x < b.Width; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && y+dy < b.Height; dy++ {
+					if b.inkAt(x, y+dy) == (color == 1) {
+						bits |= 1 << dy
+					}
+				}
+				c := '?' + bits
+				if count > 0 && c != run {
+					writeSixelRun(w, run, count)
+					count = 0
+				}
+				run = c
+				count++
+			}
+			writeSixelRun(w, run, count)
+			// Return to the start of the band for the next color
+			w.WriteByte('$')
+		}
+		w.WriteByte('-')
+	}
+	w.WriteString("\x1b\\\n")
+}
+
+// writeSixelRun writes count copies of a sixel character, run-length encoded
+// once that is shorter.
+func writeSixelRun(w *bufio.Writer, c byte, count int) {
+	if count > 3 {
+		fmt.Fprintf(w, "!%d%c", count, c)
+		return
+	}
+	for ; count > 0; count-- {
+		w.WriteByte(c)
+	}
+}
+
+// writeKitty sends the image as a PNG with the kitty graphics protocol, in
+// chunks of at most 4096 base64 bytes.
+func writeKitty(w *bufio.Writer, b *Bitmap) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, b.Image()); err != nil {
+		return err
+	}
+
+	const chunkSize = 4096
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+	for i := 0; i == 0 || i < len(payload); i += chunkSize {
+		chunk := payload[i:min(i+chunkSize, len(payload))]
+		more := 0
+		if i+chunkSize < len(payload) {
+			more = 1
+		}
+		if i == 0 {
+			fmt.Fprintf(w, "\x1b_Ga=T,f=100,m=%d;%s\x1b\\", more, chunk)
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	w.WriteByte('\n')
+	return nil
+}
@@ -0,0 +1,81 @@
+package bitmap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWritePreviewText(t *testing.T) {
+	// 3x3: a diagonal, MSB-first with padded rows
+	bm, err := New(3, 3, Mono1, MSBFirst, []byte{0x80, 0x40, 0x20})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	tests := []struct {
+		mode     string
+		expected string
+	}{
+		{mode: "halfblock", expected: "▀▄ \n  ▀\n"},
+		{mode: "braille", expected: "⠑⠄\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WritePreview(&buf, bm, tt.mode); err != nil {
+				t.Fatalf("WritePreview failed: %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestWritePreviewGraphics(t *testing.T) {
+	bm, err := New(8, 2, Mono1, MSBFirst, []byte{0xF0, 0x0F})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePreview(&buf, bm, "sixel"); err != nil {
+		t.Fatalf("WritePreview failed: %v", err)
+	}
+	// Each color pass covers one pixel row of each half: @ is the top row, A the bottom
+	if !strings.HasPrefix(buf.String(), "\x1bPq\"1;1;8;2") || !strings.Contains(buf.String(), "#0!4A!4@$#1!4@!4A$-") {
+		t.Errorf("Unexpected sixel output %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := WritePreview(&buf, bm, "kitty"); err != nil {
+		t.Fatalf("WritePreview failed: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "\x1b_Ga=T,f=100,m=0;") {
+		t.Errorf("Unexpected kitty output %q", buf.String())
+	}
+}
+
+func TestWritePreviewGray(t *testing.T) {
+	// Dark gray levels are drawn as ink, light ones as paper
+	bm, err := New(4, 1, Gray4, MSBFirst, []byte{0x3C, 0x7F})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WritePreview(&buf, bm, "halfblock"); err != nil {
+		t.Fatalf("WritePreview failed: %v", err)
+	}
+	if buf.String() != "▀ ▀ \n" {
+		t.Errorf("Unexpected preview %q", buf.String())
+	}
+}
+
+func TestWritePreviewUnknownMode(t *testing.T) {
+	bm, _ := New(1, 1, Mono1, MSBFirst, []byte{0})
+	if err := WritePreview(&bytes.Buffer{}, bm, "ascii"); err == nil {
+		t.Errorf("Expected an error for an unknown mode, got nil")
+	}
+}
@@ -0,0 +1,27 @@
+package bitmap
+
+// Profile is the layout a display driver expects, which a conversion can
+// start from.
+type Profile struct {
+	Width, Height int
+	Format        PixelFormat
+	BitOrder      BitOrder
+	Polarity      Polarity
+	// Panel is the key of the entry of Panels that looks like the display.
+	Panel string
+}
+
+// Apply sets the layout of opts to that of p, leaving the other options alone.
+func (p Profile) Apply(opts *Options) {
+	opts.Width, opts.Height = p.Width, p.Height
+	opts.Format, opts.BitOrder, opts.Polarity = p.Format, p.BitOrder, p.Polarity
+}
+
+// Profiles are the layouts of common displays, in landscape orientation.
+var Profiles = map[string]Profile{
+	"badger2040":      {Width: 296, Height: 128, Format: Mono1, Polarity: HighBlack, Panel: "eink"},
+	"ssd1306":         {Width: 128, Height: 64, Format: Mono1, Polarity: HighWhite, Panel: "oled"},
+	"sh1106":          {Width: 128, Height: 64, Format: Mono1, Polarity: HighWhite, Panel: "oled"},
+	"waveshare-2in13": {Width: 250, Height: 122, Format: Mono1, Polarity: HighWhite, Panel: "eink"},
+	"waveshare-2in9":  {Width: 296, Height: 128, Format: Mono1, Polarity: HighWhite, Panel: "eink"},
+}
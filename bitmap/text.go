@@ -0,0 +1,185 @@
+package bitmap
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// defaultTextSize is the size of text in pixels when TextOptions.Size is zero.
+const defaultTextSize = 16
+
+// TextOptions control how NewFace and RenderText draw text.
+type TextOptions struct {
+	// Size is the height of the font in pixels, 16 when zero. The built-in
+	// font has a single size.
+	Size float64
+	// Hinting snaps the outlines of a font to the pixel grid, which keeps
+	// small text sharp.
+	Hinting Hinting
+	// Align decides where lines narrower than Width sit.
+	Align Align
+	// Width is the width lines wrap at and are aligned in. When it is zero
+	// lines only break at newlines, and the text is as wide as its longest line.
+	Width int
+	// NoAntialias draws every pixel as ink or paper, so text stays crisp when
+	// it is thresholded to 1bpp.
+	NoAntialias bool
+}
+
+// validate rejects text options that RenderText cannot honor.
+func (o TextOptions) validate() error {
+	switch {
+	case !(o.Size >= 0 && o.Size <= 1000):
+		return fmt.Errorf("text size %g is not between 0 and 1000", o.Size)
+	case o.Hinting < NoHinting || o.Hinting > FullHinting:
+		return fmt.Errorf("unknown hinting %s", o.Hinting)
+	case o.Align < AlignLeft || o.Align > AlignRight:
+		return fmt.Errorf("unknown alignment %s", o.Align)
+	case o.Width < 0:
+		return fmt.Errorf("invalid text width %d", o.Width)
+	}
+	return nil
+}
+
+// Hinting selects how much a font is snapped to the pixel grid.
+type Hinting int
+
+const (
+	NoHinting Hinting = iota
+	// VerticalHinting snaps heights only, keeping the spacing of letters.
+	VerticalHinting
+	FullHinting
+)
+
+var hintingNames = []string{"none", "vertical", "full"}
+
+func (h Hinting) String() string               { return enumString(hintingNames, int(h)) }
+func (h Hinting) MarshalText() ([]byte, error) { return []byte(h.String()), nil }
+func (h *Hinting) UnmarshalText(b []byte) error {
+	return unmarshalEnum("hinting", hintingNames, b, (*int)(h))
+}
+
+// Align is the side lines of text are aligned to.
+type Align int
+
+const (
+	AlignLeft Align = iota
+	AlignCenter
+	AlignRight
+)
+
+var alignNames = []string{"left", "center", "right"}
+
+func (a Align) String() string               { return enumString(alignNames, int(a)) }
+func (a Align) MarshalText() ([]byte, error) { return []byte(a.String()), nil }
+func (a *Align) UnmarshalText(b []byte) error {
+	return unmarshalEnum("alignment", alignNames, b, (*int)(a))
+}
+
+// NewFace returns a face of the TrueType or OpenType font in data, at the size
+// and with the hinting of opts. Without data it returns the built-in 7x13 pixel
+// font, which is crisp at 1bpp.
+func NewFace(data []byte, opts TextOptions) (font.Face, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return basicfont.Face7x13, nil
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	hinting := [...]font.Hinting{font.HintingNone, font.HintingVertical, font.HintingFull}[opts.Hinting]
+	// At 72 DPI a point is a pixel
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: cmp.Or(opts.Size, defaultTextSize), DPI: 72, Hinting: hinting})
+}
+
+// RenderText draws text with face into an alpha mask, opaque where the glyphs
+// are. Lines break at newlines and wrap at opts.Width, and every line is as
+// tall as the face asks. Callers draw the mask in the color they want.
+func RenderText(text string, face font.Face, opts TextOptions) (*image.Alpha, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	lines := wrapText(text, face, opts.Width)
+	m := face.Metrics()
+	lineHeight := m.Height.Ceil()
+	width := opts.Width
+	if width == 0 {
+		for _, line := range lines {
+			width = max(width, font.MeasureString(face, line).Ceil())
+		}
+	}
+
+	dst := image.NewAlpha(image.Rect(0, 0, width, len(lines)*lineHeight))
+	d := font.Drawer{Dst: dst, Src: image.Opaque, Face: face}
+	for i, line := range lines {
+		x := 0
+		switch room := width - font.MeasureString(face, line).Ceil(); opts.Align {
+		case AlignCenter:
+			x = room / 2
+		case AlignRight:
+			x = room
+		}
+		d.Dot = fixed.Point26_6{X: fixed.I(x), Y: m.Ascent + fixed.I(i*lineHeight)}
+		d.DrawString(line)
+	}
+	if opts.NoAntialias {
+		for i, a := range dst.Pix {
+			dst.Pix[i] = 0
+			if a >= 0x80 {
+				dst.Pix[i] = 0xFF
+			}
+		}
+	}
+	return dst, nil
+}
+
+// wrapText splits text into lines at newlines and, when width is not zero,
+// between words so that no line is wider than width. A word wider than width
+// on its own is broken between characters.
+func wrapText(text string, face font.Face, width int) []string {
+	fits := func(s string) bool { return font.MeasureString(face, s).Ceil() <= width }
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		if width == 0 {
+			lines = append(lines, paragraph)
+			continue
+		}
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			if line != "" && fits(line+" "+word) {
+				line += " " + word
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			for utf8.RuneCountInString(word) > 1 && !fits(word) {
+				// Keep at least one character, so every line makes progress
+				end := 0
+				for i, r := range word {
+					next := i + utf8.RuneLen(r)
+					if end > 0 && !fits(word[:next]) {
+						break
+					}
+					end = next
+				}
+				lines = append(lines, word[:end])
+				word = word[end:]
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,134 @@
+package bitmap
+
+import (
+	"image"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// inkColumns returns the first and last columns of row y of mask with any ink
+func inkColumns(mask *image.Alpha, y int) (first, last int) {
+	first, last = -1, -1
+	for x := 0; x < mask.Bounds().Dx(); x++ {
+		if mask.AlphaAt(x, y).A > 0 {
+			if first < 0 {
+				first = x
+			}
+			last = x
+		}
+	}
+	return first, last
+}
+
+func TestWrapText(t *testing.T) {
+	// Every character of the pixel font is 7 pixels wide
+	face := basicfont.Face7x13
+	tests := []struct {
+		text     string
+		width    int
+		expected []string
+	}{
+		{text: "one two three", width: 0, expected: []string{"one two three"}},
+		{text: "one two three", width: 7 * 7, expected: []string{"one two", "three"}},
+		{text: "one\ntwo three", width: 0, expected: []string{"one", "two three"}},
+		{text: "one  two", width: 7 * 3, expected: []string{"one", "two"}},
+		{text: "abcdefgh", width: 7 * 3, expected: []string{"abc", "def", "gh"}},
+		{text: "a\n\nb", width: 7 * 3, expected: []string{"a", "", "b"}},
+		// A line keeps one character even when it is wider than the width
+		{text: "ab", width: 3, expected: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		if got := wrapText(tt.text, face, tt.width); !slices.Equal(got, tt.expected) {
+			t.Errorf("wrapText(%q, %d): expected %q, got %q", tt.text, tt.width, tt.expected, got)
+		}
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	face := basicfont.Face7x13
+	mask, err := RenderText("ab\ncd", face, TextOptions{})
+	if err != nil {
+		t.Fatalf("RenderText failed: %v", err)
+	}
+	if b := mask.Bounds(); b.Dx() != 14 || b.Dy() != 26 {
+		t.Errorf("Expected two lines of two characters to be 14x26, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	// Lines narrower than the width sit on the side they are aligned to
+	for _, tt := range []struct {
+		align       Align
+		first, last int
+	}{
+		{align: AlignLeft, first: 0, last: 13},
+		{align: AlignCenter, first: 8, last: 21},
+		{align: AlignRight, first: 16, last: 29},
+	} {
+		mask, err := RenderText("MM", face, TextOptions{Align: tt.align, Width: 30})
+		if err != nil {
+			t.Fatalf("RenderText failed: %v", err)
+		}
+		lo, hi := 30, -1
+		for y := 0; y < mask.Bounds().Dy(); y++ {
+			if first, last := inkColumns(mask, y); first >= 0 {
+				lo, hi = min(lo, first), max(hi, last)
+			}
+		}
+		if lo < tt.first || hi > tt.last || hi < 0 {
+			t.Errorf("%s: expected ink within columns %d..%d, got %d..%d", tt.align, tt.first, tt.last, lo, hi)
+		}
+	}
+}
+
+func TestRenderTextFont(t *testing.T) {
+	face, err := NewFace(goregular.TTF, TextOptions{Size: 24, Hinting: FullHinting})
+	if err != nil {
+		t.Fatalf("NewFace failed: %v", err)
+	}
+	soft, err := RenderText("Go", face, TextOptions{})
+	if err != nil {
+		t.Fatalf("RenderText failed: %v", err)
+	}
+	if h := soft.Bounds().Dy(); h < 24 || h > 32 {
+		t.Errorf("Expected a line about 24 pixels tall, got %d", h)
+	}
+	if !slices.ContainsFunc(soft.Pix, func(a uint8) bool { return a > 0 && a < 0xFF }) {
+		t.Errorf("Expected antialiased edges")
+	}
+
+	// Without antialiasing every pixel is ink or paper
+	crisp, err := RenderText("Go", face, TextOptions{NoAntialias: true})
+	if err != nil {
+		t.Fatalf("RenderText failed: %v", err)
+	}
+	if slices.ContainsFunc(crisp.Pix, func(a uint8) bool { return a != 0 && a != 0xFF }) {
+		t.Errorf("Expected only opaque and transparent pixels")
+	}
+	if !slices.Contains(crisp.Pix, 0xFF) {
+		t.Errorf("Expected the text to be drawn")
+	}
+}
+
+func TestRenderTextInvalid(t *testing.T) {
+	if _, err := NewFace([]byte("not a font"), TextOptions{}); err == nil {
+		t.Errorf("Expected an error for an invalid font, got nil")
+	}
+	for _, opts := range []TextOptions{
+		{Size: -1},
+		{Size: 2000},
+		{Hinting: Hinting(5)},
+		{Align: Align(5)},
+		{Width: -1},
+	} {
+		if _, err := RenderText("a", basicfont.Face7x13, opts); err == nil {
+			t.Errorf("Expected an error for %+v, got nil", opts)
+		}
+	}
+	var align Align
+	if err := align.UnmarshalText([]byte("justify")); err == nil || !strings.Contains(err.Error(), "alignment") {
+		t.Errorf("Expected an unknown alignment error, got %v", err)
+	}
+}
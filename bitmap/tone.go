@@ -0,0 +1,247 @@
+package bitmap
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// middleGray is the linear luminance of middle gray, which Contrast keeps in place.
+const middleGray = 0.18
+
+// Tiles and contrast limit of CLAHE: the image is equalized in up to
+// claheTiles x claheTiles regions, and no gray level of a region's histogram
+// may hold more than claheClipLimit times its share of the pixels.
+const (
+	claheTiles     = 8
+	claheClipLimit = 2.0
+)
+
+// hasTone reports whether any tone adjustment is set.
+func (o Options) hasTone() bool {
+	return o.AutoLevels != 0 || o.Equalize != NoEqualize || (o.Gamma != 0 && o.Gamma != 1) || o.Brightness != 0 || o.Contrast != 0
+}
+
+// validateTone rejects tone adjustments that Convert cannot honor.
+func (o Options) validateTone() error {
+	switch {
+	case o.Gamma < 0 || math.IsNaN(o.Gamma) || math.IsInf(o.Gamma, 0):
+		return fmt.Errorf("gamma %g is not a finite positive number (zero means 1)", o.Gamma)
+	case !(o.Brightness >= -1 && o.Brightness <= 1):
+		return fmt.Errorf("brightness %g is not between -1 and 1", o.Brightness)
+	case !(o.Contrast >= -1 && o.Contrast <= 1):
+		return fmt.Errorf("contrast %g is not between -1 and 1", o.Contrast)
+	case !(o.AutoLevels >= 0 && o.AutoLevels < 50):
+		return fmt.Errorf("auto levels %g%% is not between 0 and 50", o.AutoLevels)
+	case o.Equalize < NoEqualize || o.Equalize > CLAHE:
+		return fmt.Errorf("unknown equalization %s", o.Equalize)
+	case o.hasTone() && o.Format.levels() == 0:
+		return fmt.Errorf("tone adjustments are not supported for %s", o.Format)
+	}
+	return nil
+}
+
+// decodeSRGB converts an sRGB-encoded value in 0..1 to linear light.
+func decodeSRGB(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// encodeSRGB converts linear light in 0..1 to an sRGB-encoded value.
+func encodeSRGB(l float64) float64 {
+	if l <= 0.0031308 {
+		return l * 12.92
+	}
+	return 1.055*math.Pow(l, 1/2.4) - 0.055
+}
+
+// linearTable maps every 16-bit encoded luminance to linear light.
+var linearTable = sync.OnceValue(func() []float32 {
+	table := make([]float32, 0x10000)
+	for i := range table {
+		table[i] = float32(decodeSRGB(float64(i) / 0xFFFF))
+	}
+	return table
+})
+
+// toneBins is the number of gray levels the equalizers count pixels in.
+const toneBins = 256
+
+// binEdges holds the linear luminance at which each equalizer bin but the
+// first starts. Bins are evenly spaced in encoded values, as the eye sees them.
+var binEdges = sync.OnceValue(func() []float32 {
+	edges := make([]float32, toneBins-1)
+	for i := range edges {
+		edges[i] = float32(decodeSRGB((float64(i) + 0.5) / (toneBins - 1)))
+	}
+	return edges
+})
+
+// toneBin returns the equalizer bin of linear luminance l.
+func toneBin(edges []float32, l float32) int {
+	return sort.Search(len(edges), func(i int) bool { return edges[i] > l })
+}
+
+// adjustTone applies the tone adjustments of opts to luma in place, in linear
+// light: auto levels, then equalization, gamma, brightness and contrast. The
+// result is encoded again, so thresholds and gray levels keep their meaning.
+func adjustTone(luma []int32, width, height int, opts Options) {
+	if len(luma) == 0 {
+		return
+	}
+	table := linearTable()
+	lin := make([]float32, len(luma))
+	for i, v := range luma {
+		lin[i] = table[min(max(v, 0), 0xFFFF)]
+	}
+
+	if opts.AutoLevels > 0 {
+		autoLevels(lin, opts.AutoLevels)
+	}
+	switch opts.Equalize {
+	case Histogram:
+		equalizeHistogram(lin)
+	case CLAHE:
+		equalizeCLAHE(lin, width, height)
+	}
+
+	gamma := opts.Gamma
+	if gamma == 0 {
+		gamma = 1
+	}
+	factor := 1 + opts.Contrast
+	parallelRows(width, height, func(y0, y1 int) {
+		for i := y0 * width; i < y1*width; i++ {
+			l := float64(lin[i])
+			if gamma != 1 {
+				l = math.Pow(l, 1/gamma)
+			}
+			l = middleGray + (l+opts.Brightness-middleGray)*factor
+			luma[i] = int32(math.Round(encodeSRGB(min(max(l, 0), 1)) * 0xFFFF))
+		}
+	})
+}
+
+// autoLevels stretches lin so that percent of the pixels are clipped to black
+// and as many to white. A flat image is left as it is.
+func autoLevels(lin []float32, percent float64) {
+	sorted := slices.Clone(lin)
+	slices.Sort(sorted)
+	clip := int(float64(len(sorted)) * percent / 100)
+	lo, hi := sorted[clip], sorted[len(sorted)-1-clip]
+	if hi <= lo {
+		return
+	}
+	for i, l := range lin {
+		lin[i] = min(max((l-lo)/(hi-lo), 0), 1)
+	}
+}
+
+// equalizeHistogram spreads the tones of lin evenly over the gray levels, as
+// they are seen rather than in linear light. The darkest tone becomes black,
+// and an image of a single tone is left as it is.
+func equalizeHistogram(lin []float32) {
+	edges := binEdges()
+	var hist [toneBins]int
+	for _, l := range lin {
+		hist[toneBin(edges, l)]++
+	}
+	first := 0
+	for first < toneBins && hist[first] == 0 {
+		first++
+	}
+	if first == toneBins || hist[first] == len(lin) {
+		return
+	}
+
+	var mapping [toneBins]float32
+	base, cum := hist[first], 0
+	for b, n := range hist {
+		cum += n
+		mapping[b] = float32(decodeSRGB(float64(max(cum-base, 0)) / float64(len(lin)-base)))
+	}
+	for i, l := range lin {
+		lin[i] = mapping[toneBin(edges, l)]
+	}
+}
+
+// equalizeCLAHE equalizes lin region by region with contrast-limited adaptive
+// histogram equalization. Each region's histogram is clipped at claheClipLimit
+// times the mean count, spreading the excess over all levels, so flat areas
+// are not turned into noise; pixels blend the mappings of the nearest regions
+// so no seams show.
+func equalizeCLAHE(lin []float32, width, height int) {
+	if width == 0 || height == 0 {
+		return
+	}
+	edges := binEdges()
+	tilesX, tilesY := min(claheTiles, width), min(claheTiles, height)
+	bins := make([]int, len(lin))
+	for i, l := range lin {
+		bins[i] = toneBin(edges, l)
+	}
+
+	// Build the mapping of every region
+	mappings := make([][toneBins]float32, tilesX*tilesY)
+	for ty := 0; ty < tilesY; ty++ {
+		y0, y1 := ty*height/tilesY, (ty+1)*height/tilesY
+		for tx := 0; tx < tilesX; tx++ {
+			x0, x1 := tx*width/tilesX, (tx+1)*width/tilesX
+			var hist [toneBins]float64
+			for y := y0; y < y1; y++ {
+				for _, b := range bins[y*width+x0 : y*width+x1] {
+					hist[b]++
+				}
+			}
+			area := float64((x1 - x0) * (y1 - y0))
+			clipHistogram(hist[:], claheClipLimit*area/toneBins)
+
+			m := &mappings[ty*tilesX+tx]
+			cum := 0.0
+			for b, n := range hist {
+				cum += n
+				m[b] = float32(decodeSRGB(min(cum/area, 1)))
+			}
+		}
+	}
+
+	// Blend the four regions around each pixel by its distance to their centers
+	cell := func(pos, size, tiles int) (int, int, float32) {
+		f := (float64(pos)+0.5)*float64(tiles)/float64(size) - 0.5
+		t0 := int(math.Floor(f))
+		w := float32(f - float64(t0))
+		return min(max(t0, 0), tiles-1), min(max(t0+1, 0), tiles-1), w
+	}
+	parallelRows(width, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			ty0, ty1, wy := cell(y, height, tilesY)
+			for x := 0; x < width; x++ {
+				tx0, tx1, wx := cell(x, width, tilesX)
+				b := bins[y*width+x]
+				top := mappings[ty0*tilesX+tx0][b]*(1-wx) + mappings[ty0*tilesX+tx1][b]*wx
+				bottom := mappings[ty1*tilesX+tx0][b]*(1-wx) + mappings[ty1*tilesX+tx1][b]*wx
+				lin[y*width+x] = top*(1-wy) + bottom*wy
+			}
+		}
+	})
+}
+
+// clipHistogram caps every count of hist at limit and spreads the excess
+// evenly over all bins, keeping the total.
+func clipHistogram(hist []float64, limit float64) {
+	excess := 0.0
+	for i, n := range hist {
+		if n > limit {
+			excess += n - limit
+			hist[i] = limit
+		}
+	}
+	share := excess / float64(len(hist))
+	for i := range hist {
+		hist[i] += share
+	}
+}
@@ -0,0 +1,165 @@
+package bitmap
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+	"testing"
+)
+
+// grayRamp creates a horizontal ramp from lo to hi
+func grayRamp(width, height int, lo, hi uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: lo + uint8(x*int(hi-lo)/max(1, width-1))})
+		}
+	}
+	return img
+}
+
+// convertGray8 converts src to Gray8 with opts, failing the test on errors
+func convertGray8(t *testing.T, src image.Image, opts Options) []byte {
+	t.Helper()
+	opts.Format = Gray8
+	bm, err := Convert(src, opts)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	return bm.Data
+}
+
+func TestSRGBRoundTrip(t *testing.T) {
+	for v := 0.0; v <= 1; v += 1.0 / 64 {
+		if got := encodeSRGB(decodeSRGB(v)); math.Abs(got-v) > 1e-9 {
+			t.Errorf("encodeSRGB(decodeSRGB(%g)) = %g", v, got)
+		}
+	}
+	// Middle gray in linear light is a little under half way when encoded
+	if v := encodeSRGB(middleGray); v < 0.45 || v > 0.47 {
+		t.Errorf("Expected middle gray near 0.46, got %g", v)
+	}
+}
+
+func TestConvertTone(t *testing.T) {
+	src := grayRamp(64, 1, 0, 255)
+	plain := convertGray8(t, src, Options{})
+
+	// Neutral settings leave every pixel as it was
+	if got := convertGray8(t, src, Options{Gamma: 1}); !bytes.Equal(got, plain) {
+		t.Errorf("Expected gamma 1 to change nothing, got % X", got)
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		// check reports whether a pixel of value v in the plain conversion is
+		// expected to come out as got
+		check func(v, got byte) bool
+	}{
+		{name: "gamma", opts: Options{Gamma: 2}, check: func(v, got byte) bool { return got >= v }},
+		{name: "darker gamma", opts: Options{Gamma: 0.5}, check: func(v, got byte) bool { return got <= v }},
+		{name: "brightness", opts: Options{Brightness: 0.1}, check: func(v, got byte) bool { return got >= v }},
+		{name: "contrast", opts: Options{Contrast: 0.5}, check: func(v, got byte) bool {
+			// Middle gray stays put, darker tones get darker and lighter ones lighter
+			if v < 110 {
+				return got <= v
+			}
+			if v > 126 {
+				return got >= v
+			}
+			return true
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convertGray8(t, src, tt.opts)
+			changed := false
+			for i, v := range plain {
+				if !tt.check(v, got[i]) {
+					t.Fatalf("Pixel %d: %d became %d", i, v, got[i])
+				}
+				changed = changed || got[i] != v
+			}
+			if !changed {
+				t.Errorf("Expected the tones to change")
+			}
+			if !slices.IsSorted(got) {
+				t.Errorf("Expected the ramp to stay in order, got % X", got)
+			}
+		})
+	}
+}
+
+func TestConvertAutoLevelsAndEqualize(t *testing.T) {
+	// A dull ramp from dark gray to light gray
+	src := grayRamp(200, 4, 90, 160)
+	for _, opts := range []Options{{AutoLevels: 1}, {Equalize: Histogram}} {
+		got := convertGray8(t, src, opts)
+		lo, hi := slices.Min(got), slices.Max(got)
+		if lo > 10 || hi < 245 {
+			t.Errorf("%+v: expected the tones to span the range, got %d to %d", opts, lo, hi)
+		}
+		if row := got[:200]; !slices.IsSorted(row) {
+			t.Errorf("%+v: expected the ramp to stay in order, got % X", opts, row)
+		}
+	}
+
+	// A flat image has nothing to stretch or spread
+	flat := grayRamp(40, 40, 128, 128)
+	plain := convertGray8(t, flat, Options{})
+	for _, opts := range []Options{{AutoLevels: 5}, {Equalize: Histogram}} {
+		if got := convertGray8(t, flat, opts); !bytes.Equal(got, plain) {
+			t.Errorf("%+v: expected a flat image to stay as it is", opts)
+		}
+	}
+	got := convertGray8(t, flat, Options{Equalize: CLAHE})
+	if slices.Min(got) != slices.Max(got) {
+		t.Errorf("Expected CLAHE to keep a flat image flat, got %d to %d", slices.Min(got), slices.Max(got))
+	}
+}
+
+func TestConvertCLAHELocalContrast(t *testing.T) {
+	// Two dull halves, one dark and one light, which a global stretch cannot
+	// both bring out. Each region holds one tooth of a faint saw.
+	const width, height = 256, 128
+	src := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(x%32/2 + 10)
+			if x >= width/2 {
+				v += 215
+			}
+			src.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	spread := func(data []byte, x0, x1 int) int {
+		row := data[height/2*width+x0 : height/2*width+x1]
+		return int(slices.Max(row)) - int(slices.Min(row))
+	}
+	plain := convertGray8(t, src, Options{})
+	got := convertGray8(t, src, Options{Equalize: CLAHE})
+	for _, half := range [][2]int{{64, 96}, {160, 192}} {
+		if spread(got, half[0], half[1]) <= 2*spread(plain, half[0], half[1]) {
+			t.Errorf("Expected CLAHE to bring out the detail of %v, got a spread of %d from %d",
+				half, spread(got, half[0], half[1]), spread(plain, half[0], half[1]))
+		}
+	}
+}
+
+func TestConvertToneInvalid(t *testing.T) {
+	for _, opts := range []Options{
+		{Gamma: -1},
+		{Brightness: 2},
+		{Contrast: math.NaN()},
+		{AutoLevels: 60},
+		{Equalize: Equalize(9)},
+		{Format: RGB565, Gamma: 2},
+	} {
+		if _, err := Convert(grayRamp(4, 4, 0, 255), opts); err == nil {
+			t.Errorf("Expected an error for %+v, got nil", opts)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package bitmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// prepare crops src, turns it when the transform applies to the source, and
+// resizes and places it: the steps Convert and ConvertMask share. The options
+// must have passed validateResize; a crop that misses the source and margins
+// that leave no room are errors.
+func prepare(src image.Image, opts Options) (image.Image, error) {
+	if !image.Rectangle(opts.Crop).Empty() {
+		sb := src.Bounds()
+		r := image.Rectangle(opts.Crop).Add(sb.Min)
+		if !r.In(sb) {
+			return nil, fmt.Errorf("crop %s is outside the %dx%d source", opts.Crop, sb.Dx(), sb.Dy())
+		}
+		src = subImage(src, r)
+	}
+	if opts.TransformStage == TransformSource && opts.transforms() {
+		src = orientImage(src, opts.Rotate, opts.Flip)
+	}
+	sb := src.Bounds()
+	canvas, area, box := opts.layout(sb.Dx(), sb.Dy())
+	if area.Empty() && !canvas.Empty() {
+		return nil, fmt.Errorf("margins %s leave no room in the %dx%d bitmap", opts.Margins, canvas.Dx(), canvas.Dy())
+	}
+	return place(src, canvas, area, box, opts), nil
+}
+
+// transforms reports whether Rotate or Flip changes the image.
+func (o Options) transforms() bool {
+	return o.Rotate != Rotate0 || o.Flip != NoFlip
+}
+
+// croppedImage shows the part r of an image without a SubImage method.
+type croppedImage struct {
+	image.Image
+	r image.Rectangle
+}
+
+func (c croppedImage) Bounds() image.Rectangle { return c.r }
+
+// subImage returns the part r of img, sharing its pixels.
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+	return croppedImage{img, r}
+}
+
+// orientImage returns img turned by rot and then mirrored by flip.
+func orientImage(img image.Image, rot Rotation, flip Flip) *image.RGBA {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	pix := make([]color.RGBA, width*height)
+	parallelRows(width, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			readRow(img, y, pix[y*width:(y+1)*width])
+		}
+	})
+	pix, width, height = orient(pix, width, height, rot, flip)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, c := range pix {
+		p := dst.Pix[4*i : 4*i+4 : 4*i+4]
+		p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+	}
+	return dst
+}
+
+// orient returns the width x height pixels of src turned by rot and then
+// mirrored by flip, with the width and height of the result.
+func orient[T any](src []T, width, height int, rot Rotation, flip Flip) ([]T, int, int) {
+	w, h := width, height
+	if rot == Rotate90 || rot == Rotate270 {
+		w, h = height, width
+	}
+	dst := make([]T, len(src))
+	parallelRows(width, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				dx, dy := x, y
+				switch rot {
+				case Rotate90:
+					dx, dy = height-1-y, x
+				case Rotate180:
+					dx, dy = width-1-x, height-1-y
+				case Rotate270:
+					dx, dy = y, width-1-x
+				}
+				switch flip {
+				case FlipHorizontal:
+					dx = w - 1 - dx
+				case FlipVertical:
+					dy = h - 1 - dy
+				}
+				dst[dy*w+dx] = src[y*width+x]
+			}
+		}
+	})
+	return dst, w, h
+}
@@ -0,0 +1,111 @@
+package bitmap
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func TestOrient(t *testing.T) {
+	// 1 2 3
+	// 4 5 6
+	src := []int{1, 2, 3, 4, 5, 6}
+	tests := []struct {
+		rot      Rotation
+		flip     Flip
+		w, h     int
+		expected []int
+	}{
+		{rot: Rotate0, w: 3, h: 2, expected: []int{1, 2, 3, 4, 5, 6}},
+		{rot: Rotate90, w: 2, h: 3, expected: []int{4, 1, 5, 2, 6, 3}},
+		{rot: Rotate180, w: 3, h: 2, expected: []int{6, 5, 4, 3, 2, 1}},
+		{rot: Rotate270, w: 2, h: 3, expected: []int{3, 6, 2, 5, 1, 4}},
+		{flip: FlipHorizontal, w: 3, h: 2, expected: []int{3, 2, 1, 6, 5, 4}},
+		{flip: FlipVertical, w: 3, h: 2, expected: []int{4, 5, 6, 1, 2, 3}},
+		// The flip applies to the turned image
+		{rot: Rotate90, flip: FlipHorizontal, w: 2, h: 3, expected: []int{1, 4, 2, 5, 3, 6}},
+	}
+	for _, tt := range tests {
+		got, w, h := orient(src, 3, 2, tt.rot, tt.flip)
+		if w != tt.w || h != tt.h || !slices.Equal(got, tt.expected) {
+			t.Errorf("%s %s: expected %dx%d %v, got %dx%d %v", tt.rot, tt.flip, tt.w, tt.h, tt.expected, w, h, got)
+		}
+	}
+}
+
+// arrow returns an 8x4 image with a black bar along the top and down the left
+func arrow() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			if y == 0 || x == 0 {
+				img.SetGray(x, y, color.Gray{})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 0xFF})
+			}
+		}
+	}
+	return img
+}
+
+func TestConvertTransform(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		w, h     int
+		expected []byte
+	}{
+		{name: "none", w: 8, h: 4, expected: []byte{0xFF, 0x80, 0x80, 0x80}},
+		{name: "rotate", opts: Options{Rotate: Rotate90}, w: 4, h: 8, expected: []byte{0xF0, 0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x10}},
+		{name: "flip", opts: Options{Flip: FlipVertical}, w: 8, h: 4, expected: []byte{0x80, 0x80, 0x80, 0xFF}},
+		// Before resizing, the size is that of the result
+		{name: "source", opts: Options{Rotate: Rotate90, Width: 8, Height: 4, Resize: Fit, Scaler: NearestNeighbor}, w: 8, h: 4, expected: []byte{0x08, 0x08, 0x08, 0x08}},
+		// After converting, the size is turned with the pixels
+		{name: "output", opts: Options{Rotate: Rotate270, TransformStage: TransformOutput, Width: 8, Height: 4}, w: 4, h: 8, expected: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0xF0}},
+		{name: "crop", opts: Options{Crop: Rect(image.Rect(0, 0, 4, 2))}, w: 4, h: 2, expected: []byte{0xF0, 0x80}},
+		{name: "crop offset", opts: Options{Crop: Rect(image.Rect(4, 1, 8, 4))}, w: 4, h: 3, expected: []byte{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm, err := Convert(arrow(), tt.opts)
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if bm.Width != tt.w || bm.Height != tt.h || !bytes.Equal(bm.Data, tt.expected) {
+				t.Errorf("Expected %dx%d % X, got %dx%d % X", tt.w, tt.h, tt.expected, bm.Width, bm.Height, bm.Data)
+			}
+
+			// The mask lines up with the bitmap
+			mask, err := ConvertMask(arrow(), tt.opts, 0)
+			if err != nil {
+				t.Fatalf("ConvertMask failed: %v", err)
+			}
+			if mask.Width != bm.Width || mask.Height != bm.Height {
+				t.Errorf("Expected a %dx%d mask, got %dx%d", bm.Width, bm.Height, mask.Width, mask.Height)
+			}
+		})
+	}
+}
+
+func TestConvertCropSubImage(t *testing.T) {
+	// Crops are relative to the bounds, and images without SubImage are cropped too
+	sub := arrow().SubImage(image.Rect(1, 1, 8, 4))
+	for _, src := range []image.Image{sub, opaqueImage{sub}} {
+		bm, err := Convert(src, Options{Crop: Rect(image.Rect(0, 0, 2, 2))})
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		if bm.Width != 2 || bm.Height != 2 || !bytes.Equal(bm.Data, []byte{0, 0}) {
+			t.Errorf("%T: expected 2x2 00 00, got %dx%d % X", src, bm.Width, bm.Height, bm.Data)
+		}
+	}
+
+	_, err := Convert(arrow(), Options{Crop: Rect(image.Rect(4, 0, 12, 4))})
+	var ce *ConvertError
+	if !errors.As(err, &ce) || ce.Stage != "resize" {
+		t.Errorf("Expected a resize error for a crop outside the source, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"image2bytes/bitmap"
+)
+
+// cacheEnv overrides the cache directory, like GOCACHE does for the go
+// command. Setting it to "off" disables the cache.
+const cacheEnv = "IMAGE2BYTES_CACHE"
+
+// buildCache stores converted bitmaps keyed by a hash of the input bytes, the
+// conversion options and the tool itself, so unchanged images skip the
+// resize and dither. Entries are files in subdirectories named after the first
+// two hex digits of their key, like Go's build cache.
+type buildCache struct {
+	dir string
+}
+
+// cacheDir returns the directory of the cache: $IMAGE2BYTES_CACHE, or
+// image2bytes in the user cache directory.
+func cacheDir() (string, error) {
+	switch dir := os.Getenv(cacheEnv); dir {
+	case "off":
+		return "", fmt.Errorf("the cache is disabled by %s=off", cacheEnv)
+	case "":
+	default:
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "image2bytes"), nil
+}
+
+// openCache returns the cache, or nil when it is disabled or there is no cache
+// directory. The cache only saves time, so a conversion never fails because of it.
+func openCache() *buildCache {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil
+	}
+	return &buildCache{dir: dir}
+}
+
+// toolID identifies the running binary, so a new version of the conversion
+// code never reuses results of an older one. It hashes the executable, and
+// falls back to the module version when the executable cannot be read.
+var toolID = sync.OnceValue(func() string {
+	if exe, err := os.Executable(); err == nil {
+		if sum, err := hashFile(exe); err == nil {
+			return sum
+		}
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+})
+
+// cacheKey returns the key of converting data with opts. Extra arguments tell
+// apart other results of the same conversion, like masks.
+func cacheKey(data []byte, opts bitmap.Options, extra ...string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "image2bytes %s\n%s\n", toolID(), strings.Join(append(bitmapOptionArgs(opts), extra...), " "))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// path returns the file of the entry with key.
+func (c *buildCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+"-b")
+}
+
+// get returns the bitmap stored under key, or nil when there is none. Entries
+// that cannot be read are misses.
+func (c *buildCache) get(key string) *bitmap.Bitmap {
+	if c == nil {
+		return nil
+	}
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil
+	}
+
+	// An entry is a line describing the layout, then the packed data
+	line, data, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return nil
+	}
+	var width, height int
+	var format, order, polarity string
+	if _, err := fmt.Sscanf(string(line), "%d %d %s %s %s", &width, &height, &format, &order, &polarity); err != nil {
+		return nil
+	}
+	var f bitmap.PixelFormat
+	var o bitmap.BitOrder
+	var p bitmap.Polarity
+	if f.UnmarshalText([]byte(format)) != nil || o.UnmarshalText([]byte(order)) != nil || p.UnmarshalText([]byte(polarity)) != nil {
+		return nil
+	}
+	bm, err := bitmap.New(width, height, f, o, data)
+	if err != nil || len(bm.Data) != len(data) {
+		return nil
+	}
+	bm.Polarity = p
+	return bm
+}
+
+// put stores bm under key. Failures are ignored: the next run converts again.
+func (c *buildCache) put(key string, bm *bitmap.Bitmap) {
+	if c == nil {
+		return
+	}
+	path := c.path(key)
+	if os.MkdirAll(filepath.Dir(path), 0o755) != nil {
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d %d %s %s %s\n", bm.Width, bm.Height, bm.Format, bm.BitOrder, bm.Polarity)
+	buf.Write(bm.Data)
+	replaceFile(path, buf.Bytes())
+}
+
+// clean removes every entry and returns how many there were. Only the entry
+// directories are removed, so pointing the cache at a directory by mistake
+// cannot delete anything else.
+func (c *buildCache) clean() (int, error) {
+	dirs, err := os.ReadDir(c.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, d := range dirs {
+		if !d.IsDir() || len(d.Name()) != 2 {
+			continue
+		}
+		if _, err := hex.DecodeString(d.Name()); err != nil {
+			continue
+		}
+		sub := filepath.Join(c.dir, d.Name())
+		entries, err := os.ReadDir(sub)
+		if err != nil {
+			return removed, err
+		}
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), "-b") {
+				removed++
+			}
+		}
+		if err := os.RemoveAll(sub); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// cacheCommand implements "image2bytes cache", which prints where the cache is
+// or empties it. It returns the exit code like run.
+func cacheCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("cache", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go run . cache dir|clean")
+		fmt.Fprintf(fs.Output(), "The cache is in $%s, or image2bytes in the user cache directory.\n", cacheEnv)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() != 1 || (fs.Arg(0) != "dir" && fs.Arg(0) != "clean") {
+		fs.Usage()
+		return exitUsage
+	}
+
+	dir, err := cacheDir()
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return exitError
+	}
+	if fs.Arg(0) == "dir" {
+		fmt.Fprintln(stdout, dir)
+		return exitOK
+	}
+	c := &buildCache{dir: dir}
+	removed, err := c.clean()
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: clean %s: %v\n", dir, err)
+		return exitError
+	}
+	fmt.Fprintf(stdout, "Removed %d cached conversions from %s\n", removed, dir)
+	return exitOK
+}
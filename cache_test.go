@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"image2bytes/bitmap"
+)
+
+func TestBuildCache(t *testing.T) {
+	c := &buildCache{dir: t.TempDir()}
+	bm, _ := bitmap.New(3, 2, bitmap.Gray2, bitmap.LSBFirst, []byte{0x1B, 0xE4})
+	bm.Polarity = bitmap.HighBlack
+	key := cacheKey([]byte("png"), bitmap.Options{})
+
+	if got := c.get(key); got != nil {
+		t.Fatalf("Expected a miss, got %+v", got)
+	}
+	c.put(key, bm)
+	got := c.get(key)
+	if got == nil || got.Width != 3 || got.Height != 2 || got.Format != bitmap.Gray2 || got.BitOrder != bitmap.LSBFirst || got.Polarity != bitmap.HighBlack || !bytes.Equal(got.Data, bm.Data) {
+		t.Errorf("Expected %+v, got %+v", bm, got)
+	}
+
+	// The key depends on the data and on every option
+	for _, other := range []string{
+		cacheKey([]byte("png2"), bitmap.Options{}),
+		cacheKey([]byte("png"), bitmap.Options{Threshold: 100}),
+		cacheKey([]byte("png"), bitmap.Options{Dither: bitmap.Atkinson}),
+	} {
+		if other == key {
+			t.Errorf("Expected different keys")
+		}
+	}
+	// Threshold zero means 128
+	if cacheKey([]byte("png"), bitmap.Options{Threshold: 128}) != key {
+		t.Errorf("Expected equal options to share a key")
+	}
+
+	// Damaged entries are misses
+	for _, data := range []string{
+		"", "3 2 gray2 lsb black", "3 2 gray2 lsb black\n\x1B", "3 2 gray9 lsb black\n\x1B\xE4", "3 2 gray2 lsb black\n\x1B\xE4\x00",
+		"3 2 gray2 lsb grey\n\x1B\xE4", "3 2 gray2 lsb\n\x1B\xE4",
+	} {
+		if err := os.WriteFile(c.path(key), []byte(data), 0o644); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+		if got := c.get(key); got != nil {
+			t.Errorf("Expected a miss for %q, got %+v", data, got)
+		}
+	}
+
+	// A disabled cache never hits
+	var off *buildCache
+	off.put(key, bm)
+	if off.get(key) != nil {
+		t.Errorf("Expected a nil cache to miss")
+	}
+}
+
+func TestConvertCached(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Setenv(cacheEnv, cacheDir)
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "logo.png")
+	writeCheckerboardPNG(t, inputPath, 16, 8)
+	outputPath := filepath.Join(dir, "logo.go")
+
+	convert := func(args ...string) string {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		if code := run(append(args, "-dither", "atkinson", inputPath, outputPath), &stdout, &stderr); code != exitOK {
+			t.Fatalf("run failed: %s", stderr.String())
+		}
+		return stdout.String()
+	}
+	if out := convert(); strings.Contains(out, "(cached)") {
+		t.Errorf("Expected the first conversion to miss:\n%s", out)
+	}
+	first, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read generated file: %v", err)
+	}
+	if out := convert(); !strings.Contains(out, "Image dimensions: 296x128 (cached)\n") {
+		t.Errorf("Expected the second conversion to hit:\n%s", out)
+	}
+	if out := convert("-no-cache"); strings.Contains(out, "(cached)") {
+		t.Errorf("Expected -no-cache to convert again:\n%s", out)
+	}
+	// Other options miss
+	if out := convert("-threshold", "10"); strings.Contains(out, "(cached)") {
+		t.Errorf("Expected other options to miss:\n%s", out)
+	}
+	convert()
+	if second, err := os.ReadFile(outputPath); err != nil || !bytes.Equal(first, second) {
+		t.Errorf("Expected a cached conversion to generate the same file")
+	}
+
+	// cache dir and cache clean, which leaves other files alone
+	otherPath := filepath.Join(cacheDir, "notes.txt")
+	if err := os.WriteFile(otherPath, []byte("keep"), 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"cache", "dir"}, &stdout, &stderr); code != exitOK || stdout.String() != cacheDir+"\n" {
+		t.Errorf("Expected %s, got %d: %s%s", cacheDir, code, stdout.String(), stderr.String())
+	}
+	stdout.Reset()
+	if code := run([]string{"cache", "clean"}, &stdout, &stderr); code != exitOK || stdout.String() != "Removed 2 cached conversions from "+cacheDir+"\n" {
+		t.Errorf("Unexpected clean: %d: %s%s", code, stdout.String(), stderr.String())
+	}
+	if _, err := os.Stat(otherPath); err != nil {
+		t.Errorf("Expected clean to leave other files: %v", err)
+	}
+	if out := convert(); strings.Contains(out, "(cached)") {
+		t.Errorf("Expected a miss after clean:\n%s", out)
+	}
+}
+
+func TestCacheCommandErrors(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"cache"}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d without an action, got %d", exitUsage, code)
+	}
+	if code := run([]string{"cache", "trim"}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d for an unknown action, got %d", exitUsage, code)
+	}
+
+	// TestMain disables the cache
+	stderr.Reset()
+	if code := run([]string{"cache", "clean"}, &stdout, &stderr); code != exitError || !strings.Contains(stderr.String(), "the cache is disabled") {
+		t.Errorf("Expected exit code %d with the cache disabled, got %d: %s", exitError, code, stderr.String())
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// checkCommand implements "image2bytes check", which reports generated files
+// that are stale: a source changed since the file was generated, or the
+// go:generate directive that makes it asks for other options or sources. It
+// returns exitError when a file is stale, so CI fails until it is regenerated.
+func checkCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go run . check [file.go|dir ...]")
+		fmt.Fprintln(fs.Output(), "Directories are searched recursively; the default is the current directory.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	roots := fs.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	files, err := goFiles(roots)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	// Collect the reasons each file is stale, once each
+	stale := map[string][]string{}
+	checked := map[string]bool{}
+	report := func(path string, reasons ...string) {
+		checked[path] = true
+		for _, r := range reasons {
+			if !slices.Contains(stale[path], r) {
+				stale[path] = append(stale[path], r)
+			}
+		}
+	}
+	for _, path := range files {
+		h, err := readHeader(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: read %v\n", err)
+			return exitError
+		}
+		if h != nil {
+			report(path, h.changedSources(filepath.Dir(path))...)
+			continue
+		}
+
+		directives, err := generateDirectives(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: read %v\n", err)
+			return exitError
+		}
+		for _, d := range directives {
+			output, reasons := d.check()
+			report(output, reasons...)
+		}
+	}
+
+	paths := make([]string, 0, len(stale))
+	for path := range stale {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(stdout, "%s is stale: %s\n", path, strings.Join(stale[path], ", "))
+	}
+	if len(paths) > 0 {
+		fmt.Fprintf(stdout, "%d of %d generated %s stale; run go generate\n", len(paths), len(checked), filesAre(len(checked), len(paths)))
+		return exitError
+	}
+	fmt.Fprintf(stdout, "%d generated %s up to date\n", len(checked), filesAre(len(checked), len(checked)))
+	return exitOK
+}
+
+// filesAre returns the noun and the verb of a summary about some of n files,
+// as in "1 of 3 generated files is stale" or "1 generated file is up to date".
+func filesAre(n, some int) string {
+	noun, verb := "files", "are"
+	if n == 1 {
+		noun = "file"
+	}
+	if some == 1 {
+		verb = "is"
+	}
+	return noun + " " + verb
+}
+
+// goFiles lists the Go files given, and those below the directories given,
+// which may end in /... like package patterns. Like the go tool, it skips
+// directories named testdata or starting with . or _.
+func goFiles(roots []string) ([]string, error) {
+	var files []string
+	for _, root := range roots {
+		if root = strings.TrimSuffix(root, "..."); root == "" {
+			root = "."
+		}
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, root)
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if path != root && (name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type().IsRegular() && isGoFile(path) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// changedSources returns why the sources of a generated file in dir no longer
+// match its header, or nothing when they all do.
+func (h *genHeader) changedSources(dir string) []string {
+	var reasons []string
+	for _, s := range h.sources {
+		sum, err := hashFile(resolvePath(dir, filepath.FromSlash(s.path)))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			reasons = append(reasons, s.path+" is missing")
+		case err != nil:
+			reasons = append(reasons, fmt.Sprintf("%s: %v", s.path, err))
+		case sum != s.sum:
+			reasons = append(reasons, s.path+" changed")
+		}
+	}
+	return reasons
+}
+
+// directive is a go:generate line that runs image2bytes.
+type directive struct {
+	// pos is the file and line of the directive
+	pos  string
+	dir  string
+	args []string
+}
+
+// generateDirectives returns the go:generate lines of a Go file that run
+// image2bytes to convert images or build a manifest.
+func generateDirectives(path string) ([]directive, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var directives []directive
+	for i, line := range strings.Split(string(data), "\n") {
+		rest, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), "//go:generate ")
+		if !ok {
+			continue
+		}
+		// go generate would reject a malformed line, and it might not be ours anyway
+		words, err := splitDirective(rest, filepath.Base(path))
+		if err != nil {
+			continue
+		}
+		args, ok := image2bytesArgs(words)
+		if !ok || (len(args) > 0 && (args[0] == "decode" || args[0] == "check")) {
+			continue
+		}
+		directives = append(directives, directive{pos: fmt.Sprintf("%s:%d", path, i+1), dir: filepath.Dir(path), args: args})
+	}
+	return directives, nil
+}
+
+// splitDirective splits a go:generate line into words like go generate: on
+// spaces, with double-quoted Go strings, and expanding $GOFILE, $DOLLAR and
+// environment variables.
+func splitDirective(line, goFile string) ([]string, error) {
+	expand := func(s string) string {
+		return os.Expand(s, func(name string) string {
+			switch name {
+			case "GOFILE":
+				return goFile
+			case "DOLLAR":
+				return "$"
+			}
+			return os.Getenv(name)
+		})
+	}
+
+	var words []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimLeft(line, " \t") {
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, err
+			}
+			word, _ := strconv.Unquote(quoted)
+			words = append(words, expand(word))
+			line = line[len(quoted):]
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		words = append(words, expand(line[:end]))
+		line = line[end:]
+	}
+	return words, nil
+}
+
+// image2bytesArgs returns the arguments of a command that runs image2bytes,
+// either installed or as "go run path/to/image2bytes[@version]".
+func image2bytesArgs(words []string) ([]string, bool) {
+	if len(words) == 0 {
+		return nil, false
+	}
+	if strings.TrimSuffix(filepath.Base(words[0]), ".exe") == "image2bytes" {
+		return words[1:], true
+	}
+	if len(words) > 2 && words[0] == "go" && words[1] == "run" {
+		for i, w := range words[2:] {
+			if strings.HasPrefix(w, "-") {
+				continue
+			}
+			pkg, _, _ := strings.Cut(w, "@")
+			return words[i+3:], path.Base(pkg) == "image2bytes"
+		}
+	}
+	return nil, false
+}
+
+// check plans the directive and compares the header it would write with the
+// one of its output. It returns the output, or the directive itself when it
+// cannot be planned, and why it is stale.
+func (d directive) check() (string, []string) {
+	var usage bytes.Buffer
+	var p *plan
+	var err error
+	if len(d.args) > 0 && d.args[0] == "build" {
+		b, _ := parseBuildArgs(d.args[1:], d.dir, &usage)
+		if b == nil {
+			return d.pos, []string{"invalid directive: " + firstLine(usage.String())}
+		}
+		p, err = b.plan()
+	} else if len(d.args) > 0 && d.args[0] == "compose" {
+		c, _ := parseComposeArgs(d.args[1:], d.dir, &usage)
+		if c == nil {
+			return d.pos, []string{"invalid directive: " + firstLine(usage.String())}
+		}
+		p, err = c.plan()
+	} else {
+		c, _ := parseConvertArgs(d.args, d.dir, &usage)
+		if c == nil {
+			return d.pos, []string{"invalid directive: " + firstLine(usage.String())}
+		}
+		p, err = c.plan()
+	}
+	if err != nil {
+		return d.pos, []string{err.Error()}
+	}
+	want, err := p.header(p.entries)
+	if err != nil {
+		return d.pos, []string{err.Error()}
+	}
+
+	output := p.mainOutput()
+	got, err := readHeader(output)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return output, []string{"not generated yet"}
+	case err != nil:
+		return output, []string{err.Error()}
+	case got == nil:
+		return output, []string{"not generated by image2bytes"}
+	}
+
+	var reasons []string
+	if got.command != want.command {
+		reasons = append(reasons, "options changed")
+	}
+	sums := map[string]string{}
+	for _, s := range got.sources {
+		sums[s.path] = s.sum
+	}
+	for _, s := range want.sources {
+		sum, ok := sums[s.path]
+		switch {
+		case !ok:
+			reasons = append(reasons, s.path+" added")
+		case sum != s.sum:
+			reasons = append(reasons, s.path+" changed")
+		}
+		delete(sums, s.path)
+	}
+	for _, s := range got.sources {
+		if _, ok := sums[s.path]; ok {
+			reasons = append(reasons, s.path+" removed")
+		}
+	}
+	return output, reasons
+}
+
+// firstLine returns the first line of the messages in s, without their prefix.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return strings.TrimPrefix(line, "Error: ")
+}
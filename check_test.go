@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSplitDirective(t *testing.T) {
+	t.Setenv("IMAGE2BYTES_TEST_WIDTH", "32")
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{line: "image2bytes -in logo.png -out logo_gen.go", expected: []string{"image2bytes", "-in", "logo.png", "-out", "logo_gen.go"}},
+		{line: "  image2bytes\t-in \"my logo.png\" ", expected: []string{"image2bytes", "-in", "my logo.png"}},
+		{line: "image2bytes -width $IMAGE2BYTES_TEST_WIDTH -out ${GOFILE}.bin", expected: []string{"image2bytes", "-width", "32", "-out", "main.go.bin"}},
+		{line: "echo $DOLLAR", expected: []string{"echo", "$"}},
+	}
+	for _, tt := range tests {
+		got, err := splitDirective(tt.line, "main.go")
+		if err != nil || !slices.Equal(got, tt.expected) {
+			t.Errorf("splitDirective(%q) = %q, %v; expected %q", tt.line, got, err, tt.expected)
+		}
+	}
+	if _, err := splitDirective(`image2bytes -in "logo.png`, "main.go"); err == nil {
+		t.Errorf("Expected an error for an unterminated string")
+	}
+}
+
+func TestImage2bytesArgs(t *testing.T) {
+	tests := []struct {
+		words    []string
+		expected []string
+		ok       bool
+	}{
+		{words: []string{"image2bytes", "-in", "a.png"}, expected: []string{"-in", "a.png"}, ok: true},
+		{words: []string{"/usr/local/bin/image2bytes.exe", "build", "a.yaml"}, expected: []string{"build", "a.yaml"}, ok: true},
+		{words: []string{"go", "run", "github.com/eithansmith/image2bytes@v1.2.0", "a.png", "a.go"}, expected: []string{"a.png", "a.go"}, ok: true},
+		{words: []string{"go", "run", "-mod=mod", "../image2bytes", "a.png", "a.go"}, expected: []string{"a.png", "a.go"}, ok: true},
+		{words: []string{"go", "run", "golang.org/x/tools/cmd/stringer", "-type=Mode"}},
+		{words: []string{"stringer", "-type=Mode"}},
+		{},
+	}
+	for _, tt := range tests {
+		got, ok := image2bytesArgs(tt.words)
+		if ok != tt.ok || (ok && !slices.Equal(got, tt.expected)) {
+			t.Errorf("image2bytesArgs(%q) = %q, %t; expected %q, %t", tt.words, got, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+// writeDirectives writes a Go file holding the given go:generate lines
+func writeDirectives(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	content := "package assets\n\n"
+	for _, line := range lines {
+		content += "//go:generate " + line + "\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+// runCheck runs the check command and returns its exit code and output
+func runCheck(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"check"}, args...), &stdout, &stderr)
+	if stderr.Len() > 0 {
+		t.Errorf("Unexpected errors: %s", stderr.String())
+	}
+	return code, stdout.String()
+}
+
+func TestCheckCommand(t *testing.T) {
+	dir := t.TempDir()
+	logoPath := filepath.Join(dir, "logo.png")
+	writeCheckerboardPNG(t, logoPath, 8, 8)
+	iconsDir := filepath.Join(dir, "icons")
+	if err := os.Mkdir(iconsDir, 0o755); err != nil {
+		t.Fatalf("Failed to create icons dir: %v", err)
+	}
+	writeCheckerboardPNG(t, filepath.Join(iconsDir, "arrow.png"), 4, 4)
+	generatePath := filepath.Join(dir, "generate.go")
+	writeDirectives(t, generatePath,
+		"image2bytes -in logo.png -out logo_gen.go -width 8 -height 8",
+		"go run github.com/eithansmith/image2bytes@latest -out icons_gen.go -in icons",
+		"stringer -type=Mode",
+	)
+
+	// Nothing was generated yet
+	code, out := runCheck(t, dir)
+	if code != exitError || !strings.Contains(out, "logo_gen.go is stale: not generated yet") || !strings.Contains(out, "icons_gen.go is stale: not generated yet") {
+		t.Errorf("Expected both outputs to be missing, got %d:\n%s", code, out)
+	}
+
+	// Generate like go generate does, from the directory of the directive
+	generate := func() {
+		t.Helper()
+		t.Chdir(dir)
+		var stdout, stderr bytes.Buffer
+		for _, args := range [][]string{
+			{"-in", "logo.png", "-out", "logo_gen.go", "-width", "8", "-height", "8"},
+			{"-out", "icons_gen.go", "-in", "icons"},
+		} {
+			if code := run(args, &stdout, &stderr); code != exitOK {
+				t.Fatalf("run(%q) failed: %s", args, stderr.String())
+			}
+		}
+	}
+	generate()
+	code, out = runCheck(t, dir)
+	if code != exitOK || out != "2 generated files are up to date\n" {
+		t.Errorf("Expected everything to be up to date, got %d:\n%s", code, out)
+	}
+	// The current directory is the default, and package patterns work too
+	for _, args := range [][]string{nil, {"./..."}, {"..."}} {
+		if code, out = runCheck(t, args...); code != exitOK {
+			t.Errorf("check %q: expected everything to be up to date, got %d:\n%s", args, code, out)
+		}
+	}
+	if code, out = runCheck(t, "logo_gen.go"); code != exitOK || out != "1 generated file is up to date\n" {
+		t.Errorf("Expected a single file to be up to date, got %d:\n%s", code, out)
+	}
+
+	// Edit an image, change the options and add an icon
+	writeCheckerboardPNG(t, logoPath, 8, 4)
+	writeDirectives(t, generatePath,
+		"image2bytes -in logo.png -out logo_gen.go -width 8 -height 8",
+		"go run github.com/eithansmith/image2bytes@latest -out icons_gen.go -in icons -format gray2",
+	)
+	writeCheckerboardPNG(t, filepath.Join(iconsDir, "home.png"), 4, 4)
+	code, out = runCheck(t, dir)
+	expected := []string{
+		filepath.Join(dir, "icons_gen.go") + " is stale: options changed, icons/home.png added",
+		filepath.Join(dir, "logo_gen.go") + " is stale: logo.png changed",
+		"2 of 2 generated files are stale; run go generate",
+	}
+	if code != exitError || out != strings.Join(expected, "\n")+"\n" {
+		t.Errorf("Expected %d and:\n%s\ngot %d:\n%s", exitError, strings.Join(expected, "\n"), code, out)
+	}
+	if code, out = runCheck(t, "logo_gen.go"); code != exitError || !strings.HasSuffix(out, "\n1 of 1 generated file is stale; run go generate\n") {
+		t.Errorf("Expected a single file to be stale, got %d:\n%s", code, out)
+	}
+
+	// Generated files are checked against their sources even without a directive
+	if err := os.Remove(generatePath); err != nil {
+		t.Fatalf("Failed to remove directives: %v", err)
+	}
+	code, out = runCheck(t, filepath.Join(dir, "logo_gen.go"))
+	if code != exitError || !strings.Contains(out, "logo_gen.go is stale: logo.png changed") {
+		t.Errorf("Expected logo_gen.go to be stale, got %d:\n%s", code, out)
+	}
+}
+
+func TestCheckCommandManifest(t *testing.T) {
+	dir := t.TempDir()
+	writeCheckerboardPNG(t, filepath.Join(dir, "logo.png"), 16, 8)
+	manifestPath := filepath.Join(dir, "assets.yaml")
+	writeManifest := func(threshold int) {
+		t.Helper()
+		manifest := "package: assets\noutput: out\nassets:\n  - input: logo.png\n    threshold: " + strconv.Itoa(threshold) + "\n"
+		if err := os.WriteFile(manifestPath, []byte(manifest), 0o644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+	}
+	writeManifest(100)
+	writeDirectives(t, filepath.Join(dir, "generate.go"), "image2bytes build assets.yaml")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build", manifestPath}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("build failed: %s", stderr.String())
+	}
+	// The index and the file of the image are both checked
+	if code, out := runCheck(t, dir); code != exitOK || out != "2 generated files are up to date\n" {
+		t.Errorf("Expected everything to be up to date, got %d:\n%s", code, out)
+	}
+
+	// Every generated file depends on the manifest
+	writeManifest(120)
+	code, out := runCheck(t, dir)
+	expected := []string{
+		filepath.Join(dir, "out", "bitmaps.go") + " is stale: ../assets.yaml changed",
+		filepath.Join(dir, "out", "logo.go") + " is stale: ../assets.yaml changed",
+		"2 of 2 generated files are stale; run go generate",
+	}
+	if code != exitError || out != strings.Join(expected, "\n")+"\n" {
+		t.Errorf("Expected %d and:\n%s\ngot %d:\n%s", exitError, strings.Join(expected, "\n"), code, out)
+	}
+}
+
+func TestCheckCommandErrors(t *testing.T) {
+	dir := t.TempDir()
+	generatePath := filepath.Join(dir, "generate.go")
+	writeDirectives(t, generatePath,
+		"image2bytes -in missing.png -out missing_gen.go",
+		"image2bytes -in logo.jpg -out logo_gen.go",
+	)
+
+	code, out := runCheck(t, dir)
+	for _, expected := range []string{
+		generatePath + ":3 is stale: read " + filepath.Join(dir, "missing.png") + ": no such file or directory",
+		generatePath + ":4 is stale: invalid directive: Input file must be a PNG file (with .png extension)",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, out)
+		}
+	}
+	if code != exitError {
+		t.Errorf("Expected exit code %d, got %d", exitError, code)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"check", filepath.Join(dir, "nonexistent")}, &stdout, &stderr); code != exitError {
+		t.Errorf("Expected exit code %d for a missing path, got %d", exitError, code)
+	}
+}
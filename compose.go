@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/token"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/image/draw"
+
+	"image2bytes/bitmap"
+	"image2bytes/compress"
+)
+
+// layout describes a screen made of layers drawn in order onto a canvas the
+// size of the bitmap, which is then converted like an image. It can be written
+// in YAML, JSON or TOML, and takes the conversion options of a manifest.
+type layout struct {
+	// Package is the package clause of the generated code; "main" when empty.
+	Package string `yaml:"package" json:"package" toml:"package"`
+	// Output is the Go file the screen is written to.
+	Output string `yaml:"output" json:"output" toml:"output"`
+	// Name overrides the identifier derived from the output file name.
+	Name            string `yaml:"name" json:"name" toml:"name"`
+	manifestOptions `yaml:",inline"`
+	Layers          []layoutLayer `yaml:"layers" json:"layers" toml:"layers"`
+}
+
+// layoutLayer is one layer of a layout. Type decides which fields apply:
+//
+//   - image draws the PNG file Input with its top-left corner at X,Y, scaled
+//     to Width x Height when they are set
+//   - text draws Text with its top-left corner at X,Y in the TrueType or
+//     OpenType file Font, or the built-in pixel font, one line per newline and
+//     wrapped at Width when it is set
+//   - rect draws a Width x Height rectangle at X,Y: filled, or outlined
+//     Thickness pixels wide
+//   - line draws a line Thickness pixels wide from X,Y to X2,Y2
+//
+// Text, rectangles and lines are drawn in Color, black by default.
+type layoutLayer struct {
+	Type      string        `yaml:"type" json:"type" toml:"type"`
+	X         int           `yaml:"x" json:"x" toml:"x"`
+	Y         int           `yaml:"y" json:"y" toml:"y"`
+	X2        int           `yaml:"x2" json:"x2" toml:"x2"`
+	Y2        int           `yaml:"y2" json:"y2" toml:"y2"`
+	Width     int           `yaml:"width" json:"width" toml:"width"`
+	Height    int           `yaml:"height" json:"height" toml:"height"`
+	Input     string        `yaml:"input" json:"input" toml:"input"`
+	Text      string        `yaml:"text" json:"text" toml:"text"`
+	Color     *bitmap.Color `yaml:"color" json:"color" toml:"color"`
+	Fill      bool          `yaml:"fill" json:"fill" toml:"fill"`
+	Thickness int           `yaml:"thickness" json:"thickness" toml:"thickness"`
+
+	Font        string         `yaml:"font" json:"font" toml:"font"`
+	Size        float64        `yaml:"size" json:"size" toml:"size"`
+	Hinting     bitmap.Hinting `yaml:"hinting" json:"hinting" toml:"hinting"`
+	Align       bitmap.Align   `yaml:"align" json:"align" toml:"align"`
+	NoAntialias bool           `yaml:"no-antialias" json:"no-antialias" toml:"no-antialias"`
+}
+
+// validate rejects a layer that cannot be drawn.
+func (l layoutLayer) validate() error {
+	switch {
+	case l.Width < 0 || l.Height < 0:
+		return fmt.Errorf("invalid size %dx%d", l.Width, l.Height)
+	case l.Thickness < 0:
+		return fmt.Errorf("invalid thickness %d", l.Thickness)
+	case l.Size < 0:
+		return fmt.Errorf("invalid font size %g", l.Size)
+	}
+	switch l.Type {
+	case "image":
+		if !isPNGFile(l.Input) {
+			return fmt.Errorf("image input %q is not a PNG file", l.Input)
+		}
+	case "text":
+		if l.Text == "" {
+			return fmt.Errorf("missing text")
+		}
+	case "rect":
+		if l.Width == 0 || l.Height == 0 {
+			return fmt.Errorf("missing rectangle size")
+		}
+	case "line":
+	default:
+		return fmt.Errorf("unknown layer type %q (want image, text, rect or line)", l.Type)
+	}
+	return nil
+}
+
+// inputs returns the image and font files the layers are drawn from.
+func (l *layout) inputs() []string {
+	var paths []string
+	for _, layer := range l.Layers {
+		switch {
+		case layer.Type == "image":
+			paths = append(paths, layer.Input)
+		case layer.Type == "text" && layer.Font != "":
+			paths = append(paths, layer.Font)
+		}
+	}
+	return paths
+}
+
+// planLayout reads the layout at path and plans the conversion of its screen.
+// Relative paths in the layout are relative to its directory.
+func planLayout(path string) (*plan, error) {
+	var l layout
+	invalid := func(err error) error {
+		return &stageError{stage: "read", path: path, err: err}
+	}
+	if err := decodeFile(path, &l); err != nil {
+		return nil, invalid(err)
+	}
+
+	// Start from the command-line defaults
+	cfg := convertConfig{
+		options: bitmap.Options{Width: panelWidth, Height: panelHeight},
+		pkg:     "main",
+		codec:   "none",
+		params:  compress.DefaultParams,
+	}
+	if l.Package != "" {
+		cfg.pkg = l.Package
+	}
+	if !token.IsIdentifier(cfg.pkg) {
+		return nil, invalid(fmt.Errorf("invalid package name %q", cfg.pkg))
+	}
+	if !isGoFile(l.Output) {
+		return nil, invalid(fmt.Errorf("output %q is not a Go file", l.Output))
+	}
+	if err := l.apply(&cfg); err != nil {
+		return nil, invalid(err)
+	}
+	if cfg.options.Width == 0 || cfg.options.Height == 0 {
+		return nil, invalid(fmt.Errorf("the screen needs a width and a height"))
+	}
+	if len(l.Layers) == 0 {
+		return nil, invalid(fmt.Errorf("no layers"))
+	}
+	dir := filepath.Dir(path)
+	for i := range l.Layers {
+		layer := &l.Layers[i]
+		if err := layer.validate(); err != nil {
+			return nil, invalid(fmt.Errorf("layer %d: %w", i+1, err))
+		}
+		if layer.Input != "" {
+			layer.Input = resolvePath(dir, layer.Input)
+		}
+		if layer.Font != "" {
+			layer.Font = resolvePath(dir, layer.Font)
+		}
+	}
+	name := l.Name
+	if name == "" {
+		name = identifierFromPath(l.Output)
+	} else if !token.IsIdentifier(name) {
+		return nil, invalid(fmt.Errorf("invalid name %q", name))
+	}
+
+	output := resolvePath(dir, l.Output)
+	p := &plan{output: output, pkg: cfg.pkg, single: true, sources: append([]string{path}, l.inputs()...)}
+	p.command = joinArgs([]string{"compose", relPath(p.dir(), path)})
+	p.entries = []planEntry{{input: path, name: name, cfg: cfg, layout: &l}}
+	return p, nil
+}
+
+// composeAsset draws the screen of a layout and converts it like convertAsset
+// converts an image.
+func composeAsset(layoutPath string, l *layout, name string, cfg convertConfig, stdout io.Writer) (bitmap.Asset, error) {
+	canvas, err := l.render(cfg.options)
+	if err != nil {
+		return bitmap.Asset{}, err
+	}
+	bm, err := bitmap.Convert(canvas, cfg.options)
+	if err != nil {
+		return bitmap.Asset{}, convertError(layoutPath, err)
+	}
+	var mask *bitmap.Bitmap
+	if cfg.mask {
+		if mask, err = bitmap.ConvertMask(canvas, cfg.options, cfg.maskThreshold); err != nil {
+			return bitmap.Asset{}, convertError(layoutPath, err)
+		}
+	}
+	return packAsset(layoutPath, name, bm, mask, "", cfg, stdout)
+}
+
+// render draws the layers in order onto a transparent canvas of the bitmap
+// size, so the matte shows wherever no layer draws. When a quarter turn
+// applies to the source, the canvas is drawn in the orientation of the art.
+func (l *layout) render(opts bitmap.Options) (*image.RGBA, error) {
+	w, h := opts.Width, opts.Height
+	if opts.TransformStage == bitmap.TransformSource && (opts.Rotate == bitmap.Rotate90 || opts.Rotate == bitmap.Rotate270) {
+		w, h = h, w
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, w, h))
+	for _, layer := range l.Layers {
+		if err := layer.draw(canvas, opts.Scaler); err != nil {
+			return nil, err
+		}
+	}
+	return canvas, nil
+}
+
+// draw draws the layer onto dst, scaling images with scaler.
+func (l layoutLayer) draw(dst *image.RGBA, scaler bitmap.Scaler) error {
+	ink := image.NewUniform(color.RGBA(bitmap.Black))
+	if l.Color != nil {
+		ink = image.NewUniform(l.Color.RGBA())
+	}
+	thickness := max(l.Thickness, 1)
+
+	switch l.Type {
+	case "image":
+		src, err := readPNG(l.Input)
+		if err != nil {
+			return err
+		}
+		sb := src.Bounds()
+		w, h := l.Width, l.Height
+		switch {
+		case w == 0 && h == 0:
+			w, h = sb.Dx(), sb.Dy()
+		case w == 0:
+			w = (h*sb.Dx() + sb.Dy()/2) / max(sb.Dy(), 1)
+		case h == 0:
+			h = (w*sb.Dy() + sb.Dx()/2) / max(sb.Dx(), 1)
+		}
+		r := image.Rect(l.X, l.Y, l.X+w, l.Y+h)
+		if w == sb.Dx() && h == sb.Dy() {
+			draw.Draw(dst, r, src, sb.Min, draw.Over)
+		} else {
+			scaler.Interpolator().Scale(dst, r, src, sb, draw.Over, nil)
+		}
+	case "text":
+		mask, err := renderText(l.Text, l.Font, bitmap.TextOptions{
+			Size:        l.Size,
+			Hinting:     l.Hinting,
+			Align:       l.Align,
+			Width:       l.Width,
+			NoAntialias: l.NoAntialias,
+		})
+		if err != nil {
+			return err
+		}
+		r := mask.Bounds().Add(image.Pt(l.X, l.Y))
+		draw.DrawMask(dst, r, ink, image.Point{}, mask, image.Point{}, draw.Over)
+	case "rect":
+		r := image.Rect(l.X, l.Y, l.X+l.Width, l.Y+l.Height)
+		if l.Fill || 2*thickness >= min(l.Width, l.Height) {
+			draw.Draw(dst, r, ink, image.Point{}, draw.Over)
+			break
+		}
+		t := thickness
+		for _, side := range []image.Rectangle{
+			{r.Min, image.Pt(r.Max.X, r.Min.Y+t)},
+			{image.Pt(r.Min.X, r.Max.Y-t), r.Max},
+			{image.Pt(r.Min.X, r.Min.Y+t), image.Pt(r.Min.X+t, r.Max.Y-t)},
+			{image.Pt(r.Max.X-t, r.Min.Y+t), image.Pt(r.Max.X, r.Max.Y-t)},
+		} {
+			draw.Draw(dst, side, ink, image.Point{}, draw.Over)
+		}
+	case "line":
+		// Bresenham, stamping a square pen on every step so 1bpp lines stay crisp
+		dx, dy := abs(l.X2-l.X), -abs(l.Y2-l.Y)
+		sx, sy := sign(l.X2-l.X), sign(l.Y2-l.Y)
+		x, y, e := l.X, l.Y, dx+dy
+		for {
+			pen := image.Rect(x, y, x+thickness, y+thickness).Sub(image.Pt(thickness/2, thickness/2))
+			draw.Draw(dst, pen, ink, image.Point{}, draw.Over)
+			if x == l.X2 && y == l.Y2 {
+				break
+			}
+			if 2*e >= dy {
+				e += dy
+				x += sx
+			}
+			if 2*e <= dx {
+				e += dx
+				y += sy
+			}
+		}
+	}
+	return nil
+}
+
+// readPNG reads and decodes a PNG file.
+func readPNG(path string) (image.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &stageError{stage: "read", path: path, err: err}
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &stageError{stage: "decode", path: path, err: err}
+	}
+	return img, nil
+}
+
+// abs returns the absolute value of v.
+func abs(v int) int {
+	return max(v, -v)
+}
+
+// sign returns -1, 0 or 1 as v is negative, zero or positive.
+func sign(v int) int {
+	return min(max(v, -1), 1)
+}
+
+// composeCommand implements "image2bytes compose", which draws the screen a
+// layout describes and converts it. It returns the exit code like run.
+func composeCommand(args []string, stdout, stderr io.Writer) int {
+	c, code := parseComposeArgs(args, "", stderr)
+	if c == nil {
+		return code
+	}
+	if c.watch.enabled {
+		return c.watch.watch(c.plan, []string{c.layout}, stdout, stderr)
+	}
+	p, err := c.plan()
+	if err == nil {
+		err = p.build(stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// composeArgs is the parsed command line of compose.
+type composeArgs struct {
+	layout string
+	watch  watchOptions
+}
+
+// parseComposeArgs parses the flags and the layout argument of compose,
+// resolving a relative layout path against dir unless it is empty. Usage goes
+// to stderr; when there is nothing to compose it returns nil and the exit code.
+func parseComposeArgs(args []string, dir string, stderr io.Writer) (*composeArgs, int) {
+	fs := flag.NewFlagSet("compose", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go run . compose [flags] layout.yaml|layout.json|layout.toml")
+		fs.PrintDefaults()
+	}
+	c := &composeArgs{}
+	c.watch.register(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, exitOK
+		}
+		return nil, exitUsage
+	}
+	if fs.NArg() != 1 || fs.Arg(0) == "" {
+		fmt.Fprintln(stderr, "Usage: go run . compose layout.yaml|layout.json|layout.toml")
+		return nil, exitUsage
+	}
+	c.layout = fs.Arg(0)
+	if dir != "" {
+		c.layout = resolvePath(dir, c.layout)
+	}
+	return c, exitOK
+}
+
+// plan plans the conversion of the layout.
+func (c *composeArgs) plan() (*plan, error) {
+	return planLayout(c.layout)
+}
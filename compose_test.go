@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testLayout = `
+package: screens
+output: badge.go
+name: Badge
+width: 32
+height: 16
+layers:
+  - type: image
+    input: logo.png
+  - type: rect
+    x: 8
+    width: 4
+    height: 4
+    fill: true
+  - type: rect
+    x: 9
+    y: 1
+    width: 2
+    height: 2
+    fill: true
+    color: white
+  - type: line
+    y: 15
+    x2: 31
+    y2: 15
+  - type: text
+    text: Hi
+    x: 14
+`
+
+func TestComposeCommand(t *testing.T) {
+	dir := t.TempDir()
+	writeCheckerboardPNG(t, filepath.Join(dir, "logo.png"), 4, 4)
+	layoutPath := filepath.Join(dir, "badge.yaml")
+	if err := os.WriteFile(layoutPath, []byte(testLayout), 0o644); err != nil {
+		t.Fatalf("Failed to write layout: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"compose", layoutPath}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	outputPath := filepath.Join(dir, "badge.go")
+	typeCheck(t, outputPath)
+	bm, err := loadBitmap(outputPath, decodeOptions{name: "Badge"})
+	if err != nil {
+		t.Fatalf("loadBitmap failed: %v", err)
+	}
+	if bm.Width != 32 || bm.Height != 16 {
+		t.Fatalf("Expected a 32x16 screen, got %dx%d", bm.Width, bm.Height)
+	}
+
+	// Later layers cover earlier ones, and the matte shows where none draws
+	ink := func(x, y int) bool { return bm.Gray(x, y) < 0x80 }
+	for _, tt := range []struct {
+		x, y int
+		ink  bool
+	}{
+		{0, 0, true}, {1, 0, false}, {3, 3, true}, // the image
+		{8, 0, true}, {11, 3, true}, {9, 1, false}, // the rectangle, knocked out in white
+		{0, 15, true}, {31, 15, true}, // the line
+		{5, 8, false}, {31, 0, false}, // the matte
+	} {
+		if ink(tt.x, tt.y) != tt.ink {
+			t.Errorf("Pixel (%d,%d): expected ink %t", tt.x, tt.y, tt.ink)
+		}
+	}
+	text := 0
+	for y := 0; y < 13; y++ {
+		for x := 14; x < 28; x++ {
+			if ink(x, y) {
+				text++
+			}
+		}
+	}
+	if text == 0 {
+		t.Errorf("Expected the text to be drawn")
+	}
+
+	// The header records the layout and its images, so check sees them change
+	stdout.Reset()
+	if code := run([]string{"check", outputPath}, &stdout, &stderr); code != exitOK {
+		t.Errorf("Expected the screen to be up to date, got %s", stdout.String())
+	}
+	writeCheckerboardPNG(t, filepath.Join(dir, "logo.png"), 2, 2)
+	stdout.Reset()
+	if code := run([]string{"check", outputPath}, &stdout, &stderr); code != exitError || !bytes.Contains(stdout.Bytes(), []byte("logo.png changed")) {
+		t.Errorf("Expected the screen to be stale after its image changed, got %s", stdout.String())
+	}
+}
+
+func TestComposeCommandErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeCheckerboardPNG(t, filepath.Join(dir, "a.png"), 2, 2)
+
+	for name, content := range map[string]string{
+		"no-output.yaml":  "layers:\n  - type: text\n    text: A\n",
+		"no-layers.yaml":  "output: a.go\n",
+		"no-size.yaml":    "output: a.go\nwidth: 0\nlayers:\n  - type: text\n    text: A\n",
+		"type.yaml":       "output: a.go\nlayers:\n  - type: circle\n",
+		"text.yaml":       "output: a.go\nlayers:\n  - type: text\n",
+		"rect.yaml":       "output: a.go\nlayers:\n  - type: rect\n    width: 4\n",
+		"font-size.yaml":  "output: a.go\nlayers:\n  - type: text\n    text: A\n    size: -1\n",
+		"align.yaml":      "output: a.go\nlayers:\n  - type: text\n    text: A\n    align: justify\n",
+		"font.yaml":       "output: a.go\nlayers:\n  - type: text\n    text: A\n    font: a.png\n",
+		"missing.yaml":    "output: a.go\nlayers:\n  - type: image\n    input: b.png\n",
+		"typo.json":       `{"output": "a.go", "layers": [{"type": "line", "thicknes": 2}]}`,
+		"bad-option.toml": "output = \"a.go\"\ndither = \"sierra\"\n[[layers]]\ntype = \"line\"\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write layout: %v", err)
+		}
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"compose", path}, &stdout, &stderr); code != exitError {
+			t.Errorf("%s: expected exit code %d, got %d", name, exitError, code)
+		}
+	}
+}
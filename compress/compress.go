@@ -0,0 +1,139 @@
+// Package compress implements compression codecs for generated byte arrays that
+// microcontrollers can decode with little memory. Every codec has a streaming
+// decoder whose only state is a small history window, so firmware can decompress
+// one row at a time into a row-sized buffer.
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Codec is a compression scheme for generated byte arrays.
+type Codec interface {
+	// Name returns the name used on the command line and in generated files.
+	Name() string
+	// Params returns the parameters a decoder needs; unused ones are zero.
+	Params() Params
+	// Encode compresses src.
+	Encode(src []byte) []byte
+	// NewReader returns a reader that decompresses the data read from r.
+	NewReader(r io.Reader) io.Reader
+}
+
+// Params holds the tunables shared by the windowed codecs.
+type Params struct {
+	Window    int // log2 of the history window (heatshrink, lz4)
+	Lookahead int // log2 of the longest match (heatshrink)
+}
+
+// DefaultParams match heatshrink's defaults (-w 8 -l 4).
+var DefaultParams = Params{Window: 8, Lookahead: 4}
+
+// Names lists the codecs accepted by New, in the order Compress tries them for "auto".
+var Names = []string{"none", "heatshrink", "lz4", "lzss"}
+
+// New returns the codec with the given name.
+func New(name string, p Params) (Codec, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return noneCodec{}, nil
+	case "heatshrink":
+		return newHeatshrink(p)
+	case "lz4":
+		return newLZ4(p)
+	case "lzss":
+		return lzssCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown codec %q (want %s or auto)", name, strings.Join(Names, ", "))
+	}
+}
+
+// Result is the outcome of compressing a byte array with one codec.
+type Result struct {
+	Codec   Codec
+	Data    []byte
+	RawSize int // length of the data before compression
+}
+
+// Ratio returns the compressed size as a percentage of the raw size.
+func (r Result) Ratio() float64 {
+	if r.RawSize == 0 {
+		return 100
+	}
+	return 100 * float64(len(r.Data)) / float64(r.RawSize)
+}
+
+// Compress compresses data with the named codec. For "auto" every codec is
+// tried and the results are ordered smallest first; otherwise a single result is returned.
+func Compress(name string, p Params, data []byte) ([]Result, error) {
+	names := []string{name}
+	if strings.EqualFold(name, "auto") {
+		names = Names
+	}
+
+	results := make([]Result, 0, len(names))
+	for _, n := range names {
+		c, err := New(n, p)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, Result{Codec: c, Data: c.Encode(data), RawSize: len(data)})
+	}
+
+	// Keep Names order on ties, so auto prefers the simpler codec
+	sort.SliceStable(results, func(i, j int) bool {
+		return len(results[i].Data) < len(results[j].Data)
+	})
+
+	return results, nil
+}
+
+// Decompress reads exactly size bytes of data compressed with c.
+func Decompress(c Codec, data []byte, size int) ([]byte, error) {
+	out := make([]byte, size)
+	if _, err := io.ReadFull(c.NewReader(bytes.NewReader(data)), out); err != nil {
+		return nil, fmt.Errorf("decompressing %s data: %w", c.Name(), err)
+	}
+	return out, nil
+}
+
+// noneCodec stores data as is.
+type noneCodec struct{}
+
+func (noneCodec) Name() string                    { return "none" }
+func (noneCodec) Params() Params                  { return Params{} }
+func (noneCodec) Encode(src []byte) []byte        { return src }
+func (noneCodec) NewReader(r io.Reader) io.Reader { return r }
+
+// byteReader returns r as an io.ByteReader, wrapping it if needed.
+func byteReader(r io.Reader) io.ByteReader {
+	if br, ok := r.(io.ByteReader); ok {
+		return br
+	}
+	return &singleByteReader{r: r}
+}
+
+// singleByteReader reads one byte at a time without buffering ahead.
+type singleByteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (s *singleByteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(s.r, s.buf[:]); err != nil {
+		return 0, err
+	}
+	return s.buf[0], nil
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, for input that ends mid-token.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// compressTestInputs returns inputs that exercise literals, short and long
+// matches, and the end-of-stream handling of every codec.
+func compressTestInputs() map[string][]byte {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 3000)
+	rng.Read(random)
+
+	// Rows of a mostly white image with a few repeated shapes
+	image := make([]byte, 37*128)
+	for y := 40; y < 90; y++ {
+		for x := 10; x < 20; x++ {
+			image[y*37+x] = byte(0xF0 >> (y % 4))
+		}
+	}
+
+	return map[string][]byte{
+		"empty":    {},
+		"single":   {0xA5},
+		"short":    []byte("abcabcabcab"),
+		"zeros":    make([]byte, 1000),
+		"random":   random,
+		"image":    image,
+		"repeated": bytes.Repeat([]byte("0123456789abcdef!"), 500),
+	}
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	for _, name := range Names {
+		c, err := New(name, DefaultParams)
+		if err != nil {
+			t.Fatalf("New(%q) failed: %v", name, err)
+		}
+		for inputName, input := range compressTestInputs() {
+			t.Run(name+"/"+inputName, func(t *testing.T) {
+				encoded := c.Encode(input)
+				decoded, err := io.ReadAll(c.NewReader(bytes.NewReader(encoded)))
+				if err != nil {
+					t.Fatalf("decoding failed: %v", err)
+				}
+				if !bytes.Equal(decoded, input) {
+					t.Errorf("round trip mismatch: got %d bytes, want %d", len(decoded), len(input))
+				}
+			})
+		}
+	}
+}
+
+// TestCodecsStreamRows decodes one row at a time into a row-sized buffer, the way
+// firmware would.
+func TestCodecsStreamRows(t *testing.T) {
+	const rowBytes = 37
+	input := compressTestInputs()["image"]
+
+	for _, name := range Names {
+		for _, p := range []Params{DefaultParams, {Window: 4, Lookahead: 3}, {Window: 12, Lookahead: 6}} {
+			c, err := New(name, p)
+			if err != nil {
+				t.Fatalf("New(%q, %+v) failed: %v", name, p, err)
+			}
+			r := c.NewReader(bytes.NewReader(c.Encode(input)))
+			row := make([]byte, rowBytes)
+			for y := 0; y < len(input)/rowBytes; y++ {
+				if _, err := io.ReadFull(r, row); err != nil {
+					t.Fatalf("%s %+v: reading row %d failed: %v", name, p, y, err)
+				}
+				if !bytes.Equal(row, input[y*rowBytes:(y+1)*rowBytes]) {
+					t.Fatalf("%s %+v: row %d mismatch", name, p, y)
+				}
+			}
+			if n, err := r.Read(row); n != 0 || err != io.EOF {
+				t.Errorf("%s %+v: expected EOF after the last row, got %d bytes and %v", name, p, n, err)
+			}
+		}
+	}
+}
+
+func TestCompressDataAuto(t *testing.T) {
+	input := compressTestInputs()["image"]
+	results, err := Compress("auto", DefaultParams, input)
+	if err != nil {
+		t.Fatalf("compressData failed: %v", err)
+	}
+	if len(results) != len(Names) {
+		t.Fatalf("Expected %d results, got %d", len(Names), len(results))
+	}
+	for _, r := range results[1:] {
+		if len(r.Data) < len(results[0].Data) {
+			t.Errorf("%s (%d bytes) is smaller than the chosen %s (%d bytes)",
+				r.Codec.Name(), len(r.Data), results[0].Codec.Name(), len(results[0].Data))
+		}
+	}
+	if len(results[0].Data) >= len(input) {
+		t.Errorf("Expected a mostly white image to compress, got %d of %d bytes", len(results[0].Data), len(input))
+	}
+}
+
+func TestNewCodecErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec string
+		p     Params
+	}{
+		{name: "Unknown codec", codec: "zip", p: DefaultParams},
+		{name: "Heatshrink window too small", codec: "heatshrink", p: Params{Window: 3, Lookahead: 2}},
+		{name: "Heatshrink lookahead not below window", codec: "heatshrink", p: Params{Window: 8, Lookahead: 8}},
+		{name: "LZ4 window too large", codec: "lz4", p: Params{Window: 17, Lookahead: 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.codec, tt.p); err == nil {
+				t.Errorf("Expected an error for %q with %+v, got nil", tt.codec, tt.p)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package compress
+
+import (
+	"fmt"
+	"io"
+)
+
+// heatshrinkCodec implements the heatshrink bitstream: a 1 tag bit followed by an
+// 8-bit literal, or a 0 tag bit followed by a window-bit (offset-1) and a
+// lookahead-bit (count-1) back-reference. Bits are packed MSB-first and the final
+// byte is padded with zeros.
+type heatshrinkCodec struct {
+	window    uint
+	lookahead uint
+}
+
+// newHeatshrink validates p against the ranges heatshrink itself accepts.
+func newHeatshrink(p Params) (Codec, error) {
+	if p.Window < 4 || p.Window > 15 {
+		return nil, fmt.Errorf("heatshrink window must be between 4 and 15, got %d", p.Window)
+	}
+	if p.Lookahead < 3 || p.Lookahead >= p.Window {
+		return nil, fmt.Errorf("heatshrink lookahead must be between 3 and window-1, got %d", p.Lookahead)
+	}
+	return heatshrinkCodec{window: uint(p.Window), lookahead: uint(p.Lookahead)}, nil
+}
+
+func (h heatshrinkCodec) Name() string { return "heatshrink" }
+
+func (h heatshrinkCodec) Params() Params {
+	return Params{Window: int(h.window), Lookahead: int(h.lookahead)}
+}
+
+func (h heatshrinkCodec) Encode(src []byte) []byte {
+	windowSize := 1 << h.window
+	maxLen := 1 << h.lookahead
+	// A back-reference only pays off once it replaces more bits than it costs
+	minLen := int(1+h.window+h.lookahead)/9 + 1
+
+	var w bitWriter
+	for pos := 0; pos < len(src); {
+		bestLen, bestOff := 0, 0
+		for off := 1; off <= windowSize && off <= pos; off++ {
+			n := 0
+			for n < maxLen && pos+n < len(src) && src[pos+n] == src[pos+n-off] {
+				n++
+			}
+			if n > bestLen {
+				bestLen, bestOff = n, off
+				if n == maxLen {
+					break
+				}
+			}
+		}
+
+		if bestLen >= minLen {
+			w.writeBits(0, 1)
+			w.writeBits(uint32(bestOff-1), h.window)
+			w.writeBits(uint32(bestLen-1), h.lookahead)
+			pos += bestLen
+		} else {
+			w.writeBits(1, 1)
+			w.writeBits(uint32(src[pos]), 8)
+			pos++
+		}
+	}
+	return w.bytes()
+}
+
+func (h heatshrinkCodec) NewReader(r io.Reader) io.Reader {
+	return &heatshrinkReader{
+		codec: h,
+		bits:  bitReader{r: byteReader(r)},
+		ring:  make([]byte, 1<<h.window),
+	}
+}
+
+// heatshrinkReader decodes a heatshrink stream using a window-sized ring buffer.
+type heatshrinkReader struct {
+	codec heatshrinkCodec
+	bits  bitReader
+	ring  []byte
+	head  int
+	lit   byte // pending literal, when off is zero
+	off   int  // offset of the pending back-reference
+	count int  // bytes left to emit from the pending token
+	err   error
+}
+
+func (d *heatshrinkReader) Read(p []byte) (int, error) {
+	mask := len(d.ring) - 1
+	n := 0
+	for n < len(p) {
+		if d.count == 0 && !d.next() {
+			break
+		}
+
+		c := d.lit
+		if d.off > 0 {
+			c = d.ring[(d.head-d.off)&mask]
+		}
+		d.count--
+		d.ring[d.head&mask] = c
+		d.head++
+		p[n] = c
+		n++
+	}
+
+	if n == 0 && d.err != nil {
+		return 0, d.err
+	}
+	return n, nil
+}
+
+// next decodes the next token, returning false once the stream has ended. Input
+// that ends inside a token is the zero padding of the last byte, so it ends the
+// stream cleanly.
+func (d *heatshrinkReader) next() bool {
+	if d.err != nil {
+		return false
+	}
+	tag, err := d.bits.readBits(1)
+	if err != nil {
+		d.err = eofOr(err)
+		return false
+	}
+
+	if tag == 1 {
+		lit, err := d.bits.readBits(8)
+		if err != nil {
+			d.err = eofOr(err)
+			return false
+		}
+		d.lit, d.off, d.count = byte(lit), 0, 1
+		return true
+	}
+
+	off, err := d.bits.readBits(d.codec.window)
+	if err != nil {
+		d.err = eofOr(err)
+		return false
+	}
+	count, err := d.bits.readBits(d.codec.lookahead)
+	if err != nil {
+		d.err = eofOr(err)
+		return false
+	}
+	if int(off)+1 > d.head {
+		d.err = fmt.Errorf("heatshrink: back-reference before start of data")
+		return false
+	}
+	d.off, d.count = int(off)+1, int(count)+1
+	return true
+}
+
+// eofOr maps running out of input to io.EOF and passes other errors through.
+func eofOr(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return io.EOF
+	}
+	return err
+}
+
+// bitWriter packs bits MSB-first.
+type bitWriter struct {
+	buf   []byte
+	acc   uint8
+	nbits uint
+}
+
+func (w *bitWriter) writeBits(v uint32, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.acc = w.acc<<1 | uint8(v>>uint(i)&1)
+		w.nbits++
+		if w.nbits == 8 {
+			w.buf = append(w.buf, w.acc)
+			w.acc, w.nbits = 0, 0
+		}
+	}
+}
+
+// bytes flushes any partial byte, zero padded, and returns the packed bits.
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, w.acc<<(8-w.nbits))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.buf
+}
+
+// bitReader unpacks bits MSB-first.
+type bitReader struct {
+	r     io.ByteReader
+	cur   uint8
+	nbits uint
+}
+
+func (b *bitReader) readBits(n uint) (uint32, error) {
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		if b.nbits == 0 {
+			c, err := b.r.ReadByte()
+			if err != nil {
+				return 0, noEOF(err)
+			}
+			b.cur, b.nbits = c, 8
+		}
+		b.nbits--
+		v = v<<1 | uint32(b.cur>>b.nbits&1)
+	}
+	return v, nil
+}
@@ -0,0 +1,245 @@
+package compress
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// LZ4 block format constants. The end-of-block rules (last match starts at least
+// 12 bytes before the end, last 5 bytes are literals) keep the output readable by
+// any conforming LZ4 block decoder.
+const (
+	lz4MinMatch     = 4
+	lz4LastLiterals = 5
+	lz4MFLimit      = 12
+	lz4HashLog      = 14
+	lz4ChainDepth   = 64
+)
+
+// lz4Codec writes raw LZ4 blocks whose match distances never exceed maxDist, so a
+// streaming decoder only needs a window-sized history instead of the usual 64KB.
+type lz4Codec struct {
+	window  uint
+	maxDist int
+}
+
+// newLZ4 bounds the match distance by the window size.
+func newLZ4(p Params) (Codec, error) {
+	if p.Window < 4 || p.Window > 16 {
+		return nil, fmt.Errorf("lz4 window must be between 4 and 16, got %d", p.Window)
+	}
+	return lz4Codec{window: uint(p.Window), maxDist: min(1<<p.Window, 65535)}, nil
+}
+
+func (l lz4Codec) Name() string { return "lz4" }
+
+func (l lz4Codec) Params() Params { return Params{Window: int(l.window)} }
+
+func (l lz4Codec) Encode(src []byte) []byte {
+	var out []byte
+	head := make([]int32, 1<<lz4HashLog)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(src))
+
+	insert := func(pos int) {
+		h := lz4Hash(binary.LittleEndian.Uint32(src[pos:]))
+		prev[pos] = head[h]
+		head[h] = int32(pos)
+	}
+
+	matchLimit := len(src) - lz4LastLiterals
+	anchor := 0
+	for pos := 0; pos+lz4MFLimit <= len(src); {
+		key := binary.LittleEndian.Uint32(src[pos:])
+		bestLen, bestDist := 0, 0
+		cand := head[lz4Hash(key)]
+		for depth := 0; cand >= 0 && depth < lz4ChainDepth && pos-int(cand) <= l.maxDist; depth++ {
+			c := int(cand)
+			cand = prev[c]
+			if binary.LittleEndian.Uint32(src[c:]) != key {
+				continue
+			}
+			n := lz4MinMatch
+			for pos+n < matchLimit && src[c+n] == src[pos+n] {
+				n++
+			}
+			if n > bestLen {
+				bestLen, bestDist = n, pos-c
+			}
+		}
+		insert(pos)
+
+		if bestLen < lz4MinMatch {
+			pos++
+			continue
+		}
+
+		out = lz4Sequence(out, src[anchor:pos], bestDist, bestLen)
+		for i := pos + 1; i < pos+bestLen && i+lz4MinMatch <= len(src); i++ {
+			insert(i)
+		}
+		pos += bestLen
+		anchor = pos
+	}
+
+	// The last sequence holds the remaining literals and no match
+	return lz4Sequence(out, src[anchor:], 0, 0)
+}
+
+// lz4Hash maps four bytes to a hash table slot.
+func lz4Hash(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - lz4HashLog)
+}
+
+// lz4Sequence appends one sequence: a token, the literals and, when matchLen is
+// non-zero, the little-endian distance and match length.
+func lz4Sequence(out, literals []byte, dist, matchLen int) []byte {
+	ml := 0
+	if matchLen > 0 {
+		ml = matchLen - lz4MinMatch
+	}
+	out = append(out, byte(min(len(literals), 15)<<4|min(ml, 15)))
+	if len(literals) >= 15 {
+		out = lz4Length(out, len(literals)-15)
+	}
+	out = append(out, literals...)
+	if matchLen > 0 {
+		out = append(out, byte(dist), byte(dist>>8))
+		if ml >= 15 {
+			out = lz4Length(out, ml-15)
+		}
+	}
+	return out
+}
+
+// lz4Length appends the 255-run encoding of a length that overflowed its nibble.
+func lz4Length(out []byte, n int) []byte {
+	for n >= 255 {
+		out = append(out, 255)
+		n -= 255
+	}
+	return append(out, byte(n))
+}
+
+func (l lz4Codec) NewReader(r io.Reader) io.Reader {
+	return &lz4Reader{r: byteReader(r), ring: make([]byte, 1<<l.window)}
+}
+
+// lz4Reader decodes an LZ4 block using a window-sized ring buffer.
+type lz4Reader struct {
+	r        io.ByteReader
+	ring     []byte
+	head     int
+	literals int  // literals left to copy from the input
+	match    bool // a match follows the pending literals
+	matchLen int  // nibble of the pending match length
+	dist     int
+	count    int // bytes left to copy from the pending match
+	err      error
+}
+
+func (d *lz4Reader) Read(p []byte) (int, error) {
+	mask := len(d.ring) - 1
+	n := 0
+	for n < len(p) {
+		var c byte
+		switch {
+		case d.literals > 0:
+			b, err := d.r.ReadByte()
+			if err != nil {
+				d.err = noEOF(err)
+				return n, d.err
+			}
+			c = b
+			d.literals--
+		case d.count > 0:
+			c = d.ring[(d.head-d.dist)&mask]
+			d.count--
+		default:
+			if !d.next() {
+				if n == 0 {
+					return 0, d.err
+				}
+				return n, nil
+			}
+			continue
+		}
+		d.ring[d.head&mask] = c
+		d.head++
+		p[n] = c
+		n++
+	}
+	return n, nil
+}
+
+// next reads the next token or match header, returning false once the stream has
+// ended. The stream may only end right after a token's literals.
+func (d *lz4Reader) next() bool {
+	if d.err != nil {
+		return false
+	}
+
+	if d.match {
+		d.match = false
+		lo, err := d.r.ReadByte()
+		if err != nil {
+			// The last sequence has no match
+			d.err = err
+			return false
+		}
+		hi, err := d.r.ReadByte()
+		if err != nil {
+			d.err = noEOF(err)
+			return false
+		}
+		dist := int(lo) | int(hi)<<8
+		switch {
+		case dist == 0 || dist > d.head:
+			d.err = fmt.Errorf("lz4: invalid match distance %d", dist)
+			return false
+		case dist > len(d.ring):
+			d.err = fmt.Errorf("lz4: match distance %d exceeds the %d-byte window", dist, len(d.ring))
+			return false
+		}
+		ml, err := d.readLength(d.matchLen)
+		if err != nil {
+			d.err = err
+			return false
+		}
+		d.dist, d.count = dist, ml+lz4MinMatch
+		return true
+	}
+
+	token, err := d.r.ReadByte()
+	if err != nil {
+		d.err = err
+		return false
+	}
+	lits, err := d.readLength(int(token >> 4))
+	if err != nil {
+		d.err = err
+		return false
+	}
+	d.literals, d.match, d.matchLen = lits, true, int(token&0x0F)
+	return true
+}
+
+// readLength finishes a length whose nibble was n.
+func (d *lz4Reader) readLength(n int) (int, error) {
+	if n < 15 {
+		return n, nil
+	}
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		n += int(b)
+		if b != 255 {
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package compress
+
+import (
+	"fmt"
+	"io"
+)
+
+// LZSS in the classic Okumura layout: a 4096-byte window and matches of 3 to 18
+// bytes. Each flag byte describes the next eight items LSB-first, 1 for a literal
+// byte and 0 for a two-byte reference holding (distance-1) in 12 bits and
+// (length-3) in 4 bits.
+const (
+	lzssWindow = 4096
+	lzssMinLen = 3
+	lzssMaxLen = 18
+)
+
+type lzssCodec struct{}
+
+func (lzssCodec) Name() string   { return "lzss" }
+func (lzssCodec) Params() Params { return Params{} }
+
+func (lzssCodec) Encode(src []byte) []byte {
+	var out []byte
+	flagPos, flagBit := 0, 8
+	for pos := 0; pos < len(src); {
+		if flagBit == 8 {
+			flagPos, flagBit = len(out), 0
+			out = append(out, 0)
+		}
+
+		bestLen, bestDist := 0, 0
+		for dist := 1; dist <= lzssWindow && dist <= pos; dist++ {
+			n := 0
+			for n < lzssMaxLen && pos+n < len(src) && src[pos+n] == src[pos+n-dist] {
+				n++
+			}
+			if n > bestLen {
+				bestLen, bestDist = n, dist
+				if n == lzssMaxLen {
+					break
+				}
+			}
+		}
+
+		if bestLen >= lzssMinLen {
+			d := bestDist - 1
+			out = append(out, byte(d), byte(d>>8)<<4|byte(bestLen-lzssMinLen))
+			pos += bestLen
+		} else {
+			out[flagPos] |= 1 << flagBit
+			out = append(out, src[pos])
+			pos++
+		}
+		flagBit++
+	}
+	return out
+}
+
+func (lzssCodec) NewReader(r io.Reader) io.Reader {
+	return &lzssReader{r: byteReader(r), ring: make([]byte, lzssWindow)}
+}
+
+// lzssReader decodes an LZSS stream using a 4096-byte ring buffer.
+type lzssReader struct {
+	r       io.ByteReader
+	ring    []byte
+	head    int
+	flags   uint8
+	flagBit int // items left in the current flag byte
+	lit     byte
+	dist    int // distance of the pending reference, zero for a literal
+	count   int // bytes left to emit from the pending item
+	err     error
+}
+
+func (d *lzssReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if d.count == 0 && !d.next() {
+			break
+		}
+
+		c := d.lit
+		if d.dist > 0 {
+			c = d.ring[(d.head-d.dist)%lzssWindow]
+		}
+		d.count--
+		d.ring[d.head%lzssWindow] = c
+		d.head++
+		p[n] = c
+		n++
+	}
+
+	if n == 0 && d.err != nil {
+		return 0, d.err
+	}
+	return n, nil
+}
+
+// next decodes the next item, returning false once the stream has ended.
+func (d *lzssReader) next() bool {
+	if d.err != nil {
+		return false
+	}
+	if d.flagBit == 0 {
+		f, err := d.r.ReadByte()
+		if err != nil {
+			d.err = err
+			return false
+		}
+		d.flags, d.flagBit = f, 8
+	}
+
+	first, err := d.r.ReadByte()
+	if err != nil {
+		// Unused flag bits at the end of the stream
+		d.err = err
+		return false
+	}
+
+	literal := d.flags&1 == 1
+	d.flags >>= 1
+	d.flagBit--
+
+	if literal {
+		d.lit, d.dist, d.count = first, 0, 1
+		return true
+	}
+
+	second, err := d.r.ReadByte()
+	if err != nil {
+		d.err = noEOF(err)
+		return false
+	}
+	dist := (int(first) | int(second>>4)<<8) + 1
+	if dist > d.head {
+		d.err = fmt.Errorf("lzss: reference before start of data")
+		return false
+	}
+	d.dist, d.count = dist, int(second&0x0F)+lzssMinLen
+	return true
+}
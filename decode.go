@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"image2bytes/bitmap"
+	"image2bytes/compress"
+)
+
+// generatedArray is a byte array read back from a generated Go file, along with
+// the constants declared in the same file.
+type generatedArray struct {
+	name    string
+	data    []byte
+	ints    map[string]int
+	strings map[string]string
+}
+
+// parseGoArray parses a Go file written by generateGoFile and returns the []byte
+// variable called name, or the only one in the file when name is empty. The
+// array may be a composite literal or a converted string literal.
+func parseGoArray(path string, name string) (*generatedArray, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	ints := make(map[string]int)
+	strs := make(map[string]string)
+	arrays := make(map[string][]byte)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs, ok := spec.(*ast.ValueSpec)
+			if ok && len(vs.Values) == 0 && len(vs.Names) == 1 && isByteSlice(vs.Type) {
+				// NameData embedded from a sidecar file, as written with -data embed
+				embed, ok := embedFile(gen.Doc)
+				if !ok {
+					embed, ok = embedFile(vs.Doc)
+				}
+				if !ok {
+					continue
+				}
+				data, err := os.ReadFile(filepath.Join(filepath.Dir(path), embed))
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s: %w", fset.Position(vs.Pos()), vs.Names[0].Name, err)
+				}
+				arrays[strings.TrimSuffix(vs.Names[0].Name, "Data")] = data
+				continue
+			}
+			if !ok || len(vs.Names) != len(vs.Values) {
+				continue
+			}
+			for i, ident := range vs.Names {
+				switch v := vs.Values[i].(type) {
+				case *ast.BasicLit:
+					switch v.Kind {
+					case token.INT:
+						if n, err := strconv.ParseInt(v.Value, 0, 64); err == nil {
+							ints[ident.Name] = int(n)
+						}
+					case token.STRING:
+						if s, err := strconv.Unquote(v.Value); err == nil {
+							strs[ident.Name] = s
+						}
+					}
+				case *ast.CompositeLit:
+					if !isByteSlice(v.Type) {
+						continue
+					}
+					data, err := byteSliceValues(v)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %s: %w", fset.Position(v.Pos()), ident.Name, err)
+					}
+					arrays[ident.Name] = data
+				case *ast.CallExpr:
+					// []byte("...") as written with -data string
+					if !isByteSlice(v.Fun) || len(v.Args) != 1 {
+						continue
+					}
+					lit, ok := v.Args[0].(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						continue
+					}
+					s, err := strconv.Unquote(lit.Value)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %s: %w", fset.Position(v.Pos()), ident.Name, err)
+					}
+					arrays[ident.Name] = []byte(s)
+				}
+			}
+		}
+	}
+
+	if name == "" {
+		if len(arrays) != 1 {
+			names := make([]string, 0, len(arrays))
+			for n := range arrays {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("%s declares %d byte arrays %v, choose one with -name", path, len(arrays), names)
+		}
+		for n := range arrays {
+			name = n
+		}
+	}
+	data, ok := arrays[name]
+	if !ok {
+		return nil, fmt.Errorf("%s does not declare a byte array named %s", path, name)
+	}
+
+	return &generatedArray{name: name, data: data, ints: ints, strings: strs}, nil
+}
+
+// embedFile returns the single file a go:embed directive in doc names.
+func embedFile(doc *ast.CommentGroup) (string, bool) {
+	if doc == nil {
+		return "", false
+	}
+	for _, c := range doc.List {
+		if rest, ok := strings.CutPrefix(c.Text, "//go:embed "); ok {
+			if name := strings.TrimSpace(rest); name != "" && !strings.ContainsAny(name, " \t\"`*?[") {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// isByteSlice reports whether expr is the type []byte or []uint8.
+func isByteSlice(expr ast.Expr) bool {
+	arr, ok := expr.(*ast.ArrayType)
+	if !ok || arr.Len != nil {
+		return false
+	}
+	elt, ok := arr.Elt.(*ast.Ident)
+	return ok && (elt.Name == "byte" || elt.Name == "uint8")
+}
+
+// byteSliceValues returns the integer literals of a []byte composite literal.
+func byteSliceValues(lit *ast.CompositeLit) ([]byte, error) {
+	data := make([]byte, 0, len(lit.Elts))
+	for _, elt := range lit.Elts {
+		bl, ok := elt.(*ast.BasicLit)
+		if !ok || bl.Kind != token.INT {
+			return nil, fmt.Errorf("element %T is not an integer literal", elt)
+		}
+		b, err := strconv.ParseUint(bl.Value, 0, 8)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, byte(b))
+	}
+	return data, nil
+}
+
+// decodeOptions override the constants of a generated Go file, or describe a raw
+// .bin file that has none. Zero values are unset.
+type decodeOptions struct {
+	name      string
+	width     int
+	height    int
+	format    string
+	bitOrder  string
+	polarity  string
+	codec     string
+	window    int
+	lookahead int
+}
+
+// loadBitmap reads a generated Go file or a raw .bin file into a Bitmap.
+func loadBitmap(inputPath string, opts decodeOptions) (*bitmap.Bitmap, error) {
+	// Read the data, along with the constants of a generated Go file
+	var data []byte
+	ints := map[string]int{}
+	strs := map[string]string{}
+	if isGoFile(inputPath) {
+		arr, err := parseGoArray(inputPath, opts.name)
+		if err != nil {
+			return nil, err
+		}
+		data = arr.data
+		for _, suffix := range []string{"Width", "Height", "Size", "Window", "Lookahead"} {
+			if v, ok := arr.ints[arr.name+suffix]; ok {
+				ints[suffix] = v
+			}
+		}
+		for _, suffix := range []string{"Format", "BitOrder", "Polarity", "Codec"} {
+			if v, ok := arr.strings[arr.name+suffix]; ok {
+				strs[suffix] = v
+			}
+		}
+	} else {
+		raw, err := os.ReadFile(inputPath)
+		if err != nil {
+			return nil, err
+		}
+		data = raw
+	}
+
+	// Options override the generated constants
+	for key, v := range map[string]int{"Width": opts.width, "Height": opts.height, "Window": opts.window, "Lookahead": opts.lookahead} {
+		if v != 0 {
+			ints[key] = v
+		}
+	}
+	for key, v := range map[string]string{"Format": opts.format, "BitOrder": opts.bitOrder, "Polarity": opts.polarity, "Codec": opts.codec} {
+		if v != "" {
+			strs[key] = v
+		}
+	}
+
+	width, height := ints["Width"], ints["Height"]
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("%s does not record the image size, set -width and -height", filepath.Base(inputPath))
+	}
+	// Files without the constants hold MSB-first mono pixels in the polarity of their format
+	var format bitmap.PixelFormat
+	var order bitmap.BitOrder
+	var polarity bitmap.Polarity
+	if v, ok := strs["Format"]; ok {
+		if err := format.UnmarshalText([]byte(v)); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := strs["BitOrder"]; ok {
+		if err := order.UnmarshalText([]byte(v)); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := strs["Polarity"]; ok {
+		if err := polarity.UnmarshalText([]byte(v)); err != nil {
+			return nil, err
+		}
+	}
+	size := format.Stride(width) * height
+	if v, ok := ints["Size"]; ok && v != size {
+		return nil, fmt.Errorf("%s records %d bytes, but a %dx%d image packs into %d", filepath.Base(inputPath), v, width, height, size)
+	}
+
+	// Undo the compression
+	if codecName := strs["Codec"]; codecName != "" && !strings.EqualFold(codecName, "none") {
+		p := compress.DefaultParams
+		if v, ok := ints["Window"]; ok {
+			p.Window = v
+		}
+		if v, ok := ints["Lookahead"]; ok {
+			p.Lookahead = v
+		}
+		c, err := compress.New(codecName, p)
+		if err != nil {
+			return nil, err
+		}
+		data, err = compress.Decompress(c, data, size)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bm, err := bitmap.New(width, height, format, order, data)
+	if err != nil {
+		return nil, err
+	}
+	bm.Polarity = polarity
+	return bm, nil
+}
+
+// decodeCommand implements "image2bytes decode", which turns a generated Go file
+// or a raw .bin file back into a PNG. It returns the exit code like run.
+func decodeCommand(args []string, stdout, stderr io.Writer) int {
+	var opts decodeOptions
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go run . decode [flags] input.go|input.bin output.png")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.name, "name", "", "byte array to decode when the Go file declares several")
+	fs.IntVar(&opts.width, "width", 0, "image width in pixels (default: the generated Width constant)")
+	fs.IntVar(&opts.height, "height", 0, "image height in pixels (default: the generated Height constant)")
+	fs.StringVar(&opts.format, "format", "", "pixel format of the data (default: the generated Format constant, or mono)")
+	fs.StringVar(&opts.bitOrder, "bit-order", "", "order of pixels within a byte (default: the generated BitOrder constant, or msb)")
+	fs.StringVar(&opts.polarity, "polarity", "", "what the highest pixel value shows, black or white (default: the generated Polarity constant, or that of the format)")
+	fs.StringVar(&opts.codec, "codec", "", "codec the data is compressed with (default: the generated Codec constant, or none)")
+	fs.IntVar(&opts.window, "window", 0, "log2 of the compression window (default: the generated Window constant)")
+	fs.IntVar(&opts.lookahead, "lookahead", 0, "log2 of the heatshrink lookahead (default: the generated Lookahead constant)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(stderr, "Usage: go run . decode [flags] input.go|input.bin output.png")
+		return exitUsage
+	}
+	inputPath, outputPath := fs.Arg(0), fs.Arg(1)
+
+	// Validate that outputPath is a PNG file
+	if !isPNGFile(outputPath) {
+		fmt.Fprintln(stderr, "Error: Output file must be a PNG file (with .png extension)")
+		return exitUsage
+	}
+
+	// Read the data
+	bm, err := loadBitmap(inputPath, opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", &stageError{stage: "read", path: inputPath, err: err})
+		return exitError
+	}
+
+	fmt.Fprintf(stdout, "Image dimensions: %dx%d\n", bm.Width, bm.Height)
+
+	// Write the PNG file
+	if err := generatePNGFile(outputPath, bm.Image()); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", &stageError{stage: "write", path: outputPath, err: err})
+		return exitError
+	}
+
+	fmt.Fprintf(stdout, "Done. Image written to %s\n", outputPath)
+	return exitOK
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"image2bytes/bitmap"
+	"image2bytes/compress"
+)
+
+// checkerboard creates an image with black pixels on even squares
+func checkerboard(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (x+y)%2 == 0 {
+				img.Set(x, y, color.Black)
+			} else {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+	return img
+}
+
+// TestDecodeRoundTrip checks that a converted image written by generateGoFile,
+// read back by loadBitmap, is unchanged for every codec, pixel layout and data
+// style.
+func TestDecodeRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for _, opts := range []bitmap.Options{
+		{},
+		{Format: bitmap.Gray2, BitOrder: bitmap.LSBFirst},
+		{Format: bitmap.RGB565},
+		{Polarity: bitmap.HighWhite},
+	} {
+		// A checkerboard with an odd width exercises the row padding
+		bm, err := bitmap.Convert(checkerboard(13, 7), opts)
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+
+		kind := opts.Format.String() + "-" + bm.Polarity.String()
+		for _, name := range compress.Names {
+			for _, data := range []bitmap.DataStyle{bitmap.DataBytes, bitmap.DataString, bitmap.DataEmbed} {
+				t.Run(kind+"/"+name+"/"+data.String(), func(t *testing.T) {
+					results, err := compress.Compress(name, compress.DefaultParams, bm.Data)
+					if err != nil {
+						t.Fatalf("Compress failed: %v", err)
+					}
+					outputPath := filepath.Join(tempDir, kind+name+data.String()+".go")
+					err = generateGoFile(outputPath, genHeader{}, bm, bitmap.GoOptions{Name: "Test", Compression: &results[0], Data: data})
+					if err != nil {
+						t.Fatalf("generateGoFile failed: %v", err)
+					}
+
+					got, err := loadBitmap(outputPath, decodeOptions{})
+					if err != nil {
+						t.Fatalf("loadBitmap failed: %v", err)
+					}
+					if got.Width != bm.Width || got.Height != bm.Height || got.Format != bm.Format || got.BitOrder != bm.BitOrder || got.Polarity != bm.Polarity {
+						t.Fatalf("Expected %+v, got %+v", bm, got)
+					}
+					if !bytes.Equal(got.Data, bm.Data) {
+						t.Errorf("Expected % X, got % X", bm.Data, got.Data)
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestDecodeRawBin decodes a raw .bin file, which needs the size from options
+func TestDecodeRawBin(t *testing.T) {
+	binPath := filepath.Join(t.TempDir(), "raw.bin")
+	if err := os.WriteFile(binPath, []byte{0xA0, 0x50}, 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	if _, err := loadBitmap(binPath, decodeOptions{}); err == nil {
+		t.Errorf("Expected an error without -width and -height, got nil")
+	}
+
+	bm, err := loadBitmap(binPath, decodeOptions{width: 4, height: 2})
+	if err != nil {
+		t.Fatalf("loadBitmap failed: %v", err)
+	}
+	if bm.Value(0, 0) != 1 || bm.Value(1, 0) != 0 || bm.Value(1, 1) != 1 {
+		t.Errorf("Unexpected pixels: % X", bm.Data)
+	}
+
+	// The same bytes read as 2bpp gray, least significant pixel first
+	bm, err = loadBitmap(binPath, decodeOptions{width: 4, height: 2, format: "gray2", bitOrder: "lsb"})
+	if err != nil {
+		t.Fatalf("loadBitmap failed: %v", err)
+	}
+	if bm.Value(2, 0) != 2 || bm.Value(3, 0) != 2 || bm.Value(2, 1) != 1 {
+		t.Errorf("Unexpected pixels: % X", bm.Data)
+	}
+}
+
+func TestParseGoArraySeveralArrays(t *testing.T) {
+	goPath := filepath.Join(t.TempDir(), "assets.go")
+	src := "package main\n\nconst LogoWidth = 8\n\nvar Logo = []byte{0x01, 0x02}\nvar Icon = []byte{0x03}\n"
+	if err := os.WriteFile(goPath, []byte(src), 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	if _, err := parseGoArray(goPath, ""); err == nil {
+		t.Errorf("Expected an error when the file declares several arrays, got nil")
+	}
+
+	arr, err := parseGoArray(goPath, "Logo")
+	if err != nil {
+		t.Fatalf("parseGoArray failed: %v", err)
+	}
+	if len(arr.data) != 2 || arr.data[1] != 0x02 || arr.ints["LogoWidth"] != 8 {
+		t.Errorf("Unexpected array %+v", arr)
+	}
+}
+
+// TestDecodeCommandErrors checks the exit codes of the decode subcommand
+func TestDecodeCommandErrors(t *testing.T) {
+	missingPath := filepath.Join(t.TempDir(), "missing.go")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"decode", "output.go"}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d without an output file, got %d", exitUsage, code)
+	}
+
+	stderr.Reset()
+	if code := run([]string{"decode", missingPath, "out.png"}, &stdout, &stderr); code != exitError {
+		t.Errorf("Expected exit code %d for a missing input, got %d", exitError, code)
+	}
+	if !strings.HasPrefix(stderr.String(), "Error: read "+missingPath+": ") {
+		t.Errorf("Expected a read error, got %q", stderr.String())
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"image2bytes/bitmap"
+)
+
+// Exit codes of the command
+const (
+	exitOK    = 0
+	exitError = 1 // a file could not be read, converted or written
+	exitUsage = 2 // bad flags or arguments
+)
+
+// stageError records which stage of the conversion failed and on which file.
+// The stages are read, decode, resize, pack, compress and write.
+type stageError struct {
+	stage string
+	path  string
+	err   error
+}
+
+func (e *stageError) Error() string {
+	// os errors repeat the path, so keep only their cause
+	err := e.err
+	var pe *fs.PathError
+	if errors.As(err, &pe) && pe.Path == e.path {
+		err = pe.Err
+	}
+	return fmt.Sprintf("%s %s: %v", e.stage, e.path, err)
+}
+
+func (e *stageError) Unwrap() error { return e.err }
+
+// convertError wraps an error from bitmap.Convert, taking its stage from the
+// error when it has one.
+func convertError(path string, err error) error {
+	var ce *bitmap.ConvertError
+	if errors.As(err, &ce) {
+		return &stageError{stage: ce.Stage, path: path, err: ce.Err}
+	}
+	return &stageError{stage: "pack", path: path, err: err}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"image2bytes/bitmap"
+)
+
+// Panel resolution of the Badger 2040W, the default conversion size.
+const panelWidth, panelHeight = 296, 128
+
+// registerOptionFlags adds the conversion flags to fs, storing them in opts.
+// Flags left unset keep the values opts already holds.
+func registerOptionFlags(fs *flag.FlagSet, opts *bitmap.Options) {
+	fs.IntVar(&opts.Width, "width", opts.Width, "width of the bitmap in pixels (0 follows the source aspect ratio)")
+	fs.IntVar(&opts.Height, "height", opts.Height, "height of the bitmap in pixels (0 follows the source aspect ratio)")
+	fs.TextVar(&opts.Resize, "resize", opts.Resize, "how the source fits the size: stretch, fit, fill or none")
+	fs.TextVar(&opts.Scaler, "scaler", opts.Scaler, "resize interpolation: bilinear, nearest or catmull-rom")
+	fs.TextVar(&opts.Margins, "margins", opts.Margins, "borders of the bitmap to keep clear, in pixels: N, V,H or T,R,B,L")
+	fs.IntVar(&opts.ImageWidth, "image-width", opts.ImageWidth, "width the source is fitted to inside the margins (0 fills them, or follows the aspect ratio)")
+	fs.IntVar(&opts.ImageHeight, "image-height", opts.ImageHeight, "height the source is fitted to inside the margins (0 fills them, or follows the aspect ratio)")
+	fs.TextVar(&opts.Gravity, "gravity", opts.Gravity, "where the image sits inside the margins: center, top-left, top, top-right, left, right, bottom-left, bottom or bottom-right")
+	fs.TextVar(&opts.Offset, "offset", opts.Offset, "move the image by X,Y pixels from where -gravity puts it")
+	fs.TextVar(&opts.Crop, "crop", opts.Crop, "part of the source to convert, as WxH+X+Y or WxH (default the whole source)")
+	fs.TextVar(&opts.Rotate, "rotate", opts.Rotate, "turn the image clockwise by 0, 90, 180 or 270 degrees")
+	fs.TextVar(&opts.Flip, "flip", opts.Flip, "mirror the image after turning it: none, horizontal or vertical")
+	fs.TextVar(&opts.TransformStage, "transform-stage", opts.TransformStage, "when -rotate and -flip apply: to the source before resizing, or to the output pixels")
+	fs.Func("threshold", "luminance 1-255 below which a pixel is black in mono without dithering (default 128)", levelFlag(&opts.Threshold))
+	fs.TextVar(&opts.Dither, "dither", opts.Dither, "dithering: none, floyd-steinberg, atkinson or ordered")
+	fs.TextVar(&opts.Format, "format", opts.Format, "pixel format: mono, gray2, gray4, gray8, rgb565 or rgb888")
+	fs.TextVar(&opts.BitOrder, "bit-order", opts.BitOrder, "order of pixels within a byte: msb or lsb")
+	fs.TextVar(&opts.Polarity, "polarity", opts.Polarity, "what the highest pixel value shows: auto (black in mono, white in the others), black or white")
+	fs.BoolVar(&opts.Invert, "invert", opts.Invert, "invert every pixel")
+	fs.TextVar(&opts.Matte, "matte", opts.Matte, "color transparent pixels and padding are drawn on: #RRGGBB, #RGB, white or black")
+	fs.TextVar(&opts.Alpha, "alpha", opts.Alpha, "what transparency means: matte, or threshold to take opacity as ink (mono and gray)")
+	fs.TextVar(&opts.Luma, "luma", opts.Luma, "how colors become gray: bt601, bt709, linear, average, or the red, green, blue, max or min channel")
+	fs.Float64Var(&opts.AutoLevels, "auto-levels", opts.AutoLevels, "stretch the tones, clipping this `percent` of the pixels to black and as many to white")
+	fs.TextVar(&opts.Equalize, "equalize", opts.Equalize, "spread the tones evenly: none, histogram, or clahe region by region")
+	opts.Gamma = cmp.Or(opts.Gamma, 1)
+	fs.Func("gamma", "brighten the midtones above 1, darken them between 0 and 1 (default 1)", positiveFlag(&opts.Gamma))
+	fs.Float64Var(&opts.Brightness, "brightness", opts.Brightness, "add -1 to 1 to the linear luminance")
+	fs.Float64Var(&opts.Contrast, "contrast", opts.Contrast, "push tones from middle gray (0 to 1) or pull them toward it (-1 to 0)")
+	fs.Float64Var(&opts.Sharpen, "sharpen", opts.Sharpen, "unsharp mask `amount`, 0 (off) to 5, to keep downscaled text and lines crisp")
+	fs.Float64Var(&opts.SharpenRadius, "sharpen-radius", cmp.Or(opts.SharpenRadius, 1), "radius in pixels of the unsharp mask")
+	fs.TextVar(&opts.Edges, "edges", opts.Edges, "draw only the outlines: none, sobel (gradient strength) or canny (thin lines)")
+	fs.Float64Var(&opts.EdgeRadius, "edge-radius", cmp.Or(opts.EdgeRadius, 1), "radius in pixels of the smoothing before canny edge detection")
+	fs.TextVar(&opts.Morphology, "morphology", opts.Morphology, "after quantizing, thicken (dilate) or thin (erode) dark strokes, or none")
+	fs.IntVar(&opts.MorphologyRadius, "morphology-radius", cmp.Or(opts.MorphologyRadius, 1), "pixels -morphology thickens or thins strokes by")
+}
+
+// profileNames returns the names of the display profiles in order.
+func profileNames() []string {
+	names := make([]string, 0, len(bitmap.Profiles))
+	for name := range bitmap.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupProfile returns the display profile called name.
+func lookupProfile(name string) (bitmap.Profile, error) {
+	profile, ok := bitmap.Profiles[strings.ToLower(name)]
+	if !ok {
+		return bitmap.Profile{}, fmt.Errorf("unknown profile %q (want %s)", name, strings.Join(profileNames(), ", "))
+	}
+	return profile, nil
+}
+
+// applyProfile applies the display profile called name to opts and the panel
+// preview, except for the flags set on the command line, which win.
+func applyProfile(fs *flag.FlagSet, name string, opts *bitmap.Options, preview *panelPreviewOptions) error {
+	profile, err := lookupProfile(name)
+	if err != nil {
+		return err
+	}
+	given, panel := *opts, profile.Panel
+	profile.Apply(opts)
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "width":
+			opts.Width = given.Width
+		case "height":
+			opts.Height = given.Height
+		case "format":
+			opts.Format = given.Format
+		case "bit-order":
+			opts.BitOrder = given.BitOrder
+		case "polarity":
+			opts.Polarity = given.Polarity
+		case "preview-panel":
+			panel = preview.panel
+		}
+	})
+	preview.panel = panel
+	return nil
+}
+
+// levelFlag returns a flag function storing a level between 1 and 255 in dst,
+// where zero is left to mean the default.
+func levelFlag(dst *uint8) func(string) error {
+	return func(s string) error {
+		v, err := strconv.ParseUint(s, 10, 8)
+		if err != nil || v == 0 {
+			return fmt.Errorf("want a number between 1 and 255")
+		}
+		*dst = uint8(v)
+		return nil
+	}
+}
+
+// positiveFlag returns a flag function storing a finite number above zero in dst.
+func positiveFlag(dst *float64) func(string) error {
+	return func(s string) error {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || !(v > 0) || math.IsInf(v, 0) {
+			return fmt.Errorf("want a number above 0")
+		}
+		*dst = v
+		return nil
+	}
+}
+
+// optionArgs returns the flags that reproduce the generated code of cfg, in a
+// fixed order and with every value spelled out, so equal options compare equal.
+// The size, scaling, quantization, package and codec flags always appear. The
+// geometry, color, tone and filter flags, the mask and the data layout only
+// appear when they differ from their defaults, so a header that never set them
+// keeps matching its directive.
+func optionArgs(cfg convertConfig) []string {
+	args := append(bitmapOptionArgs(cfg.options), "-package="+cfg.pkg, "-codec="+cfg.codec)
+	if cfg.codec != "none" {
+		args = append(args, fmt.Sprintf("-window=%d", cfg.params.Window), fmt.Sprintf("-lookahead=%d", cfg.params.Lookahead))
+	}
+	if cfg.mask {
+		args = append(args, "-mask", fmt.Sprintf("-mask-threshold=%d", cmp.Or(cfg.maskThreshold, 128)))
+	}
+	if cfg.data != bitmap.DataBytes {
+		args = append(args, "-data="+cfg.data.String())
+	}
+	return args
+}
+
+// bitmapOptionArgs returns the conversion flags of opts like optionArgs.
+func bitmapOptionArgs(o bitmap.Options) []string {
+	threshold := int(o.Threshold)
+	if threshold == 0 {
+		threshold = 128
+	}
+	args := []string{
+		fmt.Sprintf("-width=%d", o.Width),
+		fmt.Sprintf("-height=%d", o.Height),
+		"-resize=" + o.Resize.String(),
+		"-scaler=" + o.Scaler.String(),
+		fmt.Sprintf("-threshold=%d", threshold),
+		"-dither=" + o.Dither.String(),
+		"-format=" + o.Format.String(),
+		"-bit-order=" + o.BitOrder.String(),
+		fmt.Sprintf("-invert=%t", o.Invert),
+	}
+	if o.Crop != (bitmap.Rect{}) {
+		args = append(args, "-crop="+o.Crop.String())
+	}
+	if o.Rotate != bitmap.Rotate0 {
+		args = append(args, "-rotate="+o.Rotate.String())
+	}
+	if o.Flip != bitmap.NoFlip {
+		args = append(args, "-flip="+o.Flip.String())
+	}
+	if o.TransformStage != bitmap.TransformSource {
+		args = append(args, "-transform-stage="+o.TransformStage.String())
+	}
+	if o.Margins != (bitmap.Margins{}) {
+		args = append(args, "-margins="+o.Margins.String())
+	}
+	if o.ImageWidth != 0 || o.ImageHeight != 0 {
+		args = append(args, fmt.Sprintf("-image-width=%d", o.ImageWidth), fmt.Sprintf("-image-height=%d", o.ImageHeight))
+	}
+	if o.Gravity != bitmap.Center {
+		args = append(args, "-gravity="+o.Gravity.String())
+	}
+	if o.Offset != (bitmap.Point{}) {
+		args = append(args, "-offset="+o.Offset.String())
+	}
+	if p := o.Polarity; p != bitmap.AutoPolarity && p != o.Format.Polarity() {
+		args = append(args, "-polarity="+p.String())
+	}
+	if o.Matte.RGBA() != color.RGBA(bitmap.White) {
+		args = append(args, "-matte="+o.Matte.String())
+	}
+	if o.Alpha != bitmap.AlphaMatte {
+		args = append(args, "-alpha="+o.Alpha.String())
+	}
+	if o.Luma != bitmap.BT601 {
+		args = append(args, "-luma="+o.Luma.String())
+	}
+	float := func(name string, v, def float64) {
+		if v != def {
+			args = append(args, "-"+name+"="+strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+	float("auto-levels", o.AutoLevels, 0)
+	if o.Equalize != bitmap.NoEqualize {
+		args = append(args, "-equalize="+o.Equalize.String())
+	}
+	float("gamma", cmp.Or(o.Gamma, 1), 1)
+	float("brightness", o.Brightness, 0)
+	float("contrast", o.Contrast, 0)
+	float("sharpen", o.Sharpen, 0)
+	float("sharpen-radius", cmp.Or(o.SharpenRadius, 1), 1)
+	if o.Edges != bitmap.NoEdges {
+		args = append(args, "-edges="+o.Edges.String())
+	}
+	float("edge-radius", cmp.Or(o.EdgeRadius, 1), 1)
+	if o.Morphology != bitmap.NoMorphology {
+		args = append(args, "-morphology="+o.Morphology.String())
+	}
+	if r := cmp.Or(o.MorphologyRadius, 1); r != 1 {
+		args = append(args, fmt.Sprintf("-morphology-radius=%d", r))
+	}
+	return args
+}
+
+// watchOptions control the -watch mode.
+type watchOptions struct {
+	enabled  bool
+	interval time.Duration
+}
+
+// register adds the -watch flags to fs.
+func (o *watchOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.enabled, "watch", false, "keep running and regenerate the output whenever the inputs change")
+	fs.DurationVar(&o.interval, "watch-interval", 300*time.Millisecond, "how often -watch polls the inputs; changes are built after one quiet interval")
+}
+
+// watch runs a watcher for the plan until the process is interrupted.
+func (o *watchOptions) watch(planFunc func() (*plan, error), roots []string, stdout, stderr io.Writer) int {
+	if o.interval <= 0 {
+		fmt.Fprintf(stderr, "Error: watch interval must be positive, got %v\n", o.interval)
+		return exitUsage
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	newWatcher(planFunc, roots, o.interval, stdout, stderr).run(ctx)
+	return exitOK
+}
+
+// previewFlag is the --preview flag. It can be given on its own for half-blocks,
+// or as --preview=mode.
+type previewFlag struct {
+	mode string
+}
+
+func (p *previewFlag) String() string { return p.mode }
+
+func (p *previewFlag) Set(s string) error {
+	switch s = strings.ToLower(s); s {
+	case "true":
+		p.mode = "halfblock"
+	case "false":
+		p.mode = ""
+	default:
+		for _, m := range bitmap.PreviewModes {
+			if s == m {
+				p.mode = s
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown preview mode %q (want %s)", s, strings.Join(bitmap.PreviewModes, ", "))
+	}
+	return nil
+}
+
+func (p *previewFlag) IsBoolFlag() bool { return true }
+
+// panelPreviewOptions control the simulated panel image written next to the output.
+type panelPreviewOptions struct {
+	enabled bool
+	scale   int
+	panel   string
+	paper   string
+	ink     string
+	grid    bool
+}
+
+// register adds the -preview-png flags to fs.
+func (o *panelPreviewOptions) register(fs *flag.FlagSet) {
+	panels := make([]string, 0, len(bitmap.Panels))
+	for name := range bitmap.Panels {
+		panels = append(panels, name)
+	}
+	sort.Strings(panels)
+
+	fs.BoolVar(&o.enabled, "preview-png", false, "also write a .preview.png next to the output showing how the panel will look")
+	fs.IntVar(&o.scale, "preview-scale", 4, "integer upscaling factor of the preview PNG")
+	fs.StringVar(&o.panel, "preview-panel", "eink", "paper and ink colors of the preview PNG: "+strings.Join(panels, ", "))
+	fs.StringVar(&o.paper, "preview-paper", "", "paper color of the preview PNG as #RRGGBB, overriding -preview-panel")
+	fs.StringVar(&o.ink, "preview-ink", "", "ink color of the preview PNG as #RRGGBB, overriding -preview-panel")
+	fs.BoolVar(&o.grid, "preview-grid", false, "draw pixel-grid lines on the preview PNG")
+}
+
+// resolve checks the options and turns the preset and any overrides into the panel colors.
+func (o *panelPreviewOptions) resolve() (bitmap.Panel, error) {
+	if o.scale < 1 {
+		return bitmap.Panel{}, fmt.Errorf("preview scale must be at least 1, got %d", o.scale)
+	}
+	panel, ok := bitmap.Panels[strings.ToLower(o.panel)]
+	if !ok {
+		return bitmap.Panel{}, fmt.Errorf("unknown preview panel %q", o.panel)
+	}
+	var err error
+	if o.paper != "" {
+		if panel.Paper, err = parseHexColor(o.paper); err != nil {
+			return bitmap.Panel{}, err
+		}
+	}
+	if o.ink != "" {
+		if panel.Ink, err = parseHexColor(o.ink); err != nil {
+			return bitmap.Panel{}, err
+		}
+	}
+	return panel, nil
+}
+
+// parseHexColor parses an opaque color written as #RRGGBB or RRGGBB.
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, want #RRGGBB", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xFF}, nil
+}
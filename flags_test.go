@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"image2bytes/bitmap"
+)
+
+func TestRegisterOptionFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts := bitmap.Options{Width: panelWidth, Height: panelHeight}
+	registerOptionFlags(fs, &opts)
+
+	err := fs.Parse([]string{"-width", "64", "-format", "gray4", "-dither", "atkinson", "-threshold", "100", "-bit-order", "lsb"})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	expected := bitmap.Options{Width: 64, Height: panelHeight, Format: bitmap.Gray4, Dither: bitmap.Atkinson, Threshold: 100, BitOrder: bitmap.LSBFirst, Gamma: 1, SharpenRadius: 1, EdgeRadius: 1, MorphologyRadius: 1}
+	if opts != expected {
+		t.Errorf("Expected %+v, got %+v", expected, opts)
+	}
+
+	for _, bad := range [][]string{{"-threshold", "0"}, {"-format", "cmyk"}, {"-resize", "squash"}, {"-matte", "teal"}, {"-equalize", "adaptive"}, {"-luma", "cyan"}, {"-rotate", "45"}, {"-crop", "0x10"}, {"-polarity", "red"}, {"-edges", "laplace"}, {"-morphology", "open"}, {"-gravity", "middle"}, {"-margins", "1,2,3"}, {"-offset", "3"}, {"-gamma", "0"}, {"-gamma", "-1"}} {
+		if err := fs.Parse(bad); err == nil {
+			t.Errorf("Expected an error for %v, got nil", bad)
+		}
+	}
+}
+
+func TestBitmapOptionArgs(t *testing.T) {
+	// Options added later are left out at their defaults, keeping older headers valid
+	args := strings.Join(bitmapOptionArgs(bitmap.Options{}), " ")
+	if expected := "-width=0 -height=0 -resize=stretch -scaler=bilinear -threshold=128 -dither=none -format=mono -bit-order=msb -invert=false"; args != expected {
+		t.Errorf("Expected %q, got %q", expected, args)
+	}
+	if args := bitmapOptionArgs(bitmap.Options{Matte: bitmap.White}); slices.ContainsFunc(args, func(a string) bool { return strings.HasPrefix(a, "-matte") }) {
+		t.Errorf("Expected an explicit white matte to be left out, got %q", args)
+	}
+
+	args = strings.Join(bitmapOptionArgs(bitmap.Options{Matte: bitmap.Black, Alpha: bitmap.AlphaThreshold, Luma: bitmap.Green}), " ")
+	if !strings.HasSuffix(args, " -matte=#000000 -alpha=threshold -luma=green") {
+		t.Errorf("Expected the matte, alpha mode and luma, got %q", args)
+	}
+
+	args = strings.Join(bitmapOptionArgs(bitmap.Options{Crop: bitmap.Rect(image.Rect(2, 4, 66, 36)), Rotate: bitmap.Rotate90, TransformStage: bitmap.TransformOutput}), " ")
+	if !strings.HasSuffix(args, " -invert=false -crop=64x32+2+4 -rotate=90 -transform-stage=output") {
+		t.Errorf("Expected the crop and orientation, got %q", args)
+	}
+
+	args = strings.Join(bitmapOptionArgs(bitmap.Options{Margins: bitmap.Margins{Top: 4, Right: 4, Bottom: 4, Left: 4}, ImageWidth: 64, Gravity: bitmap.TopLeft, Offset: bitmap.Point{X: 2, Y: -1}}), " ")
+	if !strings.HasSuffix(args, " -invert=false -margins=4 -image-width=64 -image-height=0 -gravity=top-left -offset=2,-1") {
+		t.Errorf("Expected the placement, got %q", args)
+	}
+
+	// A polarity is only spelled out when it is not that of the format
+	for _, opts := range []bitmap.Options{{Polarity: bitmap.HighBlack}, {Format: bitmap.Gray4, Polarity: bitmap.HighWhite}} {
+		if args := bitmapOptionArgs(opts); slices.ContainsFunc(args, func(a string) bool { return strings.HasPrefix(a, "-polarity") }) {
+			t.Errorf("Expected no -polarity for %+v, got %q", opts, args)
+		}
+	}
+	if args := strings.Join(bitmapOptionArgs(bitmap.Options{Polarity: bitmap.HighWhite}), " "); !strings.HasSuffix(args, " -polarity=white") {
+		t.Errorf("Expected the polarity, got %q", args)
+	}
+
+	// A gamma of 1 is no gamma, whichever way it is spelled
+	if args := bitmapOptionArgs(bitmap.Options{Gamma: 1}); len(args) != 9 {
+		t.Errorf("Expected no -gamma, got %q", args)
+	}
+	args = strings.Join(bitmapOptionArgs(bitmap.Options{Gamma: 2.2, Contrast: -0.25, Equalize: bitmap.CLAHE}), " ")
+	if !strings.HasSuffix(args, " -equalize=clahe -gamma=2.2 -contrast=-0.25") {
+		t.Errorf("Expected the tone adjustments, got %q", args)
+	}
+	args = strings.Join(bitmapOptionArgs(bitmap.Options{Sharpen: 1.5, SharpenRadius: 1, Edges: bitmap.Canny, EdgeRadius: 2, Morphology: bitmap.Dilate, MorphologyRadius: 2}), " ")
+	if !strings.HasSuffix(args, " -invert=false -sharpen=1.5 -edges=canny -edge-radius=2 -morphology=dilate -morphology-radius=2") {
+		t.Errorf("Expected the filters, got %q", args)
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts := bitmap.Options{Width: panelWidth, Height: panelHeight}
+	registerOptionFlags(fs, &opts)
+	var preview panelPreviewOptions
+	preview.register(fs)
+	if err := fs.Parse([]string{"-height", "32", "-dither", "atkinson"}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// The flags given explicitly win over the profile
+	if err := applyProfile(fs, "SSD1306", &opts, &preview); err != nil {
+		t.Fatalf("applyProfile failed: %v", err)
+	}
+	expected := bitmap.Options{Width: 128, Height: 32, Polarity: bitmap.HighWhite, Dither: bitmap.Atkinson, Gamma: 1, SharpenRadius: 1, EdgeRadius: 1, MorphologyRadius: 1}
+	if opts != expected || preview.panel != "oled" {
+		t.Errorf("Expected %+v on oled, got %+v on %s", expected, opts, preview.panel)
+	}
+	if err := applyProfile(fs, "ssd1307", &opts, &preview); err == nil || !strings.Contains(err.Error(), "ssd1306") {
+		t.Errorf("Expected an error listing the profiles, got %v", err)
+	}
+}
+
+func TestPreviewFlag(t *testing.T) {
+	var p previewFlag
+	if err := p.Set("true"); err != nil || p.mode != "halfblock" {
+		t.Errorf("Expected a bare --preview to select halfblock, got %q (%v)", p.mode, err)
+	}
+	if err := p.Set("braille"); err != nil || p.mode != "braille" {
+		t.Errorf("Expected --preview=braille to select braille, got %q (%v)", p.mode, err)
+	}
+	if err := p.Set("ascii"); err == nil {
+		t.Errorf("Expected an error for an unknown mode, got nil")
+	}
+}
+
+func TestPanelPreviewOptionsResolve(t *testing.T) {
+	opts := panelPreviewOptions{scale: 4, panel: "tricolor-red", paper: "#FFFFFF"}
+	panel, err := opts.resolve()
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if panel.Paper != (color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}) || panel.Ink != bitmap.Panels["tricolor-red"].Ink {
+		t.Errorf("Unexpected colors %+v", panel)
+	}
+
+	for _, bad := range []panelPreviewOptions{
+		{scale: 4, panel: "crt"},
+		{scale: 4, panel: "eink", ink: "#12345"},
+		{scale: 0, panel: "eink"},
+	} {
+		if _, err := bad.resolve(); err == nil {
+			t.Errorf("Expected an error for %+v, got nil", bad)
+		}
+	}
+}
@@ -1,39 +1,243 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"image2bytes/bitmap"
 )
 
-// generateGoFile writes the byte array data to a Go file
-func generateGoFile(outputPath string, varName string, data []byte, width, height int) error {
-	// Create the output Go file
-	outFile, err := os.Create(outputPath)
+// generatedLine marks files written by image2bytes, in the form the go tool and
+// linters recognize as generated code.
+const generatedLine = "// Code generated by image2bytes; DO NOT EDIT."
+
+// genHeader is the comment that starts every generated Go file. It records the
+// command that regenerates the file and a hash of every file it was made from,
+// so the check command can tell when the file is stale.
+type genHeader struct {
+	// command is the canonical command line, with paths relative to the
+	// directory of the generated file
+	command string
+	sources []genSource
+}
+
+// genSource is a file a generated file was made from.
+type genSource struct {
+	// path is relative to the directory of the generated file, with slashes
+	path string
+	sum  string
+}
+
+// write writes the header and the blank line that keeps it from becoming the package doc.
+func (h genHeader) write(w io.Writer) {
+	fmt.Fprintln(w, generatedLine)
+	if h.command != "" {
+		fmt.Fprintf(w, "// Command: image2bytes %s\n", h.command)
+	}
+	for _, s := range h.sources {
+		fmt.Fprintf(w, "// Source: %s sha256:%s\n", s.path, s.sum)
+	}
+	fmt.Fprintln(w)
+}
+
+// readHeader reads the header of a Go file. It returns nil when the file was
+// not generated by image2bytes.
+func readHeader(path string) (*genHeader, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// Ensure the output file is closed when the function returns
-	defer func(outFile *os.File) {
-		err = outFile.Close()
-		if err != nil {
-			panic(err)
+	// The file is only read, so a failed Close loses nothing
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() || sc.Text() != generatedLine {
+		return nil, sc.Err()
+	}
+	h := &genHeader{}
+	for sc.Scan() {
+		line := sc.Text()
+		if command, ok := strings.CutPrefix(line, "// Command: image2bytes "); ok {
+			h.command = command
+		} else if source, ok := strings.CutPrefix(line, "// Source: "); ok {
+			i := strings.LastIndex(source, " sha256:")
+			if i < 0 {
+				return nil, fmt.Errorf("invalid source line %q", line)
+			}
+			h.sources = append(h.sources, genSource{path: source[:i], sum: source[i+len(" sha256:"):]})
+		} else {
+			break
+		}
+	}
+	return h, sc.Err()
+}
+
+// hashFile returns the hex SHA-256 of a file's content.
+func hashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// relPath returns path relative to dir with slashes, as recorded in headers,
+// or the absolute path when there is no relative one.
+func relPath(dir, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	if absDir, err := filepath.Abs(dir); err == nil {
+		if rel, err := filepath.Rel(absDir, abs); err == nil {
+			return filepath.ToSlash(rel)
 		}
-	}(outFile)
+	}
+	return filepath.ToSlash(abs)
+}
 
-	// Write the Go code to the output file
-	// Start with the package declaration
-	_, _ = fmt.Fprintf(outFile, "package main\n\n")
-	// Begin the byte array declaration
-	_, _ = fmt.Fprintf(outFile, "var %s = []byte{", varName)
-	// Write the byte array data in a formatted way (12 bytes per line)
-	for i, b := range data {
-		if i%12 == 0 {
-			_, _ = fmt.Fprintf(outFile, "\n\t")
+// joinArgs joins command-line arguments with spaces, quoting the ones that
+// would not survive splitting.
+func joinArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if arg == "" || strings.ContainsAny(arg, " \t\"'\\") {
+			quoted[i] = strconv.Quote(arg)
 		}
-		_, _ = fmt.Fprintf(outFile, "0x%02X, ", b)
 	}
-	// Close the byte array declaration
-	_, _ = fmt.Fprintf(outFile, "\n}\n")
+	return strings.Join(quoted, " ")
+}
+
+// generateGoFile writes the bitmap to a Go file, starting with the header,
+// and the file its data is embedded from when it is.
+func generateGoFile(outputPath string, h genHeader, bm *bitmap.Bitmap, opts bitmap.GoOptions) error {
+	asset := bitmap.Asset{Name: opts.Name, Bitmap: bm, Compression: opts.Compression, Data: opts.Data, Mask: opts.Mask}
+	return writeGoFile(outputPath, []bitmap.Asset{asset}, func(w io.Writer) error {
+		h.write(w)
+		return bitmap.WriteGo(w, bm, opts)
+	})
+}
+
+// writeGoFile renders a Go file declaring assets with write and puts it at
+// outputPath, after the files it embeds. A Go file is never newer than its
+// data, which would make its length check panic when the program starts.
+func writeGoFile(outputPath string, assets []bitmap.Asset, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	if err := writeEmbedFiles(filepath.Dir(outputPath), assets); err != nil {
+		return err
+	}
+	return replaceFile(outputPath, buf.Bytes())
+}
 
+// writeEmbedFiles writes the data of the assets declared with go:embed to the
+// files their Go file in dir embeds, masks included. It runs after the Go file
+// was rendered, which rejects assets that would share a file.
+func writeEmbedFiles(dir string, assets []bitmap.Asset) error {
+	for _, a := range assets {
+		if a.Mask != nil {
+			if err := writeEmbedFiles(dir, []bitmap.Asset{*a.Mask}); err != nil {
+				return err
+			}
+		}
+		if a.Data != bitmap.DataEmbed {
+			continue
+		}
+		if err := replaceFile(filepath.Join(dir, a.EmbedFile()), a.Bytes()); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// generatePNGFile writes an image to a PNG file
+func generatePNGFile(outputPath string, img image.Image) error {
+	return writeFile(outputPath, func(w io.Writer) error {
+		return png.Encode(w, img)
+	})
+}
+
+// writeFile renders a file with write and puts it at outputPath with
+// replaceFile, so a failed run never leaves a half-written file behind.
+func writeFile(outputPath string, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	return replaceFile(outputPath, buf.Bytes())
+}
+
+// replaceFile atomically replaces path with data: it writes a temporary file in
+// the same directory, syncs it and renames it into place. An existing file keeps
+// its permissions, new files get 0644, and a file that already holds data is
+// left untouched so its mtime stays stable for build caches.
+func replaceFile(path string, data []byte) (err error) {
+	perm := os.FileMode(0o644)
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		if !info.Mode().IsRegular() {
+			return &fs.PathError{Op: "replace", Path: path, Err: errors.New("not a regular file")}
+		}
+		// Replace the file a symlink points to rather than the link itself
+		if path, err = filepath.EvalSymlinks(path); err != nil {
+			return err
+		}
+		perm = info.Mode().Perm()
+		if info.Size() == int64(len(data)) {
+			if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, data) {
+				return nil
+			}
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	// Create the temporary file next to the destination, so the rename cannot cross file systems
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		// Report the destination rather than the random temporary name
+		var pe *fs.PathError
+		if errors.As(err, &pe) {
+			err = &fs.PathError{Op: "create", Path: path, Err: pe.Err}
+		}
+		return err
+	}
+	// Remove the temporary file unless it was renamed into place
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -4,7 +4,12 @@ import (
 	"bytes"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"image2bytes/bitmap"
 )
 
 func TestGenerateGoFile(t *testing.T) {
@@ -24,12 +29,13 @@ func TestGenerateGoFile(t *testing.T) {
 	outputPath := filepath.Join(tempDir, "test_output.go")
 
 	// Create test data
-	data := []byte{0xA0, 0xA0, 0xA0, 0xA0}
-	width, height := 4, 4
-	varName := "TestImage"
+	bm, err := bitmap.New(4, 4, bitmap.Mono1, bitmap.MSBFirst, []byte{0xA0, 0xA0, 0xA0, 0xA0})
+	if err != nil {
+		t.Fatalf("bitmap.New failed: %v", err)
+	}
 
 	// Generate the Go file
-	err = generateGoFile(outputPath, varName, data, width, height)
+	err = generateGoFile(outputPath, genHeader{}, bm, bitmap.GoOptions{Name: "TestImage"})
 	if err != nil {
 		t.Fatalf("generateGoFile failed: %v", err)
 	}
@@ -63,12 +69,13 @@ func TestGenerateGoFileError(t *testing.T) {
 	outputPath := "/nonexistent/directory/test_output.go"
 
 	// Create test data
-	data := []byte{0xA0, 0xA0, 0xA0, 0xA0}
-	width, height := 4, 4
-	varName := "TestImage"
+	bm, err := bitmap.New(4, 4, bitmap.Mono1, bitmap.MSBFirst, []byte{0xA0, 0xA0, 0xA0, 0xA0})
+	if err != nil {
+		t.Fatalf("bitmap.New failed: %v", err)
+	}
 
 	// Generate the Go file, which should fail
-	err := generateGoFile(outputPath, varName, data, width, height)
+	err = generateGoFile(outputPath, genHeader{}, bm, bitmap.GoOptions{Name: "TestImage"})
 
 	// Check that an error was returned
 	if err == nil {
@@ -94,12 +101,13 @@ func TestGenerateGoFileWithEmptyData(t *testing.T) {
 	outputPath := filepath.Join(tempDir, "empty_output.go")
 
 	// Create empty test data
-	var data []byte
-	width, height := 0, 0
-	varName := "EmptyImage"
+	bm, err := bitmap.New(0, 0, bitmap.Mono1, bitmap.MSBFirst, nil)
+	if err != nil {
+		t.Fatalf("bitmap.New failed: %v", err)
+	}
 
 	// Generate the Go file
-	err = generateGoFile(outputPath, varName, data, width, height)
+	err = generateGoFile(outputPath, genHeader{}, bm, bitmap.GoOptions{Name: "EmptyImage"})
 	if err != nil {
 		t.Fatalf("generateGoFile failed: %v", err)
 	}
@@ -126,3 +134,164 @@ func TestGenerateGoFileWithEmptyData(t *testing.T) {
 		}
 	}
 }
+
+// TestGenerateGoFileUnchanged checks that rewriting identical content keeps the file's mtime
+func TestGenerateGoFileUnchanged(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "logo.go")
+	bm, err := bitmap.New(8, 1, bitmap.Mono1, bitmap.MSBFirst, []byte{0xA5})
+	if err != nil {
+		t.Fatalf("bitmap.New failed: %v", err)
+	}
+	if err := generateGoFile(outputPath, genHeader{}, bm, bitmap.GoOptions{Name: "Logo"}); err != nil {
+		t.Fatalf("generateGoFile failed: %v", err)
+	}
+
+	// Backdate the file, then write the same content again
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(outputPath, past, past); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+	if err := generateGoFile(outputPath, genHeader{}, bm, bitmap.GoOptions{Name: "Logo"}); err != nil {
+		t.Fatalf("generateGoFile failed: %v", err)
+	}
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to stat generated file: %v", err)
+	}
+	if !info.ModTime().Equal(past) {
+		t.Errorf("Expected the mtime to stay %v, got %v", past, info.ModTime())
+	}
+}
+
+// TestGenerateGoFileKeepsPermissions checks that replacing a file keeps its mode
+func TestGenerateGoFileKeepsPermissions(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "logo.go")
+	if err := os.WriteFile(outputPath, []byte("package main\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if err := os.Chmod(outputPath, 0o640); err != nil {
+		t.Fatalf("Failed to chmod test file: %v", err)
+	}
+
+	bm, err := bitmap.New(8, 1, bitmap.Mono1, bitmap.MSBFirst, []byte{0xA5})
+	if err != nil {
+		t.Fatalf("bitmap.New failed: %v", err)
+	}
+	if err := generateGoFile(outputPath, genHeader{}, bm, bitmap.GoOptions{Name: "Logo"}); err != nil {
+		t.Fatalf("generateGoFile failed: %v", err)
+	}
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to stat generated file: %v", err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("Expected mode 0640, got %v", info.Mode().Perm())
+	}
+}
+
+// TestGenerateGoFileFailureKeepsOriginal checks that a failed write leaves the
+// existing file and no temporary files behind
+func TestGenerateGoFileFailureKeepsOriginal(t *testing.T) {
+	tempDir := t.TempDir()
+	outputPath := filepath.Join(tempDir, "logo.go")
+	original := []byte("package main\n\nvar Logo = []byte{0x01}\n")
+	if err := os.WriteFile(outputPath, original, 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	bm, err := bitmap.New(8, 1, bitmap.Mono1, bitmap.MSBFirst, []byte{0xA5})
+	if err != nil {
+		t.Fatalf("bitmap.New failed: %v", err)
+	}
+	// An invalid name makes the encoder fail
+	if err := generateGoFile(outputPath, genHeader{}, bm, bitmap.GoOptions{Name: "not-a-name"}); err == nil {
+		t.Fatalf("Expected an error for an invalid name, got nil")
+	}
+
+	content, err := os.ReadFile(outputPath)
+	if err != nil || !bytes.Equal(content, original) {
+		t.Errorf("Expected the original file to be kept, got %q (%v)", content, err)
+	}
+	entries, err := os.ReadDir(tempDir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected no temporary files, got %v (%v)", entries, err)
+	}
+}
+
+func TestGenerateGoFileEmbedFailureKeepsOriginal(t *testing.T) {
+	tempDir := t.TempDir()
+	outputPath := filepath.Join(tempDir, "logo.go")
+	original := []byte("package main\n\nvar Logo = []byte{0x01}\n")
+	if err := os.WriteFile(outputPath, original, 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	// A directory where the data goes makes writing it fail
+	if err := os.Mkdir(filepath.Join(tempDir, "logo.bin"), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	bm, err := bitmap.New(8, 1, bitmap.Mono1, bitmap.MSBFirst, []byte{0xA5})
+	if err != nil {
+		t.Fatalf("bitmap.New failed: %v", err)
+	}
+	if err := generateGoFile(outputPath, genHeader{}, bm, bitmap.GoOptions{Name: "Logo", Data: bitmap.DataEmbed}); err == nil {
+		t.Fatalf("Expected an error writing the embedded data, got nil")
+	}
+
+	// The Go file would check the length of data that was never written
+	content, err := os.ReadFile(outputPath)
+	if err != nil || !bytes.Equal(content, original) {
+		t.Errorf("Expected the original file to be kept, got %q (%v)", content, err)
+	}
+}
+
+func TestGenHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logo.go")
+	bm, _ := bitmap.New(8, 1, bitmap.Mono1, bitmap.MSBFirst, []byte{0xA5})
+	h := genHeader{
+		command: `-in="my logo.png" -out=logo.go -width=8`,
+		sources: []genSource{{path: "my logo.png", sum: "0123"}, {path: "../assets.yaml", sum: "abcd"}},
+	}
+	if err := generateGoFile(path, h, bm, bitmap.GoOptions{Name: "Logo"}); err != nil {
+		t.Fatalf("generateGoFile failed: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read generated file: %v", err)
+	}
+	expected := generatedLine + "\n" +
+		"// Command: image2bytes -in=\"my logo.png\" -out=logo.go -width=8\n" +
+		"// Source: my logo.png sha256:0123\n" +
+		"// Source: ../assets.yaml sha256:abcd\n" +
+		"\n" +
+		"package main\n"
+	if !strings.HasPrefix(string(content), expected) {
+		t.Errorf("Expected the file to start with:\n%s\ngot:\n%s", expected, content)
+	}
+
+	got, err := readHeader(path)
+	if err != nil || got == nil {
+		t.Fatalf("readHeader failed: %v", err)
+	}
+	if got.command != h.command || !slices.Equal(got.sources, h.sources) {
+		t.Errorf("Expected %+v, got %+v", h, *got)
+	}
+
+	// Files written by hand have no header
+	other := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(other, []byte("package main\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	if got, err := readHeader(other); got != nil || err != nil {
+		t.Errorf("Expected no header, got %+v, %v", got, err)
+	}
+}
+
+func TestJoinArgs(t *testing.T) {
+	got := joinArgs([]string{"-in=a.png", "-in=my logo.png", "", `say "hi"`})
+	expected := `-in=a.png "-in=my logo.png" "" "say \"hi\""`
+	if got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
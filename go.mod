@@ -2,4 +2,10 @@ module image2bytes
 
 go 1.24
 
-require golang.org/x/image v0.30.0 // indirect
+require (
+	github.com/BurntSushi/toml v1.6.0
+	golang.org/x/image v0.30.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/text v0.28.0 // indirect
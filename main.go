@@ -2,71 +2,342 @@ package main
 
 // image2bytes is a utility that converts PNG images to byte arrays for embedding in Go code.
 // It processes the image pixel by pixel, converting it to a monochrome representation
-// where each bit represents a pixel (1 for black, 0 for white).
+// where each bit represents a pixel (1 for black, 0 for white, unless the polarity says otherwise).
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"go/token"
 	"image/png"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
-)
 
-const (
-	inputPath  = "input.png"
-	outputPath = "output.go"
+	"image2bytes/bitmap"
+	"image2bytes/compress"
 )
 
-// main is the entry point of the program. It processes command-line arguments,
-// reads the input PNG file, converts it to a byte array, and writes the result to a Go file.
+// main is the entry point of the program. It exits with the status returned by run.
 func main() {
-	// Validate that inputPath is a PNG file
-	if !isPNGFile(inputPath) {
-		fmt.Println("Error: Input file must be a PNG file (with .png extension)")
-		return
-	}
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// convertConfig holds everything the command line decides about a conversion.
+type convertConfig struct {
+	options bitmap.Options
+	pkg     string
+	codec   string
+	params  compress.Params
+	data    bitmap.DataStyle
+	preview string
+	// mask writes a transparency mask after each bitmap when set
+	mask          bool
+	maskThreshold uint8
+	// panelPNG writes the simulated panel next to the output when set
+	panelPNG   bool
+	panel      bitmap.Panel
+	panelScale int
+	panelGrid  bool
+	// text is rendered instead of reading an image when it is set
+	text textConfig
+}
 
-	// Validate that outputPath is a Go file
-	if !isGoFile(outputPath) {
-		fmt.Println("Error: Output file must be a Go file (with .go extension)")
-		return
+// run processes the command-line arguments, converts the input PNG file and
+// writes the result to a Go file. Progress goes to stdout and errors to stderr.
+// It returns the exit code: exitOK, exitError when a file could not be
+// processed, or exitUsage for bad arguments.
+func run(args []string, stdout, stderr io.Writer) int {
+	// Dispatch subcommands
+	if len(args) > 0 {
+		switch args[0] {
+		case "decode":
+			return decodeCommand(args[1:], stdout, stderr)
+		case "build":
+			return buildCommand(args[1:], stdout, stderr)
+		case "check":
+			return checkCommand(args[1:], stdout, stderr)
+		case "cache":
+			return cacheCommand(args[1:], stdout, stderr)
+		case "compose":
+			return composeCommand(args[1:], stdout, stderr)
+		}
 	}
 
-	// Generate a variable name for the output Go file based on the output file name
-	varName := strings.TrimSuffix(titleCase(strings.ReplaceAll(outputPath, ".go", "")), ".go")
+	c, code := parseConvertArgs(args, "", stderr)
+	if c == nil {
+		return code
+	}
 
-	// Open the input PNG file
-	file, err := os.Open(inputPath)
+	// Convert the images and write the output files
+	if c.watch.enabled {
+		return c.watch.watch(c.plan, []string{c.root()}, stdout, stderr)
+	}
+	p, err := c.plan()
+	if err == nil {
+		err = p.build(stdout)
+	}
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return exitError
 	}
-	// Ensure the file is closed when the function returns
-	defer func(file *os.File) {
-		err = file.Close()
-		if err != nil {
-			panic(err)
+	return exitOK
+}
+
+// convertArgs is the parsed command line of a conversion.
+type convertArgs struct {
+	cfg     convertConfig
+	input   string
+	output  string
+	batch   bool
+	watch   watchOptions
+	noCache bool
+}
+
+// parseConvertArgs parses the flags and arguments of a conversion. Relative
+// paths are resolved against dir unless it is empty, as go:generate runs in
+// the directory of the file holding the directive. Usage and errors go to
+// stderr; when there is nothing to convert it returns nil and the exit code.
+func parseConvertArgs(args []string, dir string, stderr io.Writer) (*convertArgs, int) {
+	// Parse the command-line flags
+	fs := flag.NewFlagSet("image2bytes", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go run . [flags] input.png output.go")
+		fmt.Fprintln(fs.Output(), "       go run . [flags] dir|'glob*.png' combined.go|outdir")
+		fmt.Fprintln(fs.Output(), "       go run . [flags] -text 'Hello' output.go")
+		fmt.Fprintln(fs.Output(), "       go run . [flags] -in input.png -out output.go")
+		fs.PrintDefaults()
+	}
+	c := &convertArgs{cfg: convertConfig{options: bitmap.Options{Width: panelWidth, Height: panelHeight}}}
+	cfg := &c.cfg
+	fs.StringVar(&c.input, "in", "", "input PNG file, directory or glob, instead of the first argument")
+	fs.StringVar(&c.output, "out", "", "output Go file or directory, instead of the last argument")
+	registerOptionFlags(fs, &cfg.options)
+	profile := fs.String("profile", "", "start from the size, format, bit order, polarity and preview panel of a display: "+strings.Join(profileNames(), ", "))
+	fs.StringVar(&cfg.pkg, "package", "main", "package clause of the generated Go files")
+	fs.StringVar(&cfg.codec, "codec", "none", "compress the byte array with none, heatshrink, lz4, lzss, or auto to pick the smallest")
+	fs.IntVar(&cfg.params.Window, "window", compress.DefaultParams.Window, "log2 of the compression window in bytes (heatshrink, lz4)")
+	fs.IntVar(&cfg.params.Lookahead, "lookahead", compress.DefaultParams.Lookahead, "log2 of the longest heatshrink match")
+	fs.BoolVar(&cfg.mask, "mask", false, "also write a 1bpp transparency mask of each image, named with a Mask suffix")
+	fs.Func("mask-threshold", "alpha 1-255 from which a pixel is opaque in the mask (default 128)", levelFlag(&cfg.maskThreshold))
+	fs.TextVar(&cfg.data, "data", bitmap.DataBytes, "write the data as a []byte of hex `bytes`, a string literal (string), which compiles faster, or a go:embed sidecar .bin file (embed)")
+	var preview previewFlag
+	fs.Var(&preview, "preview", "render the packed bitmap in the terminal, as halfblock (default), or with =braille, =sixel or =kitty")
+	var panelPreview panelPreviewOptions
+	panelPreview.register(fs)
+	cfg.text.register(fs)
+	c.watch.register(fs)
+	fs.BoolVar(&c.noCache, "no-cache", false, "convert every image again instead of reusing earlier conversions")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, exitOK
+		}
+		return nil, exitUsage
+	}
+	rest := fs.Args()
+	text := cfg.text.text != ""
+	if c.input == "" && len(rest) > 0 && !text {
+		c.input, rest = rest[0], rest[1:]
+	}
+	if c.output == "" && len(rest) > 0 {
+		c.output, rest = rest[0], rest[1:]
+	}
+	if text && c.input != "" {
+		fmt.Fprintln(stderr, "Error: -text replaces the input image")
+		return nil, exitUsage
+	}
+	if (c.input == "" && !text) || c.output == "" || len(rest) > 0 {
+		fmt.Fprintln(stderr, "Usage: go run . input.png output.go")
+		return nil, exitUsage
+	}
+	if dir != "" {
+		c.input, c.output = resolvePath(dir, c.input), resolvePath(dir, c.output)
+		if cfg.text.font != "" {
+			cfg.text.font = resolvePath(dir, cfg.text.font)
+		}
+	}
+	if text {
+		// The font is the source of the bitmap, so check sees it change
+		c.input = cfg.text.font
+	}
+	c.batch = !text && isBatchInput(c.input)
+
+	// Text keeps its size and sits on the bitmap, unless -resize says otherwise
+	if text {
+		if cfg.text.opts.Size < 0 {
+			fmt.Fprintf(stderr, "Error: invalid font size %g\n", cfg.text.opts.Size)
+			return nil, exitUsage
+		}
+		resize := false
+		fs.Visit(func(f *flag.Flag) { resize = resize || f.Name == "resize" })
+		if !resize {
+			cfg.options.Resize = bitmap.NoResize
+		}
+	}
+
+	// Validate that the input is a PNG file
+	if !text && !c.batch && !isPNGFile(c.input) {
+		fmt.Fprintln(stderr, "Error: Input file must be a PNG file (with .png extension)")
+		return nil, exitUsage
+	}
+
+	// Validate that the output is a Go file, or a directory in batch mode
+	if !isGoFile(c.output) && (!c.batch || filepath.Ext(c.output) != "") {
+		fmt.Fprintln(stderr, "Error: Output file must be a Go file (with .go extension)")
+		return nil, exitUsage
+	}
+
+	// Validate the package clause before any work is done
+	if !token.IsIdentifier(cfg.pkg) {
+		fmt.Fprintf(stderr, "Error: invalid package name %q\n", cfg.pkg)
+		return nil, exitUsage
+	}
+
+	// Start from the display profile, keeping the options given explicitly
+	if *profile != "" {
+		if err := applyProfile(fs, *profile, &cfg.options, &panelPreview); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return nil, exitUsage
 		}
-	}(file)
+	}
 
-	// Decode the PNG image
-	img, err := png.Decode(file)
+	// Resolve the colors of the simulated panel
+	panel, err := panelPreview.resolve()
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return nil, exitUsage
+	}
+	cfg.preview = preview.mode
+	cfg.panelPNG, cfg.panel, cfg.panelScale, cfg.panelGrid = panelPreview.enabled, panel, panelPreview.scale, panelPreview.grid
+	return c, exitOK
+}
+
+// plan plans the conversion of a single image or a batch.
+func (c *convertArgs) plan() (*plan, error) {
+	p := singlePlan(c.input, c.output, c.cfg)
+	if c.batch {
+		var err error
+		if p, err = planBatch(c.input, c.output, c.cfg); err != nil {
+			return nil, err
+		}
+	}
+	if !c.noCache {
+		p.cache = openCache()
+	}
+	return p, nil
+}
+
+// root returns the file or directory whose change means planning again.
+func (c *convertArgs) root() string {
+	if c.batch {
+		return patternDir(c.input)
 	}
+	return c.input
+}
 
-	// Process the image
-	data, width, height, err := processImage(img)
+// resolvePath joins a relative path to dir.
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// convertAsset reads, converts and compresses one PNG file, showing the
+// terminal preview and compression ratios on the way. Conversions found in
+// cache skip the decode and the conversion.
+func convertAsset(inputPath, name string, cfg convertConfig, cache *buildCache, stdout io.Writer) (bitmap.Asset, error) {
+	// Read the input PNG file
+	data, err := os.ReadFile(inputPath)
 	if err != nil {
-		panic(err)
+		return bitmap.Asset{}, &stageError{stage: "read", path: inputPath, err: err}
 	}
 
-	fmt.Printf("Image dimensions: %dx%d\n", width, height)
+	// Convert the image and its mask, unless they were converted with the same
+	// options before
+	key := cacheKey(data, cfg.options)
+	bm := cache.get(key)
+	var mask *bitmap.Bitmap
+	maskKey := cacheKey(data, cfg.options, fmt.Sprintf("-mask-threshold=%d", cfg.maskThreshold))
+	if cfg.mask {
+		mask = cache.get(maskKey)
+	}
+	cached := ""
+	if bm != nil && (mask != nil || !cfg.mask) {
+		cached = " (cached)"
+	} else {
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return bitmap.Asset{}, &stageError{stage: "decode", path: inputPath, err: err}
+		}
+		if bm == nil {
+			if bm, err = bitmap.Convert(img, cfg.options); err != nil {
+				return bitmap.Asset{}, convertError(inputPath, err)
+			}
+			cache.put(key, bm)
+		}
+		if cfg.mask && mask == nil {
+			if mask, err = bitmap.ConvertMask(img, cfg.options, cfg.maskThreshold); err != nil {
+				return bitmap.Asset{}, convertError(inputPath, err)
+			}
+			cache.put(maskKey, mask)
+		}
+	}
+	return packAsset(inputPath, name, bm, mask, cached, cfg, stdout)
+}
+
+// packAsset compresses a converted image and its mask, if any, into the asset
+// of inputPath, showing the terminal preview and compression ratios on the
+// way. cached is appended to the dimensions it prints.
+func packAsset(inputPath, name string, bm, mask *bitmap.Bitmap, cached string, cfg convertConfig, stdout io.Writer) (bitmap.Asset, error) {
+	fmt.Fprintf(stdout, "Image dimensions: %dx%d%s\n", bm.Width, bm.Height, cached)
 
-	// Generate the output Go file
-	err = generateGoFile(outputPath, varName, data, width, height)
+	// Show the packed pixels before they are compressed
+	if cfg.preview != "" {
+		if err := bitmap.WritePreview(stdout, bm, cfg.preview); err != nil {
+			return bitmap.Asset{}, &stageError{stage: "write", path: "preview", err: err}
+		}
+	}
+
+	// Compress the data, reporting the ratio of every codec tried
+	results, err := compress.Compress(cfg.codec, cfg.params, bm.Data)
 	if err != nil {
-		panic(err)
+		return bitmap.Asset{}, &stageError{stage: "compress", path: inputPath, err: err}
+	}
+	if cfg.codec != "none" {
+		for _, r := range results {
+			fmt.Fprintf(stdout, "%-10s %6d -> %6d bytes (%.1f%%)\n", r.Codec.Name(), r.RawSize, len(r.Data), r.Ratio())
+		}
+		fmt.Fprintf(stdout, "Using %s\n", results[0].Codec.Name())
 	}
+	asset := bitmap.Asset{Key: filepath.Base(inputPath), Name: name, Bitmap: bm, Compression: &results[0], Data: cfg.data}
 
-	// Print a success message
-	fmt.Printf("Done. Bytes written to %s\n", outputPath)
+	// The mask uses the codec chosen for the image, so one decoder reads both
+	if mask != nil {
+		maskResults, err := compress.Compress(results[0].Codec.Name(), cfg.params, mask.Data)
+		if err != nil {
+			return bitmap.Asset{}, &stageError{stage: "compress", path: inputPath, err: err}
+		}
+		asset.Mask = &bitmap.Asset{Name: name + "Mask", Bitmap: mask, Compression: &maskResults[0], Data: cfg.data}
+	}
+	return asset, nil
+}
+
+// writePanelPreview writes the simulated panel to previewPath when -preview-png is set.
+func writePanelPreview(previewPath string, bm *bitmap.Bitmap, cfg convertConfig, stdout io.Writer) error {
+	if !cfg.panelPNG {
+		return nil
+	}
+	panel, err := bitmap.PanelImage(bm, cfg.panel, cfg.panelScale, cfg.panelGrid)
+	if err == nil {
+		err = generatePNGFile(previewPath, panel)
+	}
+	if err != nil {
+		return &stageError{stage: "write", path: previewPath, err: err}
+	}
+	fmt.Fprintf(stdout, "Preview written to %s\n", previewPath)
+	return nil
 }
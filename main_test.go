@@ -2,198 +2,171 @@ package main
 
 import (
 	"bytes"
-	"io"
+	"image/png"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
-// TestMainWithInvalidArgs tests the main function with invalid arguments
-func TestMainWithInvalidArgs(t *testing.T) {
-	// Save original os.Args and os.Stdout
-	oldArgs := os.Args
-	oldStdout := os.Stdout
-
-	// Create a pipe to capture stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
+// TestMain keeps the tests from using the user cache. Tests of the cache
+// point it at a directory of their own.
+func TestMain(m *testing.M) {
+	os.Setenv(cacheEnv, "off")
+	os.Exit(m.Run())
+}
 
-	// Restore original values when the test completes
-	defer func() {
-		os.Args = oldArgs
-		os.Stdout = oldStdout
-	}()
+// TestMainWithInvalidArgs tests run with invalid arguments
+func TestMainWithInvalidArgs(t *testing.T) {
+	var stdout, stderr bytes.Buffer
 
 	// Test with no arguments
-	os.Args = []string{"cmd"}
-	main()
-
-	// Close the write end of the pipe to read from it
-	err := w.Close()
-	if err != nil {
-		return
-	}
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, r)
-	if err != nil {
-		return
-	}
-	output := buf.String()
+	code := run(nil, &stdout, &stderr)
 
 	// Check if the usage message was printed
-	if output != "Usage: go run . input.png output.go\n" {
-		t.Errorf("Expected usage message, got: %s", output)
+	if code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+	if stderr.String() != "Usage: go run . input.png output.go\n" {
+		t.Errorf("Expected usage message, got: %s", stderr.String())
 	}
 }
 
-// TestMainWithInvalidInputFile tests the main function with an invalid input file
+// TestMainWithInvalidInputFile tests run with an invalid input file
 func TestMainWithInvalidInputFile(t *testing.T) {
-	// Save original os.Args and os.Stdout
-	oldArgs := os.Args
-	oldStdout := os.Stdout
-
-	// Create a pipe to capture stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
-	// Restore original values when the test completes
-	defer func() {
-		os.Args = oldArgs
-		os.Stdout = oldStdout
-	}()
+	var stdout, stderr bytes.Buffer
 
 	// Test with invalid input file extension
-	os.Args = []string{"cmd", "input.txt", "output.go"}
-	main()
-
-	// Close the write end of the pipe to read from it
-	err := w.Close()
-	if err != nil {
-		return
-	}
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, r)
-	if err != nil {
-		return
-	}
-	output := buf.String()
+	code := run([]string{"input.txt", "output.go"}, &stdout, &stderr)
 
 	// Check if the error message was printed
-	if output != "Error: Input file must be a PNG file (with .png extension)\n" {
-		t.Errorf("Expected input file error message, got: %s", output)
+	if code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+	if stderr.String() != "Error: Input file must be a PNG file (with .png extension)\n" {
+		t.Errorf("Expected input file error message, got: %s", stderr.String())
 	}
 }
 
-// TestMainWithInvalidOutputFile tests the main function with an invalid output file
+// TestMainWithInvalidOutputFile tests run with an invalid output file
 func TestMainWithInvalidOutputFile(t *testing.T) {
-	// Save original os.Args and os.Stdout
-	oldArgs := os.Args
-	oldStdout := os.Stdout
-
-	// Create a pipe to capture stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
-	// Restore original values when the test completes
-	defer func() {
-		os.Args = oldArgs
-		os.Stdout = oldStdout
-	}()
+	var stdout, stderr bytes.Buffer
 
 	// Test with invalid output file extension
-	os.Args = []string{"cmd", "input.png", "output.txt"}
-	main()
-
-	// Close the write end of the pipe to read from it
-	err := w.Close()
-	if err != nil {
-		return
-	}
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, r)
-	if err != nil {
-		return
-	}
-	output := buf.String()
+	code := run([]string{"input.png", "output.txt"}, &stdout, &stderr)
 
 	// Check if the error message was printed
-	if output != "Error: Output file must be a Go file (with .go extension)\n" {
-		t.Errorf("Expected output file error message, got: %s", output)
+	if code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+	if stderr.String() != "Error: Output file must be a Go file (with .go extension)\n" {
+		t.Errorf("Expected output file error message, got: %s", stderr.String())
 	}
 }
 
-// TestMainWithValidFiles tests the main function with valid input and output files
+// TestMainWithValidFiles tests run with valid input and output files
 func TestMainWithValidFiles(t *testing.T) {
-	// Create a temporary directory for test files
-	tempDir, err := os.MkdirTemp("", "image2bytes_test")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-	defer func(path string) {
-		err := os.RemoveAll(path)
-		if err != nil {
-			t.Fatalf("Failed to remove temp dir: %v", err)
-		}
-	}(tempDir)
+	tempDir := t.TempDir()
 
 	// Create a test PNG file
 	inputPath := filepath.Join(tempDir, "test_input.png")
-	// Create a small 1x1 PNG file
-	err = createTestPNGFile(inputPath)
-	if err != nil {
-		t.Fatalf("Failed to create test PNG file: %v", err)
-	}
-
-	// Create a test output file path
+	writeCheckerboardPNG(t, inputPath, 16, 8)
 	outputPath := filepath.Join(tempDir, "test_output.go")
 
-	// Save original os.Args and os.Stdout
-	oldArgs := os.Args
-	oldStdout := os.Stdout
-
-	// Create a pipe to capture stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
-	// Restore original values when the test completes
-	defer func() {
-		os.Args = oldArgs
-		os.Stdout = oldStdout
-	}()
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-width", "0", "-height", "0", "-preview-png", inputPath, outputPath}, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "Image dimensions: 16x8\n") || !strings.HasSuffix(stdout.String(), "Done. Bytes written to "+outputPath+"\n") {
+		t.Errorf("Unexpected output: %s", stdout.String())
+	}
 
-	// Test with valid files
-	os.Args = []string{"cmd", inputPath, outputPath}
+	// The generated file and the preview must both exist
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read generated file: %v", err)
+	}
+	if !bytes.Contains(content, []byte("const TestOutputWidth = 16")) {
+		t.Errorf("Unexpected generated file:\n%s", content)
+	}
+	if _, err := os.Stat(previewPNGPath(outputPath)); err != nil {
+		t.Errorf("Expected a preview PNG: %v", err)
+	}
+}
 
-	// Skip the actual main() call since we can't easily create a valid PNG file
-	// main()
+// TestMainErrors checks that failures name the file and the stage and exit non-zero
+func TestMainErrors(t *testing.T) {
+	tempDir := t.TempDir()
 
-	// Close the write end of the pipe to read from it
-	err = w.Close()
-	if err != nil {
-		return
+	// An empty file is not a valid PNG
+	emptyPath := filepath.Join(tempDir, "empty.png")
+	if err := createTestPNGFile(emptyPath); err != nil {
+		t.Fatalf("Failed to create test PNG file: %v", err)
 	}
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, r)
-	if err != nil {
-		return
+	validPath := filepath.Join(tempDir, "valid.png")
+	writeCheckerboardPNG(t, validPath, 4, 4)
+	missingPath := filepath.Join(tempDir, "missing.png")
+	outputPath := filepath.Join(tempDir, "out.go")
+	badOutputPath := filepath.Join(tempDir, "nonexistent", "out.go")
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{name: "missing input", args: []string{missingPath, outputPath}, expected: "Error: read " + missingPath + ": no such file or directory\n"},
+		{name: "invalid png", args: []string{emptyPath, outputPath}, expected: "Error: decode " + emptyPath + ": unexpected EOF\n"},
+		{name: "invalid size", args: []string{"-width", "-1", validPath, outputPath}, expected: "Error: resize " + validPath + ": invalid size -1x128\n"},
+		{name: "invalid dither", args: []string{"-format", "rgb565", "-dither", "ordered", validPath, outputPath}, expected: "Error: pack " + validPath + ": dithering is not supported for rgb565\n"},
+		{name: "unwritable output", args: []string{validPath, badOutputPath}, expected: "Error: write " + badOutputPath + ": no such file or directory\n"},
 	}
 
-	// Instead, let's test the individual components that main() would call
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tt.args, &stdout, &stderr); code != exitError {
+				t.Errorf("Expected exit code %d, got %d", exitError, code)
+			}
+			if stderr.String() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, stderr.String())
+			}
+		})
+	}
+}
 
-	// Test titleCase function
-	varName := titleCase("test_output")
-	if varName != "Test Output" {
-		t.Errorf("Expected 'Test Output', got '%s'", varName)
+// TestMainFullDisk checks that an output that cannot be replaced, here a link
+// to a full device, is reported instead of succeeding with exit status 0
+func TestMainFullDisk(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full is not available")
+	}
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "input.png")
+	writeCheckerboardPNG(t, inputPath, 4, 4)
+	outputPath := filepath.Join(tempDir, "full.go")
+	if err := os.Symlink("/dev/full", outputPath); err != nil {
+		t.Skipf("Failed to link /dev/full: %v", err)
 	}
 
-	// Test isPNGFile function
-	if !isPNGFile(inputPath) {
-		t.Errorf("Expected isPNGFile to return true for %s", inputPath)
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{inputPath, outputPath}, &stdout, &stderr); code != exitError {
+		t.Errorf("Expected exit code %d, got %d", exitError, code)
 	}
+	if !strings.HasPrefix(stderr.String(), "Error: write "+outputPath+": ") {
+		t.Errorf("Expected a write error, got %q", stderr.String())
+	}
+}
 
-	// Test isGoFile function
-	if !isGoFile(outputPath) {
-		t.Errorf("Expected isGoFile to return true for %s", outputPath)
+// writeCheckerboardPNG writes a checkerboard PNG file for run to convert
+func writeCheckerboardPNG(t *testing.T, path string, width, height int) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, checkerboard(width, height)); err != nil {
+		t.Fatalf("Failed to encode test PNG: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write test PNG: %v", err)
 	}
 }
 
@@ -247,3 +220,40 @@ func TestCreateTestPNGFile(t *testing.T) {
 		t.Errorf("Expected an error when creating a file in a non-existent directory, but got nil")
 	}
 }
+
+// TestMainInOutFlags checks the -in and -out flags used by go:generate directives
+func TestMainInOutFlags(t *testing.T) {
+	tempDir := t.TempDir()
+	inputPath := filepath.Join(tempDir, "logo.png")
+	writeCheckerboardPNG(t, inputPath, 8, 8)
+	outputPath := filepath.Join(tempDir, "logo_gen.go")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-in", inputPath, "-out", outputPath, "-width", "0", "-height", "0"}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read generated file: %v", err)
+	}
+	expected := generatedLine + "\n// Command: image2bytes -in=logo.png -out=logo_gen.go -width=0 -height=0 -resize=stretch"
+	if !strings.HasPrefix(string(content), expected) {
+		t.Errorf("Expected the file to start with %q, got:\n%s", expected, content)
+	}
+
+	// The flags and the arguments can be mixed, but not given twice
+	tests := [][]string{
+		{"-in", inputPath, outputPath},
+		{"-out", outputPath, inputPath},
+	}
+	for _, args := range tests {
+		stdout.Reset()
+		if code := run(args, &stdout, &stderr); code != exitOK {
+			t.Errorf("run(%q): expected exit code %d, got %d", args, exitOK, code)
+		}
+	}
+	stderr.Reset()
+	if code := run([]string{"-in", inputPath, "-out", outputPath, outputPath}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d for an extra argument, got %d", exitUsage, code)
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"image2bytes/bitmap"
+	"image2bytes/compress"
+)
+
+// manifest lists the images of a build, with options shared by all of them and
+// overrides per entry. It can be written in YAML, JSON or TOML.
+type manifest struct {
+	// Package is the package clause of the generated code; "main" when empty.
+	Package string `yaml:"package" json:"package" toml:"package"`
+	// Output is a Go file that gets every image, or a directory that gets one
+	// file per image, like the batch mode.
+	Output string `yaml:"output" json:"output" toml:"output"`
+	// Defaults apply to every entry.
+	Defaults manifestOptions `yaml:"defaults" json:"defaults" toml:"defaults"`
+	Assets   []manifestAsset `yaml:"assets" json:"assets" toml:"assets"`
+}
+
+// manifestAsset is one entry of a manifest: a PNG file, a directory or a glob.
+type manifestAsset struct {
+	Input string `yaml:"input" json:"input" toml:"input"`
+	// Name overrides the identifier derived from the file name. Entries that
+	// expand to several files cannot set it.
+	Name            string `yaml:"name" json:"name" toml:"name"`
+	manifestOptions `yaml:",inline"`
+}
+
+// manifestOptions are the conversion options of a manifest, named like the
+// command-line flags. Unset fields keep the value they override.
+type manifestOptions struct {
+	Profile          *string                `yaml:"profile" json:"profile" toml:"profile"`
+	Width            *int                   `yaml:"width" json:"width" toml:"width"`
+	Height           *int                   `yaml:"height" json:"height" toml:"height"`
+	Resize           *bitmap.ResizeMode     `yaml:"resize" json:"resize" toml:"resize"`
+	Scaler           *bitmap.Scaler         `yaml:"scaler" json:"scaler" toml:"scaler"`
+	Margins          *bitmap.Margins        `yaml:"margins" json:"margins" toml:"margins"`
+	ImageWidth       *int                   `yaml:"image-width" json:"image-width" toml:"image-width"`
+	ImageHeight      *int                   `yaml:"image-height" json:"image-height" toml:"image-height"`
+	Gravity          *bitmap.Gravity        `yaml:"gravity" json:"gravity" toml:"gravity"`
+	Offset           *bitmap.Point          `yaml:"offset" json:"offset" toml:"offset"`
+	Crop             *bitmap.Rect           `yaml:"crop" json:"crop" toml:"crop"`
+	Rotate           *int                   `yaml:"rotate" json:"rotate" toml:"rotate"`
+	Flip             *bitmap.Flip           `yaml:"flip" json:"flip" toml:"flip"`
+	TransformStage   *bitmap.TransformStage `yaml:"transform-stage" json:"transform-stage" toml:"transform-stage"`
+	Threshold        *int                   `yaml:"threshold" json:"threshold" toml:"threshold"`
+	Dither           *bitmap.Dither         `yaml:"dither" json:"dither" toml:"dither"`
+	Format           *bitmap.PixelFormat    `yaml:"format" json:"format" toml:"format"`
+	BitOrder         *bitmap.BitOrder       `yaml:"bit-order" json:"bit-order" toml:"bit-order"`
+	Polarity         *bitmap.Polarity       `yaml:"polarity" json:"polarity" toml:"polarity"`
+	Invert           *bool                  `yaml:"invert" json:"invert" toml:"invert"`
+	Matte            *bitmap.Color          `yaml:"matte" json:"matte" toml:"matte"`
+	Alpha            *bitmap.AlphaMode      `yaml:"alpha" json:"alpha" toml:"alpha"`
+	Luma             *bitmap.Luma           `yaml:"luma" json:"luma" toml:"luma"`
+	AutoLevels       *float64               `yaml:"auto-levels" json:"auto-levels" toml:"auto-levels"`
+	Equalize         *bitmap.Equalize       `yaml:"equalize" json:"equalize" toml:"equalize"`
+	Gamma            *float64               `yaml:"gamma" json:"gamma" toml:"gamma"`
+	Brightness       *float64               `yaml:"brightness" json:"brightness" toml:"brightness"`
+	Contrast         *float64               `yaml:"contrast" json:"contrast" toml:"contrast"`
+	Sharpen          *float64               `yaml:"sharpen" json:"sharpen" toml:"sharpen"`
+	SharpenRadius    *float64               `yaml:"sharpen-radius" json:"sharpen-radius" toml:"sharpen-radius"`
+	Edges            *bitmap.Edges          `yaml:"edges" json:"edges" toml:"edges"`
+	EdgeRadius       *float64               `yaml:"edge-radius" json:"edge-radius" toml:"edge-radius"`
+	Morphology       *bitmap.Morphology     `yaml:"morphology" json:"morphology" toml:"morphology"`
+	MorphologyRadius *int                   `yaml:"morphology-radius" json:"morphology-radius" toml:"morphology-radius"`
+	Codec            *string                `yaml:"codec" json:"codec" toml:"codec"`
+	Window           *int                   `yaml:"window" json:"window" toml:"window"`
+	Lookahead        *int                   `yaml:"lookahead" json:"lookahead" toml:"lookahead"`
+	Data             *bitmap.DataStyle      `yaml:"data" json:"data" toml:"data"`
+	Mask             *bool                  `yaml:"mask" json:"mask" toml:"mask"`
+	MaskThreshold    *int                   `yaml:"mask-threshold" json:"mask-threshold" toml:"mask-threshold"`
+}
+
+// apply overrides the fields of cfg that o sets. A display profile applies
+// first, so the other fields override it.
+func (o manifestOptions) apply(cfg *convertConfig) error {
+	var profile bitmap.Profile
+	if o.Profile != nil {
+		var err error
+		if profile, err = lookupProfile(*o.Profile); err != nil {
+			return err
+		}
+	}
+	if o.Threshold != nil && (*o.Threshold < 1 || *o.Threshold > 255) {
+		return fmt.Errorf("threshold %d is not between 1 and 255", *o.Threshold)
+	}
+	if o.Gamma != nil && !(*o.Gamma > 0) {
+		return fmt.Errorf("gamma %g is not above 0", *o.Gamma)
+	}
+	if o.MaskThreshold != nil && (*o.MaskThreshold < 1 || *o.MaskThreshold > 255) {
+		return fmt.Errorf("mask threshold %d is not between 1 and 255", *o.MaskThreshold)
+	}
+	var rotate bitmap.Rotation
+	if o.Rotate != nil {
+		if err := rotate.UnmarshalText([]byte(strconv.Itoa(*o.Rotate))); err != nil {
+			return err
+		}
+	}
+	if o.Codec != nil && *o.Codec != "auto" && !slices.Contains(compress.Names, *o.Codec) {
+		return fmt.Errorf("unknown codec %q", *o.Codec)
+	}
+
+	if o.Profile != nil {
+		profile.Apply(&cfg.options)
+	}
+	set(&cfg.options.Width, o.Width)
+	set(&cfg.options.Height, o.Height)
+	set(&cfg.options.Resize, o.Resize)
+	set(&cfg.options.Scaler, o.Scaler)
+	set(&cfg.options.Margins, o.Margins)
+	set(&cfg.options.ImageWidth, o.ImageWidth)
+	set(&cfg.options.ImageHeight, o.ImageHeight)
+	set(&cfg.options.Gravity, o.Gravity)
+	set(&cfg.options.Offset, o.Offset)
+	set(&cfg.options.Crop, o.Crop)
+	if o.Rotate != nil {
+		cfg.options.Rotate = rotate
+	}
+	set(&cfg.options.Flip, o.Flip)
+	set(&cfg.options.TransformStage, o.TransformStage)
+	if o.Threshold != nil {
+		cfg.options.Threshold = uint8(*o.Threshold)
+	}
+	set(&cfg.options.Dither, o.Dither)
+	set(&cfg.options.Format, o.Format)
+	set(&cfg.options.BitOrder, o.BitOrder)
+	set(&cfg.options.Polarity, o.Polarity)
+	set(&cfg.options.Invert, o.Invert)
+	set(&cfg.options.Matte, o.Matte)
+	set(&cfg.options.Alpha, o.Alpha)
+	set(&cfg.options.Luma, o.Luma)
+	set(&cfg.options.AutoLevels, o.AutoLevels)
+	set(&cfg.options.Equalize, o.Equalize)
+	set(&cfg.options.Gamma, o.Gamma)
+	set(&cfg.options.Brightness, o.Brightness)
+	set(&cfg.options.Contrast, o.Contrast)
+	set(&cfg.options.Sharpen, o.Sharpen)
+	set(&cfg.options.SharpenRadius, o.SharpenRadius)
+	set(&cfg.options.Edges, o.Edges)
+	set(&cfg.options.EdgeRadius, o.EdgeRadius)
+	set(&cfg.options.Morphology, o.Morphology)
+	set(&cfg.options.MorphologyRadius, o.MorphologyRadius)
+	set(&cfg.codec, o.Codec)
+	set(&cfg.params.Window, o.Window)
+	set(&cfg.params.Lookahead, o.Lookahead)
+	set(&cfg.data, o.Data)
+	set(&cfg.mask, o.Mask)
+	if o.MaskThreshold != nil {
+		cfg.maskThreshold = uint8(*o.MaskThreshold)
+	}
+	return nil
+}
+
+// set stores *v in dst when v is not nil.
+func set[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+// loadManifest reads a manifest, picking the format from the file extension.
+// Unknown keys are errors, so a typo cannot silently fall back to a default.
+func loadManifest(path string) (*manifest, error) {
+	var m manifest
+	if err := decodeFile(path, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// decodeFile decodes the YAML, JSON or TOML file at path into v, picking the
+// format from the file extension. Unknown keys are errors.
+func decodeFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), v)
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("unknown key %s", undecoded[0])
+		}
+	default:
+		return fmt.Errorf("unknown format %q (want .yaml, .yml, .json or .toml)", filepath.Ext(path))
+	}
+	return nil
+}
+
+// planManifest reads the manifest at path and expands its entries into files,
+// each with its own options. Relative paths in the manifest are relative to its
+// directory.
+func planManifest(path string) (*plan, error) {
+	m, err := loadManifest(path)
+	if err != nil {
+		return nil, &stageError{stage: "read", path: path, err: err}
+	}
+	invalid := func(err error) error {
+		return &stageError{stage: "read", path: path, err: err}
+	}
+
+	// Start from the command-line defaults
+	base := convertConfig{
+		options: bitmap.Options{Width: panelWidth, Height: panelHeight},
+		pkg:     "main",
+		codec:   "none",
+		params:  compress.DefaultParams,
+	}
+	if m.Package != "" {
+		base.pkg = m.Package
+	}
+	if !token.IsIdentifier(base.pkg) {
+		return nil, invalid(fmt.Errorf("invalid package name %q", base.pkg))
+	}
+	if m.Output == "" {
+		return nil, invalid(fmt.Errorf("missing output"))
+	}
+	if len(m.Assets) == 0 {
+		return nil, invalid(fmt.Errorf("no assets"))
+	}
+	start := base
+	if err := m.Defaults.apply(&base); err != nil {
+		return nil, invalid(fmt.Errorf("defaults: %w", err))
+	}
+	dir := filepath.Dir(path)
+	resolve := func(p string) string { return resolvePath(dir, p) }
+
+	// Expand the entries into files, each with its own options
+	p := &plan{output: resolve(m.Output), pkg: base.pkg, sources: []string{path}, manifest: path}
+	p.command = joinArgs([]string{"build", relPath(p.dir(), path)})
+	var paths, candidates []string
+	var explicit []bool
+	var cfgs []convertConfig
+	for i, entry := range m.Assets {
+		cfg := base
+		if entry.Profile != nil {
+			// The profile of an entry takes the place of that of defaults,
+			// and the other options of defaults still override it
+			cfg = start
+			defaults := m.Defaults
+			defaults.Profile = entry.Profile
+			if err := defaults.apply(&cfg); err != nil {
+				return nil, invalid(fmt.Errorf("asset %d: %w", i+1, err))
+			}
+			entry.Profile = nil
+		}
+		if err := entry.apply(&cfg); err != nil {
+			return nil, invalid(fmt.Errorf("asset %d: %w", i+1, err))
+		}
+		if entry.Input == "" {
+			return nil, invalid(fmt.Errorf("asset %d: missing input", i+1))
+		}
+		input := resolve(entry.Input)
+		files := []string{input}
+		if isBatchInput(input) {
+			p.sources = append(p.sources, patternDir(input))
+			if files, err = batchInputs(input); err != nil {
+				return nil, invalid(fmt.Errorf("asset %d: %w", i+1, err))
+			}
+		} else if !isPNGFile(input) {
+			return nil, invalid(fmt.Errorf("asset %d: %s is not a PNG file", i+1, entry.Input))
+		}
+		if entry.Name != "" && (len(files) > 1 || !token.IsIdentifier(entry.Name)) {
+			return nil, invalid(fmt.Errorf("asset %d: name %q needs a single input and a valid identifier", i+1, entry.Name))
+		}
+
+		for _, file := range files {
+			name := entry.Name
+			if name == "" {
+				name = identifierFromPath(file)
+			}
+			paths = append(paths, file)
+			candidates = append(candidates, name)
+			explicit = append(explicit, entry.Name != "")
+			cfgs = append(cfgs, cfg)
+		}
+	}
+	if err := checkKeys(paths); err != nil {
+		return nil, err
+	}
+	for i, name := range uniqueNames(candidates, cfgs) {
+		if explicit[i] && name != candidates[i] {
+			return nil, invalid(fmt.Errorf("name %s of %s is already taken", candidates[i], paths[i]))
+		}
+		p.entries = append(p.entries, planEntry{input: paths[i], name: name, cfg: cfgs[i]})
+	}
+	return p, nil
+}
+
+// buildCommand implements "image2bytes build", which regenerates everything a
+// manifest lists. It returns the exit code like run.
+func buildCommand(args []string, stdout, stderr io.Writer) int {
+	b, code := parseBuildArgs(args, "", stderr)
+	if b == nil {
+		return code
+	}
+	if b.watch.enabled {
+		return b.watch.watch(b.plan, []string{b.manifest}, stdout, stderr)
+	}
+	p, err := b.plan()
+	if err == nil {
+		err = p.build(stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// buildArgs is the parsed command line of build.
+type buildArgs struct {
+	manifest string
+	watch    watchOptions
+	noCache  bool
+}
+
+// parseBuildArgs parses the flags and the manifest argument of build, resolving
+// a relative manifest path against dir unless it is empty. Usage goes to
+// stderr; when there is nothing to build it returns nil and the exit code.
+func parseBuildArgs(args []string, dir string, stderr io.Writer) (*buildArgs, int) {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go run . build [flags] manifest.yaml|manifest.json|manifest.toml")
+		fs.PrintDefaults()
+	}
+	b := &buildArgs{}
+	b.watch.register(fs)
+	fs.BoolVar(&b.noCache, "no-cache", false, "convert every image again instead of reusing earlier conversions")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, exitOK
+		}
+		return nil, exitUsage
+	}
+	if fs.NArg() != 1 || fs.Arg(0) == "" {
+		fmt.Fprintln(stderr, "Usage: go run . build manifest.yaml|manifest.json|manifest.toml")
+		return nil, exitUsage
+	}
+	b.manifest = fs.Arg(0)
+	if dir != "" {
+		b.manifest = resolvePath(dir, b.manifest)
+	}
+	return b, exitOK
+}
+
+// plan plans the build of the manifest.
+func (b *buildArgs) plan() (*plan, error) {
+	p, err := planManifest(b.manifest)
+	if err != nil {
+		return nil, err
+	}
+	if !b.noCache {
+		p.cache = openCache()
+	}
+	return p, nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"image2bytes/bitmap"
+)
+
+// The same manifest in every supported format
+var testManifests = map[string]string{
+	"manifest.yaml": `
+package: assets
+output: out/assets.go
+defaults:
+  width: 0
+  height: 0
+  codec: heatshrink
+assets:
+  - input: logo.png
+    threshold: 100
+    crop: 12x8+2+0
+    rotate: 90
+  - input: photo.png
+    name: Photo
+    format: gray2
+    margins: 2,4
+    gravity: top-left
+    dither: atkinson
+  - input: icons/*.png
+    width: 4
+    height: 4
+    scaler: nearest
+    bit-order: lsb
+    codec: none
+    data: string
+`,
+	"manifest.json": `{
+  "package": "assets",
+  "output": "out/assets.go",
+  "defaults": {"width": 0, "height": 0, "codec": "heatshrink"},
+  "assets": [
+    {"input": "logo.png", "threshold": 100, "crop": "12x8+2+0", "rotate": 90},
+    {"input": "photo.png", "name": "Photo", "format": "gray2", "margins": "2,4", "gravity": "top-left", "dither": "atkinson"},
+    {"input": "icons/*.png", "width": 4, "height": 4, "scaler": "nearest", "bit-order": "lsb", "codec": "none", "data": "string"}
+  ]
+}`,
+	"manifest.toml": `
+package = "assets"
+output = "out/assets.go"
+
+[defaults]
+width = 0
+height = 0
+codec = "heatshrink"
+
+[[assets]]
+input = "logo.png"
+threshold = 100
+crop = "12x8+2+0"
+rotate = 90
+
+[[assets]]
+input = "photo.png"
+name = "Photo"
+format = "gray2"
+margins = "2,4"
+gravity = "top-left"
+dither = "atkinson"
+
+[[assets]]
+input = "icons/*.png"
+width = 4
+height = 4
+scaler = "nearest"
+bit-order = "lsb"
+codec = "none"
+data = "string"
+`,
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	var loaded []*manifest
+	for name, content := range testManifests {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+		m, err := loadManifest(path)
+		if err != nil {
+			t.Fatalf("loadManifest(%s) failed: %v", name, err)
+		}
+		loaded = append(loaded, m)
+	}
+
+	// Every format must describe the same build
+	for _, m := range loaded[1:] {
+		if !reflect.DeepEqual(m, loaded[0]) {
+			t.Errorf("Manifests differ: %+v and %+v", m, loaded[0])
+		}
+	}
+	m := loaded[0]
+	if len(m.Assets) != 3 || *m.Assets[1].Format != bitmap.Gray2 || *m.Assets[2].BitOrder != bitmap.LSBFirst || m.Defaults.Threshold != nil ||
+		m.Assets[0].Crop.String() != "12x8+2+0" || *m.Assets[0].Rotate != 90 ||
+		*m.Assets[1].Margins != (bitmap.Margins{Top: 2, Right: 4, Bottom: 2, Left: 4}) || *m.Assets[1].Gravity != bitmap.TopLeft {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+}
+
+func TestLoadManifestErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"typo.yaml":   "output: a.go\nasets: []\n",
+		"typo.json":   `{"output": "a.go", "assets": [{"input": "a.png", "thresold": 3}]}`,
+		"typo.toml":   "output = \"a.go\"\n[defaults]\nditer = \"atkinson\"\n",
+		"enum.yaml":   "defaults:\n  dither: sierra\n",
+		"format.conf": "output: a.go\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+		if _, err := loadManifest(path); err == nil {
+			t.Errorf("Expected an error for %s, got nil", name)
+		}
+	}
+}
+
+func TestManifestProfile(t *testing.T) {
+	// The profile applies first, so the other options of the entry override it
+	cfg := convertConfig{options: bitmap.Options{Width: panelWidth, Height: panelHeight, Dither: bitmap.Atkinson}}
+	profile, height := "waveshare-2in13", 61
+	if err := (manifestOptions{Profile: &profile, Height: &height}).apply(&cfg); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if expected := (bitmap.Options{Width: 250, Height: 61, Polarity: bitmap.HighWhite, Dither: bitmap.Atkinson}); cfg.options != expected {
+		t.Errorf("Expected %+v, got %+v", expected, cfg.options)
+	}
+
+	profile = "epd"
+	if err := (manifestOptions{Profile: &profile}).apply(&cfg); err == nil {
+		t.Errorf("Expected an error for an unknown profile, got nil")
+	}
+}
+
+func TestPlanManifestProfile(t *testing.T) {
+	// The profile of an entry applies before defaults, so they override it too
+	dir := t.TempDir()
+	for _, name := range []string{"a.png", "b.png", "c.png", "d.png"} {
+		writeCheckerboardPNG(t, filepath.Join(dir, name), 4, 4)
+	}
+	path := filepath.Join(dir, "assets.yaml")
+	content := `
+output: assets.go
+defaults:
+  width: 32
+  height: 16
+  dither: atkinson
+assets:
+  - input: a.png
+    profile: ssd1306
+  - input: b.png
+    profile: ssd1306
+    width: 64
+  - input: c.png
+  - input: d.png
+    profile: ssd1306
+    dither: none
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	p, err := planManifest(path)
+	if err != nil {
+		t.Fatalf("planManifest failed: %v", err)
+	}
+	expected := []bitmap.Options{
+		{Width: 32, Height: 16, Polarity: bitmap.HighWhite, Dither: bitmap.Atkinson},
+		{Width: 64, Height: 16, Polarity: bitmap.HighWhite, Dither: bitmap.Atkinson},
+		{Width: 32, Height: 16, Dither: bitmap.Atkinson},
+		{Width: 32, Height: 16, Polarity: bitmap.HighWhite},
+	}
+	for i, e := range p.entries {
+		if e.cfg.options != expected[i] {
+			t.Errorf("%s: expected %+v, got %+v", e.name, expected[i], e.cfg.options)
+		}
+	}
+
+	// The profile of an entry takes the place of that of defaults
+	content = "output: assets.go\ndefaults:\n  profile: badger2040\n  height: 100\nassets:\n  - input: a.png\n    profile: ssd1306\n  - input: b.png\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	if p, err = planManifest(path); err != nil {
+		t.Fatalf("planManifest failed: %v", err)
+	}
+	expected = []bitmap.Options{
+		{Width: 128, Height: 100, Polarity: bitmap.HighWhite},
+		{Width: 296, Height: 100, Polarity: bitmap.HighBlack},
+	}
+	for i, e := range p.entries {
+		if e.cfg.options != expected[i] {
+			t.Errorf("%s: expected %+v, got %+v", e.name, expected[i], e.cfg.options)
+		}
+	}
+}
+
+func TestBuildCommand(t *testing.T) {
+	dir := t.TempDir()
+	writeCheckerboardPNG(t, filepath.Join(dir, "logo.png"), 16, 8)
+	writeCheckerboardPNG(t, filepath.Join(dir, "photo.png"), 8, 8)
+	if err := os.Mkdir(filepath.Join(dir, "icons"), 0o755); err != nil {
+		t.Fatalf("Failed to create icons dir: %v", err)
+	}
+	writeCheckerboardPNG(t, filepath.Join(dir, "icons", "arrow.png"), 32, 32)
+	writeCheckerboardPNG(t, filepath.Join(dir, "icons", "home.png"), 32, 32)
+	manifestPath := filepath.Join(dir, "manifest.yaml")
+	if err := os.WriteFile(manifestPath, []byte(testManifests["manifest.yaml"]), 0o644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build", manifestPath}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	outputPath := filepath.Join(dir, "out", "assets.go")
+	typeCheck(t, outputPath)
+
+	// Each entry keeps its own options
+	tests := []struct {
+		name          string
+		width, height int
+		format        bitmap.PixelFormat
+		order         bitmap.BitOrder
+	}{
+		// Cropped to 12x8 and turned
+		{name: "Logo", width: 8, height: 12},
+		// Framed by margins of 2 and 4
+		{name: "Photo", width: 16, height: 12, format: bitmap.Gray2},
+		{name: "Arrow", width: 4, height: 4, order: bitmap.LSBFirst},
+		{name: "Home", width: 4, height: 4, order: bitmap.LSBFirst},
+	}
+	for _, tt := range tests {
+		bm, err := loadBitmap(outputPath, decodeOptions{name: tt.name})
+		if err != nil {
+			t.Fatalf("loadBitmap(%s) failed: %v", tt.name, err)
+		}
+		if bm.Width != tt.width || bm.Height != tt.height || bm.Format != tt.format || bm.BitOrder != tt.order {
+			t.Errorf("%s: unexpected bitmap %dx%d %s %s", tt.name, bm.Width, bm.Height, bm.Format, bm.BitOrder)
+		}
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read generated file: %v", err)
+	}
+	if !strings.Contains(string(content), "const LogoCodec = \"heatshrink\"") || strings.Contains(string(content), "ArrowCodec") {
+		t.Errorf("Expected the icons to override the default codec")
+	}
+	if !strings.Contains(string(content), "var Arrow = []byte(\"") || !strings.Contains(string(content), "var Logo = []byte{\n") {
+		t.Errorf("Expected only the icons as string literals")
+	}
+}
+
+func TestBuildCommandErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeCheckerboardPNG(t, filepath.Join(dir, "a.png"), 2, 2)
+	writeCheckerboardPNG(t, filepath.Join(dir, "b.png"), 2, 2)
+
+	for name, content := range map[string]string{
+		"no-output.yaml":   "assets:\n  - input: a.png\n",
+		"bad-package.yaml": "package: my-assets\noutput: a.go\nassets:\n  - input: a.png\n",
+		"threshold.yaml":   "output: a.go\nassets:\n  - input: a.png\n    threshold: 300\n",
+		"codec.yaml":       "output: a.go\ndefaults:\n  codec: zip\nassets:\n  - input: a.png\n",
+		"rotate.yaml":      "output: a.go\nassets:\n  - input: a.png\n    rotate: 45\n",
+		"gamma.yaml":       "output: a.go\nassets:\n  - input: a.png\n    gamma: 0\n",
+		"crop.yaml":        "output: a.go\nassets:\n  - input: a.png\n    crop: 4x4+1+1\n",
+		"glob-name.yaml":   "output: a.go\nassets:\n  - input: '*.png'\n    name: Both\n",
+		"taken.yaml":       "output: a.go\nassets:\n  - input: a.png\n  - input: b.png\n    name: A\n",
+		"missing.yaml":     "output: a.go\nassets:\n  - input: c.png\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"build", path}, &stdout, &stderr); code != exitError {
+			t.Errorf("%s: expected exit code %d, got %d", name, exitError, code)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build"}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d without a manifest, got %d", exitUsage, code)
+	}
+}
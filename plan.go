@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"image2bytes/bitmap"
+)
+
+// planEntry is one image to convert: the input file, the identifier it gets in
+// the generated code, and its options. A layout entry draws its image instead.
+type planEntry struct {
+	input string
+	name  string
+	cfg   convertConfig
+	// layout is the screen to draw when input is a layout rather than an image
+	layout *layout
+}
+
+// plan is the work of one run: every image to convert and where the generated
+// code goes. A single image is written like the original command, with no
+// index; batches and manifests are written by writeAssets.
+type plan struct {
+	entries []planEntry
+	output  string
+	pkg     string
+	single  bool
+	// sources are the files and directories the entries were derived from,
+	// such as the manifest or the directory a glob lists. When they change the
+	// plan must be made again.
+	sources []string
+	// manifest is the manifest of a build, which every generated file depends on
+	manifest string
+	// command regenerates the output, as recorded in the header of every file
+	command string
+	// cache holds earlier conversions; nil converts everything again
+	cache *buildCache
+}
+
+// singlePlan converts inputPath into outputPath, naming the array after the output file.
+func singlePlan(inputPath, outputPath string, cfg convertConfig) *plan {
+	entry := planEntry{input: inputPath, name: identifierFromPath(outputPath), cfg: cfg}
+	p := &plan{entries: []planEntry{entry}, output: outputPath, pkg: cfg.pkg, single: true}
+	p.command = p.convertCommand(inputPath, cfg)
+	return p
+}
+
+// convertCommand returns the canonical command line converting input into the
+// output of the plan with cfg.
+func (p *plan) convertCommand(input string, cfg convertConfig) string {
+	args := []string{"-in=" + relPath(p.dir(), input), "-out=" + relPath(p.dir(), p.output)}
+	if cfg.text.text != "" {
+		args = append(cfg.text.args(p.dir()), "-out="+relPath(p.dir(), p.output))
+	}
+	return joinArgs(append(args, optionArgs(cfg)...))
+}
+
+// dir returns the directory the generated files go to.
+func (p *plan) dir() string {
+	if p.single || isGoFile(p.output) {
+		return filepath.Dir(p.output)
+	}
+	return p.output
+}
+
+// mainOutput returns the generated file whose header lists every source: the
+// output file, or the index of a directory.
+func (p *plan) mainOutput() string {
+	if p.single || isGoFile(p.output) {
+		return p.output
+	}
+	return filepath.Join(p.output, indexFileName)
+}
+
+// header returns the header of a file generated from entries, hashing them,
+// the images of their layouts and the manifest, if any.
+func (p *plan) header(entries []planEntry) (genHeader, error) {
+	paths := make([]string, 0, len(entries)+1)
+	if p.manifest != "" {
+		paths = append(paths, p.manifest)
+	}
+	for _, e := range entries {
+		// Text without a font file has no input
+		if e.input != "" {
+			paths = append(paths, e.input)
+		}
+		if e.layout != nil {
+			paths = append(paths, e.layout.inputs()...)
+		}
+	}
+
+	h := genHeader{command: p.command}
+	for _, path := range paths {
+		sum, err := hashFile(path)
+		if err != nil {
+			return genHeader{}, &stageError{stage: "read", path: path, err: err}
+		}
+		h.sources = append(h.sources, genSource{path: relPath(p.dir(), path), sum: sum})
+	}
+	return h, nil
+}
+
+// build converts every entry and writes the output.
+func (p *plan) build(stdout io.Writer) error {
+	assets := make([]bitmap.Asset, len(p.entries))
+	for i, e := range p.entries {
+		var err error
+		if assets[i], err = p.convert(e, stdout); err != nil {
+			return err
+		}
+	}
+	return p.write(assets, stdout)
+}
+
+// convert converts one entry and writes its panel preview: next to the output
+// for a single image, or named after the asset in the output directory.
+func (p *plan) convert(e planEntry, stdout io.Writer) (bitmap.Asset, error) {
+	if !p.single {
+		return convertBatchAsset(e.input, e.name, p.output, e.cfg, p.cache, stdout)
+	}
+	var asset bitmap.Asset
+	var err error
+	switch {
+	case e.layout != nil:
+		asset, err = composeAsset(e.input, e.layout, e.name, e.cfg, stdout)
+	case e.cfg.text.text != "":
+		asset, err = textAsset(e.name, e.cfg, stdout)
+	default:
+		asset, err = convertAsset(e.input, e.name, e.cfg, p.cache, stdout)
+	}
+	if err != nil {
+		return bitmap.Asset{}, err
+	}
+	return asset, writePanelPreview(previewPNGPath(p.output), asset.Bitmap, e.cfg, stdout)
+}
+
+// write writes the converted entries, in the same order, to the output.
+func (p *plan) write(assets []bitmap.Asset, stdout io.Writer) error {
+	if !p.single {
+		return p.writeAssets(assets, stdout)
+	}
+
+	// Generate the output Go file
+	h, err := p.header(p.entries)
+	if err != nil {
+		return err
+	}
+	a := assets[0]
+	err = generateGoFile(p.output, h, a.Bitmap, bitmap.GoOptions{Package: p.pkg, Name: a.Name, Compression: a.Compression, Data: a.Data, Mask: a.Mask})
+	if err != nil {
+		return &stageError{stage: "write", path: p.output, err: err}
+	}
+
+	// Print a success message
+	fmt.Fprintf(stdout, "Done. Bytes written to %s\n", p.output)
+	return nil
+}
+
+// patternDir returns the directory whose listing a batch input depends on:
+// the directory itself, or the one a glob matches files in.
+func patternDir(pattern string) string {
+	if hasMeta(pattern) {
+		return filepath.Dir(pattern)
+	}
+	return pattern
+}
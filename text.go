@@ -0,0 +1,121 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"image"
+	"io"
+	"os"
+	"strconv"
+
+	"golang.org/x/image/draw"
+
+	"image2bytes/bitmap"
+)
+
+// textConfig is text the command line renders instead of reading an image.
+type textConfig struct {
+	text string
+	// font is a TrueType or OpenType file; empty uses the built-in pixel font
+	font string
+	opts bitmap.TextOptions
+}
+
+// register adds the -text flags to fs.
+func (t *textConfig) register(fs *flag.FlagSet) {
+	fs.StringVar(&t.text, "text", "", "render this text instead of reading an image, wrapped to the width of the bitmap")
+	fs.StringVar(&t.font, "font", "", "TrueType or OpenType `file` to render -text with (default a built-in 7x13 pixel font)")
+	fs.Float64Var(&t.opts.Size, "font-size", 0, "height of the -font in pixels (default 16)")
+	fs.TextVar(&t.opts.Hinting, "hinting", t.opts.Hinting, "snap the -font to the pixel grid: none, vertical or full")
+	fs.TextVar(&t.opts.Align, "align", t.opts.Align, "alignment of the lines of -text: left, center or right")
+	fs.BoolVar(&t.opts.NoAntialias, "no-antialias", false, "draw -text as pure ink and paper, so it stays crisp in mono")
+}
+
+// args returns the flags that reproduce t, with the font relative to dir, in a
+// fixed order and leaving out the defaults.
+func (t textConfig) args(dir string) []string {
+	args := []string{"-text=" + t.text}
+	if t.font != "" {
+		args = append(args, "-font="+relPath(dir, t.font))
+	}
+	if t.opts.Size != 0 {
+		args = append(args, "-font-size="+strconv.FormatFloat(t.opts.Size, 'g', -1, 64))
+	}
+	if t.opts.Hinting != bitmap.NoHinting {
+		args = append(args, "-hinting="+t.opts.Hinting.String())
+	}
+	if t.opts.Align != bitmap.AlignLeft {
+		args = append(args, "-align="+t.opts.Align.String())
+	}
+	if t.opts.NoAntialias {
+		args = append(args, "-no-antialias")
+	}
+	return args
+}
+
+// render draws the text in black on a transparent image, so the matte shows
+// around the glyphs. Lines wrap at the width the image gets in the bitmap:
+// ImageWidth, or Width inside the margins, turned with a quarter turn of the
+// source.
+func (t textConfig) render(opts bitmap.Options) (*image.RGBA, error) {
+	width, imageWidth, margins := opts.Width, opts.ImageWidth, opts.Margins.Left+opts.Margins.Right
+	if opts.TransformStage == bitmap.TransformSource && (opts.Rotate == bitmap.Rotate90 || opts.Rotate == bitmap.Rotate270) {
+		width, imageWidth, margins = opts.Height, opts.ImageHeight, opts.Margins.Top+opts.Margins.Bottom
+	}
+	to := t.opts
+	switch {
+	case imageWidth > 0:
+		to.Width = imageWidth
+	case width > margins:
+		to.Width = width - margins
+	}
+	mask, err := renderText(t.text, t.font, to)
+	if err != nil {
+		return nil, err
+	}
+	dst := image.NewRGBA(mask.Bounds())
+	draw.DrawMask(dst, dst.Bounds(), image.Black, image.Point{}, mask, image.Point{}, draw.Over)
+	return dst, nil
+}
+
+// renderText renders text with the font file at path, or the built-in pixel
+// font when path is empty, into an alpha mask.
+func renderText(text, path string, opts bitmap.TextOptions) (*image.Alpha, error) {
+	var data []byte
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, &stageError{stage: "read", path: path, err: err}
+		}
+	}
+	face, err := bitmap.NewFace(data, opts)
+	if err != nil {
+		return nil, &stageError{stage: "decode", path: cmp.Or(path, "text"), err: err}
+	}
+	mask, err := bitmap.RenderText(text, face, opts)
+	if err != nil {
+		return nil, &stageError{stage: "pack", path: cmp.Or(path, "text"), err: err}
+	}
+	return mask, nil
+}
+
+// textAsset renders the text of cfg and converts it like convertAsset
+// converts an image.
+func textAsset(name string, cfg convertConfig, stdout io.Writer) (bitmap.Asset, error) {
+	source := cmp.Or(cfg.text.font, "text")
+	img, err := cfg.text.render(cfg.options)
+	if err != nil {
+		return bitmap.Asset{}, err
+	}
+	bm, err := bitmap.Convert(img, cfg.options)
+	if err != nil {
+		return bitmap.Asset{}, convertError(source, err)
+	}
+	var mask *bitmap.Bitmap
+	if cfg.mask {
+		if mask, err = bitmap.ConvertMask(img, cfg.options, cfg.maskThreshold); err != nil {
+			return bitmap.Asset{}, convertError(source, err)
+		}
+	}
+	return packAsset(source, name, bm, mask, "", cfg, stdout)
+}
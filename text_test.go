@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestTextCommand(t *testing.T) {
+	dir := t.TempDir()
+	fontPath := filepath.Join(dir, "go.ttf")
+	if err := os.WriteFile(fontPath, goregular.TTF, 0o644); err != nil {
+		t.Fatalf("Failed to write font: %v", err)
+	}
+	outputPath := filepath.Join(dir, "label.go")
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-text", "Hello world", "-font", fontPath, "-font-size", "20", "-align", "center", "-no-antialias", "-width", "64", "-height", "48", outputPath}
+	if code := run(args, &stdout, &stderr); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read generated file: %v", err)
+	}
+	expected := "// Command: image2bytes \"-text=Hello world\" -font=go.ttf -font-size=20 -align=center -no-antialias -out=label.go"
+	if !strings.Contains(string(content), expected) {
+		t.Errorf("Expected the file to contain %q, got:\n%s", expected, content)
+	}
+	typeCheck(t, outputPath)
+	bm, err := loadBitmap(outputPath, decodeOptions{name: "Label"})
+	if err != nil {
+		t.Fatalf("loadBitmap failed: %v", err)
+	}
+	if bm.Width != 64 || bm.Height != 48 {
+		t.Fatalf("Expected a 64x48 bitmap, got %dx%d", bm.Width, bm.Height)
+	}
+
+	// The words wrap onto two lines, centered on the paper
+	rows := map[int]bool{}
+	for y := 0; y < bm.Height; y++ {
+		for x := 0; x < bm.Width; x++ {
+			if bm.Gray(x, y) < 0x80 {
+				rows[y/24] = true
+			}
+		}
+	}
+	if !rows[0] || !rows[1] {
+		t.Errorf("Expected ink on two lines, got %v", rows)
+	}
+	for y := 0; y < bm.Height; y++ {
+		if bm.Gray(0, y) < 0x80 || bm.Gray(bm.Width-1, y) < 0x80 {
+			t.Errorf("Expected paper at the sides of row %d", y)
+		}
+	}
+
+	// The header records the font, so check sees it change
+	if code := run([]string{"check", outputPath}, &stdout, &stderr); code != exitOK {
+		t.Errorf("Expected the text to be up to date, got %s", stdout.String())
+	}
+	if err := os.WriteFile(fontPath, gobold.TTF, 0o644); err != nil {
+		t.Fatalf("Failed to write font: %v", err)
+	}
+	stdout.Reset()
+	if code := run([]string{"check", outputPath}, &stdout, &stderr); code != exitError || !strings.Contains(stdout.String(), "go.ttf changed") {
+		t.Errorf("Expected the text to be stale after its font changed, got %s", stdout.String())
+	}
+}
+
+func TestTextCommandErrors(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "a.png")
+	writeCheckerboardPNG(t, inputPath, 2, 2)
+	badFont := filepath.Join(dir, "bad.ttf")
+	if err := os.WriteFile(badFont, []byte("not a font"), 0o644); err != nil {
+		t.Fatalf("Failed to write font: %v", err)
+	}
+	outputPath := filepath.Join(dir, "out.go")
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{name: "input and text", args: []string{"-text", "A", inputPath, outputPath}, code: exitUsage},
+		{name: "negative size", args: []string{"-text", "A", "-font-size", "-2", outputPath}, code: exitUsage},
+		{name: "unknown alignment", args: []string{"-text", "A", "-align", "justify", outputPath}, code: exitUsage},
+		{name: "missing font", args: []string{"-text", "A", "-font", filepath.Join(dir, "missing.ttf"), outputPath}, code: exitError},
+		{name: "invalid font", args: []string{"-text", "A", "-font", badFont, outputPath}, code: exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tt.args, &stdout, &stderr); code != tt.code {
+				t.Errorf("Expected exit code %d, got %d: %s", tt.code, code, stderr.String())
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"path/filepath"
 	"strings"
 	"unicode"
 )
@@ -38,3 +39,21 @@ func isPNGFile(path string) bool {
 func isGoFile(path string) bool {
 	return strings.HasSuffix(strings.ToLower(path), ".go")
 }
+
+// identifierFromPath derives an exported Go identifier from a file name,
+// e.g. "assets/test_output.go" becomes "TestOutput".
+func identifierFromPath(path string) string {
+	base := filepath.Base(path)
+	name := strings.ReplaceAll(titleCase(strings.TrimSuffix(base, filepath.Ext(base))), " ", "")
+	// Identifiers must start with a letter
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "Image" + name
+	}
+	return name
+}
+
+// previewPNGPath returns the preview file name for an output file,
+// e.g. "assets/logo.go" becomes "assets/logo.preview.png".
+func previewPNGPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".preview.png"
+}
@@ -147,3 +147,42 @@ func TestIsGoFile(t *testing.T) {
 		})
 	}
 }
+
+func TestIdentifierFromPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "Simple file",
+			path:     "output.go",
+			expected: "Output",
+		},
+		{
+			name:     "Path with directory and underscores",
+			path:     "/path/to/test_output.go",
+			expected: "TestOutput",
+		},
+		{
+			name:     "Leading digit",
+			path:     "2040.go",
+			expected: "Image2040",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := identifierFromPath(tt.path)
+			if result != tt.expected {
+				t.Errorf("identifierFromPath(%q) = %q, want %q", tt.path, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPreviewPNGPath(t *testing.T) {
+	if got := previewPNGPath("assets/logo.go"); got != "assets/logo.preview.png" {
+		t.Errorf("Expected assets/logo.preview.png, got %s", got)
+	}
+}
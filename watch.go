@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	"image2bytes/bitmap"
+)
+
+// fileStamp identifies a version of a file by its size and modification time.
+// Missing files have a size of -1.
+type fileStamp struct {
+	size int64
+	mod  time.Time
+}
+
+// stampOf returns the current stamp of path.
+func stampOf(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{size: -1}
+	}
+	return fileStamp{size: info.Size(), mod: info.ModTime()}
+}
+
+// watchedAsset is the last conversion of a plan entry and the stamp its input had.
+type watchedAsset struct {
+	asset bitmap.Asset
+	stamp fileStamp
+}
+
+// watcher polls the files a plan depends on and rebuilds it when they change.
+// Only entries whose input changed are converted again, and writeFile leaves
+// outputs with unchanged content alone, so only the affected outputs are rewritten.
+type watcher struct {
+	// plan makes the plan again, e.g. after the manifest was edited
+	plan func() (*plan, error)
+	// roots are watched even when planning fails, such as the manifest itself
+	roots    []string
+	interval time.Duration
+	stdout   io.Writer
+	stderr   io.Writer
+
+	current   *plan
+	converted map[planEntry]watchedAsset
+	// previous holds the last bitmap of every input, for the diff summary
+	previous map[string]*bitmap.Bitmap
+}
+
+// newWatcher returns a watcher that polls every interval.
+func newWatcher(planFunc func() (*plan, error), roots []string, interval time.Duration, stdout, stderr io.Writer) *watcher {
+	return &watcher{
+		plan:      planFunc,
+		roots:     roots,
+		interval:  interval,
+		stdout:    stdout,
+		stderr:    stderr,
+		converted: map[planEntry]watchedAsset{},
+		previous:  map[string]*bitmap.Bitmap{},
+	}
+}
+
+// run builds everything once, then rebuilds after every change until ctx is
+// done. A change is built once the files have been quiet for a whole interval,
+// so an editor saving in several steps triggers a single rebuild. Errors are
+// printed and watching goes on.
+func (w *watcher) run(ctx context.Context) {
+	w.rebuild(true, true)
+	built := w.stamps()
+	last := built
+	fmt.Fprintf(w.stdout, "Watching for changes...\n")
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := w.stamps()
+		if !maps.Equal(now, last) {
+			// Still changing: wait for a quiet interval
+			last = now
+			continue
+		}
+		if maps.Equal(now, built) {
+			continue
+		}
+
+		// Plan again when what the plan was derived from changed
+		replan := w.current == nil
+		for _, path := range w.sources() {
+			replan = replan || now[path] != built[path]
+		}
+		w.rebuild(replan, false)
+		// Compare with the stamps seen before the rebuild, so a change made
+		// while converting is picked up by the next poll
+		built = now
+	}
+}
+
+// sources returns the files whose change means planning again.
+func (w *watcher) sources() []string {
+	if w.current == nil {
+		return w.roots
+	}
+	return append(w.roots[:len(w.roots):len(w.roots)], w.current.sources...)
+}
+
+// stamps returns the stamps of the sources and of every input of the plan.
+func (w *watcher) stamps() map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	for _, path := range w.sources() {
+		stamps[path] = stampOf(path)
+	}
+	if w.current != nil {
+		for _, e := range w.current.entries {
+			stamps[e.input] = stampOf(e.input)
+		}
+	}
+	return stamps
+}
+
+// rebuild converts the entries whose input changed and writes the output. The
+// first build prints the usual progress; later ones print a diff summary per
+// converted image, and the terminal preview when it is enabled.
+func (w *watcher) rebuild(replan, initial bool) {
+	if replan {
+		p, err := w.plan()
+		if err != nil {
+			fmt.Fprintf(w.stderr, "Error: %v\n", err)
+			w.current = nil
+			return
+		}
+		w.current = p
+	}
+
+	progress := io.Discard
+	if initial {
+		progress = w.stdout
+	}
+	converted := map[planEntry]watchedAsset{}
+	assets := make([]bitmap.Asset, len(w.current.entries))
+	changed := 0
+	for i, e := range w.current.entries {
+		stamp := stampOf(e.input)
+		if c, ok := w.converted[e]; ok && c.stamp == stamp {
+			assets[i], converted[e] = c.asset, c
+			continue
+		}
+		out := progress
+		if e.cfg.preview != "" {
+			out = w.stdout
+		}
+		a, err := w.current.convert(e, out)
+		if err != nil {
+			fmt.Fprintf(w.stderr, "Error: %v\n", err)
+			return
+		}
+		assets[i], converted[e] = a, watchedAsset{asset: a, stamp: stamp}
+		if !initial {
+			fmt.Fprintln(w.stdout, diffSummary(e.input, w.previous[e.input], a.Bitmap))
+		}
+		w.previous[e.input] = a.Bitmap
+		changed++
+	}
+	// Forget entries that are no longer planned
+	w.converted = converted
+
+	if err := w.current.write(assets, progress); err != nil {
+		fmt.Fprintf(w.stderr, "Error: %v\n", err)
+		return
+	}
+	if !initial && changed > 0 {
+		fmt.Fprintf(w.stdout, "Regenerated %s\n", w.current.output)
+	}
+}
+
+// diffSummary describes how an image changed since its last conversion,
+// e.g. "logo.png: 12 bytes changed, 40 pixels flipped".
+func diffSummary(input string, old, cur *bitmap.Bitmap) string {
+	name := filepath.Base(input)
+	if old == nil {
+		return fmt.Sprintf("%s: added, %dx%d %s", name, cur.Width, cur.Height, cur.Format)
+	}
+	if old.Width != cur.Width || old.Height != cur.Height || old.Format != cur.Format || old.BitOrder != cur.BitOrder {
+		return fmt.Sprintf("%s: %dx%d %s %s -> %dx%d %s %s", name,
+			old.Width, old.Height, old.Format, old.BitOrder, cur.Width, cur.Height, cur.Format, cur.BitOrder)
+	}
+
+	bytesChanged := 0
+	for i := range cur.Data {
+		if old.Data[i] != cur.Data[i] {
+			bytesChanged++
+		}
+	}
+	pixelsFlipped := 0
+	for y := 0; y < cur.Height; y++ {
+		for x := 0; x < cur.Width; x++ {
+			if old.Value(x, y) != cur.Value(x, y) {
+				pixelsFlipped++
+			}
+		}
+	}
+	return fmt.Sprintf("%s: %d bytes changed, %d pixels flipped", name, bytesChanged, pixelsFlipped)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"image2bytes/bitmap"
+)
+
+// syncBuffer is a bytes.Buffer safe to read while the watcher writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor polls the buffer until it contains want n times
+func waitFor(t *testing.T, b *syncBuffer, want string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(b.String(), want) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %q, got:\n%s", want, b.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// touch moves the mtime of path forward, so the change is seen even on file
+// systems with coarse timestamps
+func touch(t *testing.T, path string, d time.Duration) {
+	t.Helper()
+	mod := time.Now().Add(d)
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+}
+
+func TestDiffSummary(t *testing.T) {
+	old, _ := bitmap.New(8, 2, bitmap.Mono1, bitmap.MSBFirst, []byte{0xF0, 0x00})
+	cur, _ := bitmap.New(8, 2, bitmap.Mono1, bitmap.MSBFirst, []byte{0x0F, 0x00})
+	bigger, _ := bitmap.New(8, 4, bitmap.Mono1, bitmap.MSBFirst, make([]byte, 4))
+
+	tests := []struct {
+		old, cur *bitmap.Bitmap
+		expected string
+	}{
+		{old: old, cur: cur, expected: "logo.png: 1 bytes changed, 8 pixels flipped"},
+		{old: old, cur: old, expected: "logo.png: 0 bytes changed, 0 pixels flipped"},
+		{old: old, cur: bigger, expected: "logo.png: 8x2 mono msb -> 8x4 mono msb"},
+		{old: nil, cur: cur, expected: "logo.png: added, 8x2 mono"},
+	}
+	for _, tt := range tests {
+		if got := diffSummary("icons/logo.png", tt.old, tt.cur); got != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, got)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	inputDir := t.TempDir()
+	arrowPath := filepath.Join(inputDir, "arrow.png")
+	homePath := filepath.Join(inputDir, "home.png")
+	writeCheckerboardPNG(t, arrowPath, 8, 8)
+	writeCheckerboardPNG(t, homePath, 8, 8)
+	outputDir := filepath.Join(t.TempDir(), "icons")
+
+	cfg := convertConfig{options: bitmap.Options{}, pkg: "icons", codec: "none"}
+	planFunc := func() (*plan, error) { return planBatch(inputDir, outputDir, cfg) }
+	var stdout, stderr syncBuffer
+	w := newWatcher(planFunc, []string{inputDir}, 10*time.Millisecond, &stdout, &stderr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitFor(t, &stdout, "Watching for changes...", 1)
+
+	homeGo := filepath.Join(outputDir, "home.go")
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(homeGo, past, past); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+
+	// Change one image: only it is converted again
+	writeCheckerboardPNG(t, arrowPath, 8, 7)
+	touch(t, arrowPath, time.Second)
+	waitFor(t, &stdout, "arrow.png: 8x8 mono msb -> 8x7 mono msb", 1)
+	waitFor(t, &stdout, "Regenerated "+outputDir, 1)
+	_, rebuilt, _ := strings.Cut(stdout.String(), "Watching for changes...")
+	if strings.Contains(rebuilt, "home.png") {
+		t.Errorf("Expected home.png to be left alone, got:\n%s", stdout.String())
+	}
+	if info, err := os.Stat(homeGo); err != nil || !info.ModTime().Equal(past) {
+		t.Errorf("Expected home.go not to be rewritten")
+	}
+
+	// Adding an image plans the batch again
+	writeCheckerboardPNG(t, filepath.Join(inputDir, "menu.png"), 4, 4)
+	touch(t, inputDir, 2*time.Second)
+	waitFor(t, &stdout, "menu.png: added, 4x4 mono", 1)
+	waitFor(t, &stdout, "Regenerated "+outputDir, 2)
+	if _, err := os.Stat(filepath.Join(outputDir, "menu.go")); err != nil {
+		t.Errorf("Expected menu.go to be written: %v", err)
+	}
+
+	// Errors are reported and watching goes on
+	if err := os.WriteFile(homePath, []byte("not a png"), 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	touch(t, homePath, 3*time.Second)
+	waitFor(t, &stderr, "Error: decode "+homePath, 1)
+	writeCheckerboardPNG(t, homePath, 8, 8)
+	touch(t, homePath, 4*time.Second)
+	waitFor(t, &stdout, "home.png: 0 bytes changed, 0 pixels flipped", 1)
+}